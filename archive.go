@@ -0,0 +1,98 @@
+package main
+
+import (
+	"TinyDocker/container"
+	"TinyDocker/image"
+	"TinyDocker/storage"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"os"
+)
+
+// 打开输出文件，output为空或者-时写到标准输出
+// 写到标准输出时日志改为输出到标准错误，避免混入tar流
+func openOutput(output string) (io.WriteCloser, error) {
+	if output == "" || output == "-" {
+		log.SetOutput(os.Stderr)
+		return os.Stdout, nil
+	}
+	return os.Create(output)
+}
+
+// 打开输入文件，input为空或者-时从标准输入读取
+func openInput(input string) (io.ReadCloser, error) {
+	if input == "" || input == "-" {
+		log.SetOutput(os.Stderr)
+		return os.Stdin, nil
+	}
+	return os.Open(input)
+}
+
+// 把镜像保存为tar格式的archive
+func saveImages(refs []string, output string) error {
+	w, err := openOutput(output)
+	if err != nil {
+		return err
+	}
+	if err := image.Save(refs, w); err != nil {
+		w.Close()
+		if w != os.Stdout {
+			os.Remove(output)
+		}
+		return fmt.Errorf("save images error %v", err)
+	}
+	return w.Close()
+}
+
+// 导入save生成的archive中的所有镜像
+func loadImages(input string) error {
+	r, err := openInput(input)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	loaded, err := image.Load(r, storage.DefaultDriver)
+	for _, ref := range loaded {
+		fmt.Fprintf(os.Stderr, "Loaded image: %s\n", ref)
+	}
+	if err != nil {
+		return fmt.Errorf("load images error %v", err)
+	}
+	return nil
+}
+
+// 把容器挂载后的rootfs导出为tar
+func exportContainer(containerName, output string) error {
+	if _, err := getContainerInfoByName(containerName); err != nil {
+		return fmt.Errorf("get container %s info error %v", containerName, err)
+	}
+	mntURL := fmt.Sprintf(container.MntUrl, containerName)
+	if exist, _ := container.PathExists(mntURL); !exist {
+		return fmt.Errorf("rootfs of container %s is not mounted", containerName)
+	}
+	w, err := openOutput(output)
+	if err != nil {
+		return err
+	}
+	//合并后的rootfs中没有whiteout，不需要存储驱动转换
+	if err := image.WriteDiff(mntURL, nil, w); err != nil {
+		w.Close()
+		return fmt.Errorf("export container %s error %v", containerName, err)
+	}
+	return w.Close()
+}
+
+// 把rootfs的tar包导入为单层镜像，input为-时从标准输入读取
+func importRootfs(input, imageName string) error {
+	r, err := openInput(input)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	if err := image.ImportRootfs(r, imageName, storage.DefaultDriver); err != nil {
+		return fmt.Errorf("import %s error %v", input, err)
+	}
+	fmt.Println(imageName)
+	return nil
+}
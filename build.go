@@ -0,0 +1,478 @@
+package main
+
+import (
+	"TinyDocker/container"
+	"TinyDocker/image"
+	"TinyDocker/storage"
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// 构建文件中的一条指令
+type buildInstruction struct {
+	Command string //指令名，如 RUN COPY
+	Args    string //指令的参数
+	Line    int    //指令在文件中的行号
+}
+
+// 读取构建文件，去掉注释和空行，并把以\\结尾的行和下一行合并
+func parseBuildFile(buildFile string) ([]buildInstruction, error) {
+	file, err := os.Open(buildFile)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var instructions []buildInstruction
+	var current string
+	startLine := 0
+	scanner := bufio.NewScanner(file)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if current == "" && (line == "" || strings.HasPrefix(line, "#")) {
+			continue
+		}
+		if current == "" {
+			startLine = lineNo
+		}
+		if strings.HasSuffix(line, "\\") {
+			current += strings.TrimSuffix(line, "\\") + " "
+			continue
+		}
+		current += line
+		fields := strings.SplitN(strings.TrimSpace(current), " ", 2)
+		instruction := buildInstruction{Command: strings.ToUpper(fields[0]), Line: startLine}
+		if len(fields) == 2 {
+			instruction.Args = strings.TrimSpace(fields[1])
+		}
+		instructions = append(instructions, instruction)
+		current = ""
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if current != "" {
+		return nil, fmt.Errorf("line %d: unexpected end of file after \\\\", startLine)
+	}
+	if len(instructions) == 0 || instructions[0].Command != "FROM" {
+		return nil, fmt.Errorf("build file should start with FROM")
+	}
+	return instructions, nil
+}
+
+// 根据构建文件构建镜像，每条指令的结果保存为一个中间镜像
+// 父镜像和指令都没有变化时直接使用上一次构建的中间镜像
+func buildImage(contextDir, buildFile, imageName string, noCache bool) error {
+	if _, err := image.ParseReference(imageName); err != nil {
+		return err
+	}
+	if buildFile == "" {
+		buildFile = path.Join(contextDir, "Dockerfile")
+	}
+	instructions, err := parseBuildFile(buildFile)
+	if err != nil {
+		return err
+	}
+	driver, err := storage.GetDriver("")
+	if err != nil {
+		return err
+	}
+	//每一步生成的层在保存中间镜像之前不能被rmi回收
+	unlock, err := image.LockLayers()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	var current *image.Image
+	for i, instruction := range instructions {
+		fmt.Printf("Step %d/%d : %s %s\n", i+1, len(instructions), instruction.Command, instruction.Args)
+		if instruction.Command == "FROM" {
+			baseName := instruction.Args
+			//基础镜像只能是本地的镜像，/root下的tar包会先导入镜像存储
+			if err := container.CreateReadOnlyLayer(baseName, driver); err != nil {
+				return fmt.Errorf("line %d: base image %s: %v", instruction.Line, baseName, err)
+			}
+			if current, err = image.GetImage(baseName); err != nil {
+				return err
+			}
+			continue
+		}
+		if current == nil {
+			return fmt.Errorf("line %d: %s before FROM", instruction.Line, instruction.Command)
+		}
+
+		cacheKey, err := buildCacheKey(current, instruction, contextDir)
+		if err != nil {
+			return fmt.Errorf("line %d: %v", instruction.Line, err)
+		}
+		if !noCache {
+			if cached, err := image.GetBuildCache(cacheKey); err == nil {
+				current = cached
+				fmt.Println(" ---> Using cache")
+				continue
+			}
+		}
+		if current, err = runInstruction(current, instruction, contextDir, driver); err != nil {
+			return fmt.Errorf("line %d: %v", instruction.Line, err)
+		}
+		if err := image.SetBuildCache(cacheKey, current.Id); err != nil {
+			return err
+		}
+	}
+
+	if err := image.Tag(current.Id, imageName); err != nil {
+		return err
+	}
+	fmt.Printf("Successfully built %s %s\n", imageName, current.Id)
+	return nil
+}
+
+// 缓存的key由父镜像的ID和指令决定，COPY指令还要加上被复制文件的内容
+func buildCacheKey(parent *image.Image, instruction buildInstruction, contextDir string) (string, error) {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n%s %s\n", parent.Id, instruction.Command, instruction.Args)
+	if instruction.Command == "COPY" {
+		args, err := parseBuildArgs(instruction.Args)
+		if err != nil {
+			return "", err
+		}
+		if len(args) < 2 {
+			return "", fmt.Errorf("COPY requires at least two arguments")
+		}
+		for _, src := range args[:len(args)-1] {
+			srcPath, err := contextPath(contextDir, src)
+			if err != nil {
+				return "", err
+			}
+			if err := hashPath(h, srcPath); err != nil {
+				return "", err
+			}
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// 执行一条指令，结果保存为不带标签的中间镜像
+func runInstruction(parent *image.Image, instruction buildInstruction, contextDir string,
+	driver storage.Driver) (*image.Image, error) {
+	createdBy := instruction.Command + " " + instruction.Args
+	history := image.History{CreatedBy: createdBy}
+	switch instruction.Command {
+	case "RUN":
+		args, err := parseCommandArgs(instruction.Args)
+		if err != nil {
+			return nil, err
+		}
+		diffID, err := buildRun(parent, args, driver)
+		if err != nil {
+			return nil, err
+		}
+		return image.Derive(parent, "", diffID, history, nil)
+	case "COPY":
+		diffID, err := buildCopy(parent, instruction.Args, contextDir, driver)
+		if err != nil {
+			return nil, err
+		}
+		return image.Derive(parent, "", diffID, history, nil)
+	case "ENV":
+		envs, err := parseEnv(instruction.Args)
+		if err != nil {
+			return nil, err
+		}
+		return image.Derive(parent, "", "", history, func(config *image.ImageConfig) {
+			config.Config.Env = image.MergeEnv(config.Config.Env, envs)
+		})
+	case "WORKDIR":
+		workDir := instruction.Args
+		if !path.IsAbs(workDir) {
+			workDir = path.Join("/", parent.Config.Config.WorkingDir, workDir)
+		}
+		return image.Derive(parent, "", "", history, func(config *image.ImageConfig) {
+			config.Config.WorkingDir = path.Clean(workDir)
+		})
+	case "CMD", "ENTRYPOINT":
+		args, err := parseCommandArgs(instruction.Args)
+		if err != nil {
+			return nil, err
+		}
+		return image.Derive(parent, "", "", history, func(config *image.ImageConfig) {
+			if instruction.Command == "CMD" {
+				config.Config.Cmd = args
+			} else {
+				config.Config.Entrypoint = args
+			}
+		})
+	default:
+		return nil, fmt.Errorf("unknown instruction %s", instruction.Command)
+	}
+}
+
+// 在以父镜像为rootfs的临时容器中执行RUN的命令，并把容器的可写层提交为新的一层
+func buildRun(parent *image.Image, args []string, driver storage.Driver) (string, error) {
+	containerName := "build-" + randStringBytes(10)
+	parentProcess, writePipe := container.NewParentProcess(true, containerName, "", parent.Id)
+	if parentProcess == nil {
+		return "", fmt.Errorf("new parent process error")
+	}
+	defer container.DeleteWorkSpace("", containerName, driver.Name())
+	spec, err := container.NewInitSpec(parentProcess.Dir, containerName, false, args,
+		parent.Config.Config.Env, &parent.Config.Config)
+	if err != nil {
+		writePipe.Close()
+		return "", err
+	}
+	if err := parentProcess.Start(); err != nil {
+		writePipe.Close()
+		return "", err
+	}
+	if err := container.SendInitSpec(spec, writePipe); err != nil {
+		return "", err
+	}
+	if err := parentProcess.Wait(); err != nil {
+		return "", fmt.Errorf("run %q error %v", strings.Join(args, " "), err)
+	}
+	//先卸载rootfs，保证可写层中的内容都已经写入
+	mntURL := fmt.Sprintf(container.MntUrl, containerName)
+	if err := driver.Unmount(mntURL); err != nil {
+		return "", err
+	}
+	os.Remove(mntURL)
+	return image.CommitLayer(fmt.Sprintf(container.WriteLayerUrl, containerName), driver)
+}
+
+// 把构建上下文中的文件复制到一个临时目录，作为新的一层
+func buildCopy(parent *image.Image, rawArgs, contextDir string, driver storage.Driver) (string, error) {
+	args, err := parseBuildArgs(rawArgs)
+	if err != nil {
+		return "", err
+	}
+	if len(args) < 2 {
+		return "", fmt.Errorf("COPY requires at least two arguments")
+	}
+	srcs, dest := args[:len(args)-1], args[len(args)-1]
+	if !path.IsAbs(dest) {
+		//相对路径相对于WORKDIR，以/结尾表示目录
+		trailing := strings.HasSuffix(dest, "/")
+		dest = path.Join("/", parent.Config.Config.WorkingDir, dest)
+		if trailing {
+			dest += "/"
+		}
+	}
+	layerDir, err := ioutil.TempDir("", "mydocker-copy-")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(layerDir)
+	os.Chmod(layerDir, 0755)
+
+	destIsDir := strings.HasSuffix(dest, "/") || len(srcs) > 1
+	for _, src := range srcs {
+		srcPath, err := contextPath(contextDir, src)
+		if err != nil {
+			return "", err
+		}
+		fi, err := os.Stat(srcPath)
+		if err != nil {
+			return "", err
+		}
+		target := filepath.Join(layerDir, dest)
+		switch {
+		case fi.IsDir():
+			//复制目录时复制的是目录中的内容
+			err = copyTree(srcPath, target)
+		case destIsDir:
+			err = copyTree(srcPath, filepath.Join(target, filepath.Base(srcPath)))
+		default:
+			err = copyTree(srcPath, target)
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	return image.CommitLayer(layerDir, driver)
+}
+
+// 构建上下文中的路径，不允许访问上下文目录之外的文件
+func contextPath(contextDir, name string) (string, error) {
+	absContext, err := filepath.Abs(contextDir)
+	if err != nil {
+		return "", err
+	}
+	target := filepath.Join(absContext, filepath.Clean("/"+name))
+	real, err := filepath.EvalSymlinks(target)
+	if err != nil {
+		return "", err
+	}
+	realContext, err := filepath.EvalSymlinks(absContext)
+	if err != nil {
+		return "", err
+	}
+	if real != realContext && !strings.HasPrefix(real, realContext+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s is outside of the build context", name)
+	}
+	return target, nil
+}
+
+// 复制文件或者目录，保留权限和符号链接
+func copyTree(src, dest string) error {
+	return filepath.Walk(src, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dest, rel)
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		switch {
+		case fi.IsDir():
+			return os.MkdirAll(target, fi.Mode().Perm())
+		case fi.Mode()&os.ModeSymlink != 0:
+			link, err := os.Readlink(p)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		case fi.Mode().IsRegular():
+			in, err := os.Open(p)
+			if err != nil {
+				return err
+			}
+			defer in.Close()
+			out, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, fi.Mode().Perm())
+			if err != nil {
+				return err
+			}
+			defer out.Close()
+			_, err = io.Copy(out, in)
+			return err
+		default:
+			return fmt.Errorf("unsupported file type %s", p)
+		}
+	})
+}
+
+// 把文件或目录的路径、权限和内容写入hash
+func hashPath(h io.Writer, root string) error {
+	return filepath.Walk(root, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(root, p)
+		fmt.Fprintf(h, "%s %o\n", rel, fi.Mode())
+		switch {
+		case fi.Mode()&os.ModeSymlink != 0:
+			link, err := os.Readlink(p)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintln(h, link)
+		case fi.Mode().IsRegular():
+			file, err := os.Open(p)
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+			if _, err := io.Copy(h, file); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// 解析RUN CMD ENTRYPOINT的参数，JSON数组格式直接作为参数，否则通过/bin/sh -c执行
+func parseCommandArgs(raw string) ([]string, error) {
+	if strings.HasPrefix(raw, "[") {
+		var args []string
+		if err := json.Unmarshal([]byte(raw), &args); err != nil {
+			return nil, fmt.Errorf("invalid json array %s", raw)
+		}
+		return args, nil
+	}
+	if raw == "" {
+		return nil, fmt.Errorf("missing command")
+	}
+	return []string{"/bin/sh", "-c", raw}, nil
+}
+
+// 解析ENV的参数，支持 ENV key value 和 ENV key1=value1 key2=value2 两种格式
+func parseEnv(raw string) ([]string, error) {
+	args, err := parseBuildArgs(raw)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) == 0 {
+		return nil, fmt.Errorf("ENV requires at least one argument")
+	}
+	if !strings.Contains(args[0], "=") {
+		fields := strings.SplitN(raw, " ", 2)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("ENV %s has no value", raw)
+		}
+		return []string{fields[0] + "=" + strings.TrimSpace(fields[1])}, nil
+	}
+	for _, arg := range args {
+		if !strings.Contains(arg, "=") {
+			return nil, fmt.Errorf("invalid ENV %s, should be key=value", arg)
+		}
+	}
+	return args, nil
+}
+
+// 按空白拆分参数，支持单引号、双引号和反斜杠转义
+func parseBuildArgs(raw string) ([]string, error) {
+	var args []string
+	var current strings.Builder
+	inArg := false
+	var quote rune
+	escaped := false
+	for _, r := range raw {
+		switch {
+		case escaped:
+			current.WriteRune(r)
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped = true
+			inArg = true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				current.WriteRune(r)
+			}
+		case r == '"' || r == '\'':
+			quote = r
+			inArg = true
+		case r == ' ' || r == '\t':
+			if inArg {
+				args = append(args, current.String())
+				current.Reset()
+				inArg = false
+			}
+		default:
+			current.WriteRune(r)
+			inArg = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated quote in %s", raw)
+	}
+	if inArg {
+		args = append(args, current.String())
+	}
+	return args, nil
+}
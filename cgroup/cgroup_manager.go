@@ -2,7 +2,9 @@ package cgroup
 
 import (
 	subsystems "TinyDocker/cgroup/subsystem"
+	"fmt"
 	"github.com/sirupsen/logrus"
+	"strings"
 )
 
 type CgroupManager struct {
@@ -21,19 +23,56 @@ func NewCgroupManager(path string) *CgroupManager {
 // 将进程pid加入到这个cgroup中
 func (c *CgroupManager) Apply(pid int) error {
 	for _, subSysIns := range subsystems.SubsystemsIns {
-		subSysIns.Apply(c.Path, pid)
+		if err := subSysIns.Apply(c.Path, pid); err != nil {
+			logrus.Warnf("apply cgroup %s fail %v", subSysIns.Name(), err)
+		}
 	}
 	return nil
 }
 
-// 设置cgroup资源限制
+// 设置cgroup资源限制，某个subsystem失败时继续设置其他的subsystem，最后返回所有失败的subsystem的错误
 func (c *CgroupManager) Set(res *subsystems.ResourceConfig) error {
+	var errs []string
 	for _, subSysIns := range subsystems.SubsystemsIns {
-		subSysIns.Set(c.Path, res)
+		if err := subSysIns.Set(c.Path, res); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", subSysIns.Name(), err))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("set cgroup fail %s", strings.Join(errs, "; "))
 	}
 	return nil
 }
 
+// 修改运行中容器的资源限制，只重新设置配置有变化的subsystem，返回实际生效的配置
+// 某个subsystem设置失败时用旧配置恢复它，生效的配置中保留它的旧值，其他subsystem的修改照常保留
+func (c *CgroupManager) Update(old, res *subsystems.ResourceConfig) (*subsystems.ResourceConfig, error) {
+	if old == nil {
+		old = &subsystems.ResourceConfig{}
+	}
+	applied := &subsystems.ResourceConfig{}
+	*applied = *old
+	var errs []string
+	for _, subSysIns := range subsystems.SubsystemsIns {
+		if subsystems.SubsystemConfigEqual(subSysIns.Name(), old, res) {
+			continue
+		}
+		if err := subSysIns.Set(c.Path, res); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", subSysIns.Name(), err))
+			//旧配置中没有的字段无法恢复，只能尽量把有旧值的字段写回去
+			if err := subSysIns.Set(c.Path, old); err != nil {
+				logrus.Warnf("restore cgroup %s fail %v", subSysIns.Name(), err)
+			}
+			continue
+		}
+		subsystems.CopySubsystemConfig(subSysIns.Name(), applied, res)
+	}
+	if len(errs) > 0 {
+		return applied, fmt.Errorf("set cgroup fail %s", strings.Join(errs, "; "))
+	}
+	return applied, nil
+}
+
 // 释放cgroup
 func (c *CgroupManager) Destroy() error {
 	for _, subSysIns := range subsystems.SubsystemsIns {
@@ -43,3 +82,8 @@ func (c *CgroupManager) Destroy() error {
 	}
 	return nil
 }
+
+// 读取cgroup的资源使用统计
+func (c *CgroupManager) GetStats() (*subsystems.CgroupStats, error) {
+	return subsystems.GetStats(c.Path)
+}
@@ -0,0 +1,75 @@
+package subsystems
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+)
+
+type BlkioSubSystem struct {
+}
+
+// 设置块设备IO的权重以及每个设备的bps/iops限速
+func (s *BlkioSubSystem) Set(cgroupPath string, res *ResourceConfig) error {
+	if skipLegacySet(s.Name(), res) {
+		return nil
+	}
+	if subsysCgroupPath, err := GetCgroupPath(s.Name(), cgroupPath, true); err == nil {
+		if res.BlkioWeight != "" {
+			if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "blkio.weight"), []byte(res.BlkioWeight), 0644); err != nil {
+				return fmt.Errorf("set cgroup blkio weight fail %v", err)
+			}
+		}
+		throttles := []struct {
+			file  string
+			specs []string
+			bytes bool
+		}{
+			{"blkio.throttle.read_bps_device", res.DeviceReadBps, true},
+			{"blkio.throttle.write_bps_device", res.DeviceWriteBps, true},
+			{"blkio.throttle.read_iops_device", res.DeviceReadIOps, false},
+			{"blkio.throttle.write_iops_device", res.DeviceWriteIOps, false},
+		}
+		for _, throttle := range throttles {
+			//每个设备需要单独写一次，格式为 major:minor value
+			for _, spec := range throttle.specs {
+				device, value, err := ParseDeviceLimit(spec, throttle.bytes)
+				if err != nil {
+					return err
+				}
+				line := fmt.Sprintf("%s %d", device, value)
+				if err := ioutil.WriteFile(path.Join(subsysCgroupPath, throttle.file), []byte(line), 0644); err != nil {
+					return fmt.Errorf("set cgroup %s fail %v", throttle.file, err)
+				}
+			}
+		}
+		return nil
+	} else {
+		return err
+	}
+}
+
+func (s *BlkioSubSystem) Remove(cgroupPath string) error {
+	if subsysCgroupPath, err := GetCgroupPath(s.Name(), cgroupPath, false); err == nil {
+		return os.RemoveAll(subsysCgroupPath)
+	} else {
+		return err
+	}
+}
+
+func (s *BlkioSubSystem) Apply(cgroupPath string, pid int) error {
+	if subsysCgroupPath, err := GetCgroupPath(s.Name(), cgroupPath, false); err == nil {
+		if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "tasks"), []byte(strconv.Itoa(pid)), 0644); err != nil {
+			return fmt.Errorf("set cgroup proc fail %v", err)
+		}
+		return nil
+	} else {
+		return fmt.Errorf("get cgroup %s error: %v", cgroupPath, err)
+	}
+}
+
+func (s *BlkioSubSystem) Name() string {
+	return "blkio"
+}
@@ -12,12 +12,30 @@ type CpuSubSystem struct {
 }
 
 func (s *CpuSubSystem) Set(cgroupPath string, res *ResourceConfig) error {
+	if skipLegacySet(s.Name(), res) {
+		return nil
+	}
 	if subsysCgroupPath, err := GetCgroupPath(s.Name(), cgroupPath, true); err == nil {
 		if res.CpuShare != "" {
 			if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "cpu.shares"), []byte(res.CpuShare), 0644); err != nil {
 				return fmt.Errorf("set cgroup cpu share fail %v", err)
 			}
 		}
+		quota, period, err := ParseCpuLimit(res)
+		if err != nil {
+			return err
+		}
+		//先写period再写quota，quota为0表示没有配置上限
+		if res.CpuPeriod != "" || quota != 0 {
+			if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "cpu.cfs_period_us"), []byte(strconv.FormatUint(period, 10)), 0644); err != nil {
+				return fmt.Errorf("set cgroup cpu period fail %v", err)
+			}
+		}
+		if quota != 0 {
+			if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "cpu.cfs_quota_us"), []byte(strconv.FormatInt(quota, 10)), 0644); err != nil {
+				return fmt.Errorf("set cgroup cpu quota fail %v", err)
+			}
+		}
 		return nil
 	} else {
 		return err
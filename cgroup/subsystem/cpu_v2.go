@@ -0,0 +1,68 @@
+package subsystems
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strconv"
+)
+
+// cgroup v2下的cpu controller
+type CpuSubSystemV2 struct {
+}
+
+// v2没有cpu.shares，把v1的shares(2-262144)按比例换算成cpu.weight(1-10000)
+// CPU上限写入cpu.max，对应v1的cfs_quota_us和cfs_period_us
+func (s *CpuSubSystemV2) Set(cgroupPath string, res *ResourceConfig) error {
+	if subsysCgroupPath, err := GetUnifiedCgroupPath(unifiedController(s.Name(), res), cgroupPath, true); err == nil {
+		if res.CpuShare != "" {
+			shares, err := strconv.ParseUint(res.CpuShare, 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid cpu share %s: %v", res.CpuShare, err)
+			}
+			weight := sharesToWeight(shares)
+			if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "cpu.weight"), []byte(strconv.FormatUint(weight, 10)), 0644); err != nil {
+				return fmt.Errorf("set cgroup cpu weight fail %v", err)
+			}
+		}
+		quota, period, err := ParseCpuLimit(res)
+		if err != nil {
+			return err
+		}
+		//cpu.max的格式为 "$MAX $PERIOD"，不限制时MAX为max
+		if res.CpuPeriod != "" || quota != 0 {
+			limit := "max"
+			if quota > 0 {
+				limit = strconv.FormatInt(quota, 10)
+			}
+			if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "cpu.max"), []byte(fmt.Sprintf("%s %d", limit, period)), 0644); err != nil {
+				return fmt.Errorf("set cgroup cpu max fail %v", err)
+			}
+		}
+		return nil
+	} else {
+		return err
+	}
+}
+
+func (s *CpuSubSystemV2) Remove(cgroupPath string) error {
+	return removeUnifiedCgroup(cgroupPath)
+}
+
+func (s *CpuSubSystemV2) Apply(cgroupPath string, pid int) error {
+	return applyUnifiedCgroup(cgroupPath, pid)
+}
+
+func (s *CpuSubSystemV2) Name() string {
+	return "cpu"
+}
+
+func sharesToWeight(shares uint64) uint64 {
+	if shares < 2 {
+		shares = 2
+	}
+	if shares > 262144 {
+		shares = 262144
+	}
+	return 1 + ((shares-2)*9999)/262142
+}
@@ -6,13 +6,20 @@ import (
 	"os"
 	"path"
 	"strconv"
+	"strings"
 )
 
 type CpusetSubSystem struct {
 }
 
 func (s *CpusetSubSystem) Set(cgroupPath string, res *ResourceConfig) error {
+	if skipLegacySet(s.Name(), res) {
+		return nil
+	}
 	if subsysCgroupPath, err := GetCgroupPath(s.Name(), cgroupPath, true); err == nil {
+		if err := initCpuset(FindCgroupMountpoint(s.Name()), cgroupPath); err != nil {
+			return err
+		}
 		if res.CpuSet != "" {
 			if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "cpuset.cpus"), []byte(res.CpuSet), 0644); err != nil {
 				return fmt.Errorf("set cgroup cpuset fail %v", err)
@@ -46,3 +53,30 @@ func (s *CpusetSubSystem) Apply(cgroupPath string, pid int) error {
 func (s *CpusetSubSystem) Name() string {
 	return "cpuset"
 }
+
+// v1中新建的cpuset cgroup的cpuset.cpus和cpuset.mems为空，此时无法加入进程
+// 从根目录开始逐级把空的配置从父cgroup中继承下来
+func initCpuset(cgroupRoot, cgroupPath string) error {
+	parent := cgroupRoot
+	for _, dir := range strings.Split(strings.Trim(cgroupPath, "/"), "/") {
+		current := path.Join(parent, dir)
+		for _, file := range []string{"cpuset.cpus", "cpuset.mems"} {
+			content, err := ioutil.ReadFile(path.Join(current, file))
+			if err != nil {
+				return fmt.Errorf("read %s fail %v", file, err)
+			}
+			if strings.TrimSpace(string(content)) != "" {
+				continue
+			}
+			parentContent, err := ioutil.ReadFile(path.Join(parent, file))
+			if err != nil {
+				return fmt.Errorf("read parent %s fail %v", file, err)
+			}
+			if err := ioutil.WriteFile(path.Join(current, file), parentContent, 0644); err != nil {
+				return fmt.Errorf("init %s fail %v", file, err)
+			}
+		}
+		parent = current
+	}
+	return nil
+}
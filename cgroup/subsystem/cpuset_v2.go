@@ -0,0 +1,36 @@
+package subsystems
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path"
+)
+
+// cgroup v2下的cpuset controller
+type CpusetSubSystemV2 struct {
+}
+
+func (s *CpusetSubSystemV2) Set(cgroupPath string, res *ResourceConfig) error {
+	if subsysCgroupPath, err := GetUnifiedCgroupPath(unifiedController(s.Name(), res), cgroupPath, true); err == nil {
+		if res.CpuSet != "" {
+			if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "cpuset.cpus"), []byte(res.CpuSet), 0644); err != nil {
+				return fmt.Errorf("set cgroup cpuset fail %v", err)
+			}
+		}
+		return nil
+	} else {
+		return err
+	}
+}
+
+func (s *CpusetSubSystemV2) Remove(cgroupPath string) error {
+	return removeUnifiedCgroup(cgroupPath)
+}
+
+func (s *CpusetSubSystemV2) Apply(cgroupPath string, pid int) error {
+	return applyUnifiedCgroup(cgroupPath, pid)
+}
+
+func (s *CpusetSubSystemV2) Name() string {
+	return "cpuset"
+}
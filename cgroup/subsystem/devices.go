@@ -0,0 +1,73 @@
+package subsystems
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+)
+
+// 容器默认可以访问的设备，和docker的默认规则一致
+// 允许mknod任意设备，但只能读写/dev/null、/dev/zero、/dev/tty和pty这些常用设备
+var DefaultDeviceRules = []string{
+	"c *:* m",
+	"b *:* m",
+	"c 1:3 rwm",    // /dev/null
+	"c 1:5 rwm",    // /dev/zero
+	"c 1:7 rwm",    // /dev/full
+	"c 1:8 rwm",    // /dev/random
+	"c 1:9 rwm",    // /dev/urandom
+	"c 5:0 rwm",    // /dev/tty
+	"c 5:1 rwm",    // /dev/console
+	"c 5:2 rwm",    // /dev/ptmx
+	"c 136:* rwm",  // /dev/pts/*
+	"c 10:200 rwm", // /dev/net/tun
+}
+
+// cgroup v1的devices子系统，cgroup v2中设备的访问控制需要eBPF程序，暂不支持，run使用--device时会给出警告
+type DevicesSubSystem struct {
+}
+
+// 先禁止访问所有设备，再逐条写入默认规则和--device指定设备的规则
+func (s *DevicesSubSystem) Set(cgroupPath string, res *ResourceConfig) error {
+	if skipLegacySet(s.Name(), res) {
+		return nil
+	}
+	if subsysCgroupPath, err := GetCgroupPath(s.Name(), cgroupPath, true); err == nil {
+		if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "devices.deny"), []byte("a"), 0644); err != nil {
+			return fmt.Errorf("set cgroup devices.deny fail %v", err)
+		}
+		for _, rule := range append(append([]string{}, DefaultDeviceRules...), res.DeviceRules...) {
+			if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "devices.allow"), []byte(rule), 0644); err != nil {
+				return fmt.Errorf("set cgroup devices.allow %s fail %v", rule, err)
+			}
+		}
+		return nil
+	} else {
+		return err
+	}
+}
+
+func (s *DevicesSubSystem) Remove(cgroupPath string) error {
+	if subsysCgroupPath, err := GetCgroupPath(s.Name(), cgroupPath, false); err == nil {
+		return os.RemoveAll(subsysCgroupPath)
+	} else {
+		return err
+	}
+}
+
+func (s *DevicesSubSystem) Apply(cgroupPath string, pid int) error {
+	if subsysCgroupPath, err := GetCgroupPath(s.Name(), cgroupPath, false); err == nil {
+		if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "tasks"), []byte(strconv.Itoa(pid)), 0644); err != nil {
+			return fmt.Errorf("set cgroup proc fail %v", err)
+		}
+		return nil
+	} else {
+		return fmt.Errorf("get cgroup %s error: %v", cgroupPath, err)
+	}
+}
+
+func (s *DevicesSubSystem) Name() string {
+	return "devices"
+}
@@ -0,0 +1,57 @@
+package subsystems
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+)
+
+type HugetlbSubSystem struct {
+}
+
+// 每种页大小对应一个接口文件，如 hugetlb.2MB.limit_in_bytes
+func (s *HugetlbSubSystem) Set(cgroupPath string, res *ResourceConfig) error {
+	if skipLegacySet(s.Name(), res) {
+		return nil
+	}
+	if subsysCgroupPath, err := GetCgroupPath(s.Name(), cgroupPath, true); err == nil {
+		for _, spec := range res.HugetlbLimit {
+			pageSize, limit, err := ParseHugetlbLimit(spec)
+			if err != nil {
+				return err
+			}
+			file := fmt.Sprintf("hugetlb.%s.limit_in_bytes", pageSize)
+			if err := ioutil.WriteFile(path.Join(subsysCgroupPath, file), []byte(strconv.FormatInt(limit, 10)), 0644); err != nil {
+				return fmt.Errorf("set cgroup %s fail %v", file, err)
+			}
+		}
+		return nil
+	} else {
+		return err
+	}
+}
+
+func (s *HugetlbSubSystem) Remove(cgroupPath string) error {
+	if subsysCgroupPath, err := GetCgroupPath(s.Name(), cgroupPath, false); err == nil {
+		return os.RemoveAll(subsysCgroupPath)
+	} else {
+		return err
+	}
+}
+
+func (s *HugetlbSubSystem) Apply(cgroupPath string, pid int) error {
+	if subsysCgroupPath, err := GetCgroupPath(s.Name(), cgroupPath, false); err == nil {
+		if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "tasks"), []byte(strconv.Itoa(pid)), 0644); err != nil {
+			return fmt.Errorf("set cgroup proc fail %v", err)
+		}
+		return nil
+	} else {
+		return fmt.Errorf("get cgroup %s error: %v", cgroupPath, err)
+	}
+}
+
+func (s *HugetlbSubSystem) Name() string {
+	return "hugetlb"
+}
@@ -0,0 +1,43 @@
+package subsystems
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strconv"
+)
+
+// cgroup v2下的hugetlb controller
+type HugetlbSubSystemV2 struct {
+}
+
+// v2中接口文件名为 hugetlb.<pagesize>.max
+func (s *HugetlbSubSystemV2) Set(cgroupPath string, res *ResourceConfig) error {
+	if subsysCgroupPath, err := GetUnifiedCgroupPath(unifiedController(s.Name(), res), cgroupPath, true); err == nil {
+		for _, spec := range res.HugetlbLimit {
+			pageSize, limit, err := ParseHugetlbLimit(spec)
+			if err != nil {
+				return err
+			}
+			file := fmt.Sprintf("hugetlb.%s.max", pageSize)
+			if err := ioutil.WriteFile(path.Join(subsysCgroupPath, file), []byte(strconv.FormatInt(limit, 10)), 0644); err != nil {
+				return fmt.Errorf("set cgroup %s fail %v", file, err)
+			}
+		}
+		return nil
+	} else {
+		return err
+	}
+}
+
+func (s *HugetlbSubSystemV2) Remove(cgroupPath string) error {
+	return removeUnifiedCgroup(cgroupPath)
+}
+
+func (s *HugetlbSubSystemV2) Apply(cgroupPath string, pid int) error {
+	return applyUnifiedCgroup(cgroupPath, pid)
+}
+
+func (s *HugetlbSubSystemV2) Name() string {
+	return "hugetlb"
+}
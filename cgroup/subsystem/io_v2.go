@@ -0,0 +1,83 @@
+package subsystems
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strconv"
+)
+
+// cgroup v2下的io controller，对应v1的blkio
+type IoSubSystemV2 struct {
+}
+
+// 权重写入io.weight，设备限速按设备合并后写入io.max，如 8:0 rbps=1048576 wiops=100
+func (s *IoSubSystemV2) Set(cgroupPath string, res *ResourceConfig) error {
+	if subsysCgroupPath, err := GetUnifiedCgroupPath(unifiedController(s.Name(), res), cgroupPath, true); err == nil {
+		if res.BlkioWeight != "" {
+			weight, err := strconv.ParseUint(res.BlkioWeight, 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid blkio weight %s: %v", res.BlkioWeight, err)
+			}
+			line := fmt.Sprintf("default %d", blkioWeightToIoWeight(weight))
+			if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "io.weight"), []byte(line), 0644); err != nil {
+				return fmt.Errorf("set cgroup io weight fail %v", err)
+			}
+		}
+		throttles := []struct {
+			key   string
+			specs []string
+			bytes bool
+		}{
+			{"rbps", res.DeviceReadBps, true},
+			{"wbps", res.DeviceWriteBps, true},
+			{"riops", res.DeviceReadIOps, false},
+			{"wiops", res.DeviceWriteIOps, false},
+		}
+		var devices []string
+		limits := make(map[string]string)
+		for _, throttle := range throttles {
+			for _, spec := range throttle.specs {
+				device, value, err := ParseDeviceLimit(spec, throttle.bytes)
+				if err != nil {
+					return err
+				}
+				if _, exist := limits[device]; !exist {
+					devices = append(devices, device)
+				}
+				limits[device] += fmt.Sprintf(" %s=%d", throttle.key, value)
+			}
+		}
+		for _, device := range devices {
+			if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "io.max"), []byte(device+limits[device]), 0644); err != nil {
+				return fmt.Errorf("set cgroup io.max fail %v", err)
+			}
+		}
+		return nil
+	} else {
+		return err
+	}
+}
+
+func (s *IoSubSystemV2) Remove(cgroupPath string) error {
+	return removeUnifiedCgroup(cgroupPath)
+}
+
+func (s *IoSubSystemV2) Apply(cgroupPath string, pid int) error {
+	return applyUnifiedCgroup(cgroupPath, pid)
+}
+
+func (s *IoSubSystemV2) Name() string {
+	return "io"
+}
+
+// 把v1的blkio.weight(10-1000)按比例换算成io.weight(1-10000)
+func blkioWeightToIoWeight(weight uint64) uint64 {
+	if weight < 10 {
+		weight = 10
+	}
+	if weight > 1000 {
+		weight = 1000
+	}
+	return 1 + ((weight-10)*9999)/990
+}
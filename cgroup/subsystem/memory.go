@@ -13,12 +13,37 @@ type MemorySubSystem struct {
 
 // 设置CgroupPath对应的cgroup的内存资源限制
 func (s *MemorySubSystem) Set(cgroupPath string, res *ResourceConfig) error {
+	if skipLegacySet(s.Name(), res) {
+		return nil
+	}
 	//获取当前subsystem在虚拟文件系统中的路径
 	if subsysCgroupPath, err := GetCgroupPath(s.Name(), cgroupPath, true); err == nil {
+		limitFile := path.Join(subsysCgroupPath, "memory.limit_in_bytes")
+		swapFile := path.Join(subsysCgroupPath, "memory.memsw.limit_in_bytes")
+		//memsw限制的是内存+swap的总量，不能小于memory.limit_in_bytes
+		//update调大内存时新的内存限制可能超过旧的memsw，此时需要先写memsw再写内存限制
 		if res.MemoryLimit != "" {
 			//将限制写入到cgroup对应目录的memory.limit_in_bytes
-			if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "memory.limit_in_bytes"), []byte(res.MemoryLimit), 0644); err != nil {
-				return fmt.Errorf("set cgroup memory fail %v", err)
+			if err := ioutil.WriteFile(limitFile, []byte(res.MemoryLimit), 0644); err != nil {
+				if res.MemorySwap == "" {
+					return fmt.Errorf("set cgroup memory fail %v", err)
+				}
+				if err := ioutil.WriteFile(swapFile, []byte(res.MemorySwap), 0644); err != nil {
+					return fmt.Errorf("set cgroup memory swap fail %v", err)
+				}
+				if err := ioutil.WriteFile(limitFile, []byte(res.MemoryLimit), 0644); err != nil {
+					return fmt.Errorf("set cgroup memory fail %v", err)
+				}
+			}
+		}
+		if res.MemorySwap != "" {
+			if err := ioutil.WriteFile(swapFile, []byte(res.MemorySwap), 0644); err != nil {
+				return fmt.Errorf("set cgroup memory swap fail %v", err)
+			}
+		}
+		if res.MemoryReservation != "" {
+			if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "memory.soft_limit_in_bytes"), []byte(res.MemoryReservation), 0644); err != nil {
+				return fmt.Errorf("set cgroup memory reservation fail %v", err)
 			}
 		}
 		return nil
@@ -0,0 +1,74 @@
+package subsystems
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strconv"
+)
+
+// cgroup v2下的memory controller
+type MemorySubSystemV2 struct {
+}
+
+// 将内存限制写入cgroup目录下的memory.max
+func (s *MemorySubSystemV2) Set(cgroupPath string, res *ResourceConfig) error {
+	if subsysCgroupPath, err := GetUnifiedCgroupPath(unifiedController(s.Name(), res), cgroupPath, true); err == nil {
+		if res.MemoryLimit != "" {
+			if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "memory.max"), []byte(res.MemoryLimit), 0644); err != nil {
+				return fmt.Errorf("set cgroup memory fail %v", err)
+			}
+		}
+		if res.MemorySwap != "" {
+			swapMax, err := swapMaxValue(res.MemoryLimit, res.MemorySwap)
+			if err != nil {
+				return err
+			}
+			if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "memory.swap.max"), []byte(swapMax), 0644); err != nil {
+				return fmt.Errorf("set cgroup memory swap fail %v", err)
+			}
+		}
+		if res.MemoryReservation != "" {
+			if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "memory.low"), []byte(res.MemoryReservation), 0644); err != nil {
+				return fmt.Errorf("set cgroup memory reservation fail %v", err)
+			}
+		}
+		return nil
+	} else {
+		return err
+	}
+}
+
+func (s *MemorySubSystemV2) Remove(cgroupPath string) error {
+	return removeUnifiedCgroup(cgroupPath)
+}
+
+func (s *MemorySubSystemV2) Apply(cgroupPath string, pid int) error {
+	return applyUnifiedCgroup(cgroupPath, pid)
+}
+
+func (s *MemorySubSystemV2) Name() string {
+	return "memory"
+}
+
+// MemorySwap和v1一样表示内存+swap的总量，而v2的memory.swap.max只限制swap部分，需要减去内存限制
+func swapMaxValue(memoryLimit, memorySwap string) (string, error) {
+	if memorySwap == "-1" {
+		return "max", nil
+	}
+	if memoryLimit == "" {
+		return "", fmt.Errorf("memory swap %s requires a memory limit", memorySwap)
+	}
+	memory, err := ParseBytes(memoryLimit)
+	if err != nil {
+		return "", err
+	}
+	swap, err := ParseBytes(memorySwap)
+	if err != nil {
+		return "", err
+	}
+	if swap < memory {
+		return "", fmt.Errorf("memory swap %s should not be smaller than memory limit %s", memorySwap, memoryLimit)
+	}
+	return strconv.FormatInt(swap-memory, 10), nil
+}
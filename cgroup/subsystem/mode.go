@@ -0,0 +1,97 @@
+package subsystems
+
+import (
+	"bufio"
+	"os"
+	"strings"
+)
+
+// 宿主机cgroup的挂载模式
+type CgroupMode int
+
+const (
+	// 只有cgroup v1的hierarchy
+	CgroupModeLegacy CgroupMode = iota
+	// v1的hierarchy承载控制器，同时额外挂载了一个不带控制器的cgroup2(一般在/sys/fs/cgroup/unified)
+	CgroupModeHybrid
+	// /sys/fs/cgroup本身就是cgroup2，所有控制器都在unified hierarchy中
+	CgroupModeUnified
+)
+
+func (m CgroupMode) String() string {
+	switch m {
+	case CgroupModeHybrid:
+		return "hybrid"
+	case CgroupModeUnified:
+		return "unified"
+	default:
+		return "legacy"
+	}
+}
+
+const defaultCgroupRoot = "/sys/fs/cgroup"
+
+var currentMode = CgroupModeLegacy
+
+// 通过/proc/self/mountinfo 中的文件系统类型判断当前宿主机的cgroup模式
+func DetectCgroupMode() CgroupMode {
+	f, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return CgroupModeLegacy
+	}
+	defer f.Close()
+
+	hasV1, hasV2, rootIsV2 := false, false, false
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		mountPoint, fsType := parseMountInfoLine(scanner.Text())
+		switch fsType {
+		case "cgroup":
+			hasV1 = true
+		case "cgroup2":
+			hasV2 = true
+			if mountPoint == defaultCgroupRoot {
+				rootIsV2 = true
+			}
+		}
+	}
+	switch {
+	case rootIsV2 || (hasV2 && !hasV1):
+		return CgroupModeUnified
+	case hasV1 && hasV2:
+		return CgroupModeHybrid
+	default:
+		return CgroupModeLegacy
+	}
+}
+
+// 返回mountinfo中一行的挂载点和文件系统类型，文件系统类型在" - "分隔符之后
+func parseMountInfoLine(line string) (string, string) {
+	fields := strings.Split(line, " ")
+	if len(fields) < 5 {
+		return "", ""
+	}
+	for i := 5; i < len(fields)-1; i++ {
+		if fields[i] == "-" {
+			return fields[4], fields[i+1]
+		}
+	}
+	return fields[4], ""
+}
+
+// 探测cgroup模式并选择对应的subsystem实现，需要在使用CgroupManager之前调用
+// hybrid模式下控制器仍然挂在v1的hierarchy上，所以和legacy一样使用v1的实现
+func Init() CgroupMode {
+	currentMode = DetectCgroupMode()
+	if currentMode == CgroupModeUnified {
+		SubsystemsIns = UnifiedSubsystemsIns
+	} else {
+		SubsystemsIns = LegacySubsystemsIns
+	}
+	return currentMode
+}
+
+// 返回Init探测到的cgroup模式
+func CurrentMode() CgroupMode {
+	return currentMode
+}
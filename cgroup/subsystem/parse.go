@@ -0,0 +1,168 @@
+package subsystems
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// 解析带单位的大小，如 512k 100m 1g 1gb，不带单位时按字节计算
+func ParseBytes(size string) (int64, error) {
+	s := strings.ToLower(strings.TrimSpace(size))
+	s = strings.TrimSuffix(s, "b")
+	multiplier := int64(1)
+	if s != "" {
+		switch s[len(s)-1] {
+		case 'k':
+			multiplier = 1 << 10
+		case 'm':
+			multiplier = 1 << 20
+		case 'g':
+			multiplier = 1 << 30
+		case 't':
+			multiplier = 1 << 40
+		}
+		if multiplier != 1 {
+			s = s[:len(s)-1]
+		}
+	}
+	value, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("invalid size %s", size)
+	}
+	if value > math.MaxInt64/multiplier {
+		return 0, fmt.Errorf("size %s is too large", size)
+	}
+	return value * multiplier, nil
+}
+
+// 解析 <device>:<value> 格式的设备限制，返回cgroup需要的 major:minor 设备号和值
+// bytes为true时value可以带单位，否则必须是整数(如iops)
+func ParseDeviceLimit(spec string, bytes bool) (string, int64, error) {
+	idx := strings.LastIndex(spec, ":")
+	if idx <= 0 || idx == len(spec)-1 {
+		return "", 0, fmt.Errorf("invalid device limit %s, should be <device>:<value>", spec)
+	}
+	device, rawValue := spec[:idx], spec[idx+1:]
+	var value int64
+	var err error
+	if bytes {
+		value, err = ParseBytes(rawValue)
+	} else {
+		value, err = strconv.ParseInt(rawValue, 10, 64)
+	}
+	if err != nil || value < 0 {
+		return "", 0, fmt.Errorf("invalid device limit value %s", rawValue)
+	}
+	majorMinor, err := DeviceNumber(device)
+	if err != nil {
+		return "", 0, err
+	}
+	return majorMinor, value, nil
+}
+
+// 返回块设备的 major:minor 设备号
+func DeviceNumber(device string) (string, error) {
+	var stat syscall.Stat_t
+	if err := syscall.Stat(device, &stat); err != nil {
+		return "", fmt.Errorf("stat device %s error %v", device, err)
+	}
+	if stat.Mode&syscall.S_IFMT != syscall.S_IFBLK {
+		return "", fmt.Errorf("%s is not a block device", device)
+	}
+	return fmt.Sprintf("%d:%d", major(stat.Rdev), minor(stat.Rdev)), nil
+}
+
+func major(dev uint64) uint64 {
+	return ((dev >> 8) & 0xfff) | ((dev >> 32) &^ 0xfff)
+}
+
+func minor(dev uint64) uint64 {
+	return (dev & 0xff) | ((dev >> 12) &^ 0xff)
+}
+
+// 解析 <pagesize>:<limit> 格式的大页限制，如 2MB:1g，返回cgroup接口文件中使用的页大小名称和字节数
+func ParseHugetlbLimit(spec string) (string, int64, error) {
+	parts := strings.SplitN(spec, ":", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", 0, fmt.Errorf("invalid hugetlb limit %s, should be <pagesize>:<limit>", spec)
+	}
+	pageSize, err := ParseBytes(parts[0])
+	if err != nil {
+		return "", 0, err
+	}
+	limit, err := ParseBytes(parts[1])
+	if err != nil {
+		return "", 0, err
+	}
+	return hugePageSizeName(pageSize), limit, nil
+}
+
+// 大页在cgroup接口文件中的命名，如 hugetlb.2MB.limit_in_bytes
+func hugePageSizeName(size int64) string {
+	units := []string{"B", "KB", "MB", "GB"}
+	i := 0
+	for size >= 1024 && size%1024 == 0 && i < len(units)-1 {
+		size /= 1024
+		i++
+	}
+	return fmt.Sprintf("%d%s", size, units[i])
+}
+
+const (
+	// CFS默认调度周期100ms
+	DefaultCpuPeriod = 100000
+	// 内核允许的cfs_period_us和cfs_quota_us的范围
+	minCpuPeriod = 1000
+	maxCpuPeriod = 1000000
+	minCpuQuota  = 1000
+)
+
+// 根据Cpus或者CpuQuota/CpuPeriod计算CFS的quota和period
+// quota为0表示没有配置CPU上限，为-1表示不限制
+func ParseCpuLimit(res *ResourceConfig) (int64, uint64, error) {
+	period := uint64(DefaultCpuPeriod)
+	if res.CpuPeriod != "" {
+		value, err := strconv.ParseUint(res.CpuPeriod, 10, 64)
+		if err != nil || value < minCpuPeriod || value > maxCpuPeriod {
+			return 0, 0, fmt.Errorf("invalid cpu period %s, should be between %d and %d", res.CpuPeriod, minCpuPeriod, maxCpuPeriod)
+		}
+		period = value
+	}
+	if res.Cpus != "" && res.CpuQuota != "" {
+		return 0, 0, fmt.Errorf("cpus and cpu quota can not both provided")
+	}
+	var quota int64
+	switch {
+	case res.Cpus != "":
+		cpus, err := strconv.ParseFloat(res.Cpus, 64)
+		if err != nil || math.IsNaN(cpus) || math.IsInf(cpus, 0) || cpus <= 0 {
+			return 0, 0, fmt.Errorf("invalid cpus %s, should be a positive number", res.Cpus)
+		}
+		quota = int64(math.Round(cpus * float64(period)))
+		if quota < minCpuQuota {
+			return 0, 0, fmt.Errorf("cpus %s is too small, quota should be at least %dus", res.Cpus, minCpuQuota)
+		}
+	case res.CpuQuota != "":
+		value, err := strconv.ParseInt(res.CpuQuota, 10, 64)
+		if err != nil || (value != -1 && value < minCpuQuota) {
+			return 0, 0, fmt.Errorf("invalid cpu quota %s, should be -1 or at least %d", res.CpuQuota, minCpuQuota)
+		}
+		quota = value
+	}
+	return quota, period, nil
+}
+
+// 返回CPU上限对应的CPU数量，如 1.50，没有上限时返回空字符串
+func CpuLimitString(res *ResourceConfig) string {
+	if res == nil {
+		return ""
+	}
+	quota, period, err := ParseCpuLimit(res)
+	if err != nil || quota <= 0 {
+		return ""
+	}
+	return strconv.FormatFloat(float64(quota)/float64(period), 'f', 2, 64)
+}
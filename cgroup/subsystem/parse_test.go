@@ -0,0 +1,143 @@
+package subsystems
+
+import (
+	"testing"
+)
+
+func TestParseBytes(t *testing.T) {
+	tests := []struct {
+		size    string
+		want    int64
+		wantErr bool
+	}{
+		{size: "0", want: 0},
+		{size: "1024", want: 1024},
+		{size: "512k", want: 512 << 10},
+		{size: "100m", want: 100 << 20},
+		{size: "100M", want: 100 << 20},
+		{size: "1g", want: 1 << 30},
+		{size: "1gb", want: 1 << 30},
+		{size: "2t", want: 2 << 40},
+		{size: " 64m ", want: 64 << 20},
+		{size: "10b", want: 10},
+		{size: "", wantErr: true},
+		{size: "m", wantErr: true},
+		{size: "-1", wantErr: true},
+		{size: "1.5g", wantErr: true},
+		{size: "10x", wantErr: true},
+		{size: "8388607t", want: 8388607 << 40},
+		{size: "8388608t", wantErr: true},
+		{size: "9223372036854775807", want: 9223372036854775807},
+		{size: "9223372036854775807k", wantErr: true},
+		{size: "9223372036854775808", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseBytes(tt.size)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParseBytes(%q) = %d, want error", tt.size, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseBytes(%q) error %v", tt.size, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseBytes(%q) = %d, want %d", tt.size, got, tt.want)
+		}
+	}
+}
+
+func TestParseHugetlbLimit(t *testing.T) {
+	tests := []struct {
+		spec     string
+		pageSize string
+		limit    int64
+		wantErr  bool
+	}{
+		{spec: "2MB:1g", pageSize: "2MB", limit: 1 << 30},
+		{spec: "1GB:2g", pageSize: "1GB", limit: 2 << 30},
+		{spec: "64kb:512m", pageSize: "64KB", limit: 512 << 20},
+		{spec: "2MB", wantErr: true},
+		{spec: ":1g", wantErr: true},
+		{spec: "2MB:", wantErr: true},
+		{spec: "2MB:x", wantErr: true},
+	}
+	for _, tt := range tests {
+		pageSize, limit, err := ParseHugetlbLimit(tt.spec)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParseHugetlbLimit(%q) = %s, %d, want error", tt.spec, pageSize, limit)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseHugetlbLimit(%q) error %v", tt.spec, err)
+			continue
+		}
+		if pageSize != tt.pageSize || limit != tt.limit {
+			t.Errorf("ParseHugetlbLimit(%q) = %s, %d, want %s, %d", tt.spec, pageSize, limit, tt.pageSize, tt.limit)
+		}
+	}
+}
+
+func TestParseCpuLimit(t *testing.T) {
+	tests := []struct {
+		name    string
+		res     ResourceConfig
+		quota   int64
+		period  uint64
+		wantErr bool
+	}{
+		{name: "no limit", res: ResourceConfig{}, quota: 0, period: DefaultCpuPeriod},
+		{name: "cpus", res: ResourceConfig{Cpus: "1.5"}, quota: 150000, period: DefaultCpuPeriod},
+		{name: "cpus with period", res: ResourceConfig{Cpus: "0.5", CpuPeriod: "50000"}, quota: 25000, period: 50000},
+		{name: "quota", res: ResourceConfig{CpuQuota: "20000"}, quota: 20000, period: DefaultCpuPeriod},
+		{name: "unlimited quota", res: ResourceConfig{CpuQuota: "-1"}, quota: -1, period: DefaultCpuPeriod},
+		{name: "quota and period", res: ResourceConfig{CpuQuota: "300000", CpuPeriod: "200000"}, quota: 300000, period: 200000},
+		{name: "cpus and quota", res: ResourceConfig{Cpus: "1", CpuQuota: "100000"}, wantErr: true},
+		{name: "zero cpus", res: ResourceConfig{Cpus: "0"}, wantErr: true},
+		{name: "negative cpus", res: ResourceConfig{Cpus: "-1"}, wantErr: true},
+		{name: "nan cpus", res: ResourceConfig{Cpus: "NaN"}, wantErr: true},
+		{name: "cpus too small", res: ResourceConfig{Cpus: "0.001"}, wantErr: true},
+		{name: "quota too small", res: ResourceConfig{CpuQuota: "999"}, wantErr: true},
+		{name: "invalid quota", res: ResourceConfig{CpuQuota: "abc"}, wantErr: true},
+		{name: "period too small", res: ResourceConfig{CpuPeriod: "999"}, wantErr: true},
+		{name: "period too large", res: ResourceConfig{CpuPeriod: "1000001"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		quota, period, err := ParseCpuLimit(&tt.res)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s: ParseCpuLimit() = %d, %d, want error", tt.name, quota, period)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: ParseCpuLimit() error %v", tt.name, err)
+			continue
+		}
+		if quota != tt.quota || period != tt.period {
+			t.Errorf("%s: ParseCpuLimit() = %d, %d, want %d, %d", tt.name, quota, period, tt.quota, tt.period)
+		}
+	}
+}
+
+func TestCpuLimitString(t *testing.T) {
+	tests := []struct {
+		res  *ResourceConfig
+		want string
+	}{
+		{res: nil, want: ""},
+		{res: &ResourceConfig{}, want: ""},
+		{res: &ResourceConfig{Cpus: "1.5"}, want: "1.50"},
+		{res: &ResourceConfig{CpuQuota: "50000", CpuPeriod: "100000"}, want: "0.50"},
+		{res: &ResourceConfig{CpuQuota: "-1"}, want: ""},
+	}
+	for _, tt := range tests {
+		if got := CpuLimitString(tt.res); got != tt.want {
+			t.Errorf("CpuLimitString(%+v) = %q, want %q", tt.res, got, tt.want)
+		}
+	}
+}
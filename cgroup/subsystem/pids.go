@@ -0,0 +1,68 @@
+package subsystems
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+)
+
+type PidsSubSystem struct {
+}
+
+// 将进程数限制写入pids.max，防止容器中的fork炸弹耗尽宿主机的pid
+func (s *PidsSubSystem) Set(cgroupPath string, res *ResourceConfig) error {
+	if skipLegacySet(s.Name(), res) {
+		return nil
+	}
+	if subsysCgroupPath, err := GetCgroupPath(s.Name(), cgroupPath, true); err == nil {
+		if res.PidsLimit != "" {
+			limit, err := pidsMaxValue(res.PidsLimit)
+			if err != nil {
+				return err
+			}
+			if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "pids.max"), []byte(limit), 0644); err != nil {
+				return fmt.Errorf("set cgroup pids fail %v", err)
+			}
+		}
+		return nil
+	} else {
+		return err
+	}
+}
+
+func (s *PidsSubSystem) Remove(cgroupPath string) error {
+	if subsysCgroupPath, err := GetCgroupPath(s.Name(), cgroupPath, false); err == nil {
+		return os.RemoveAll(subsysCgroupPath)
+	} else {
+		return err
+	}
+}
+
+func (s *PidsSubSystem) Apply(cgroupPath string, pid int) error {
+	if subsysCgroupPath, err := GetCgroupPath(s.Name(), cgroupPath, false); err == nil {
+		if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "tasks"), []byte(strconv.Itoa(pid)), 0644); err != nil {
+			return fmt.Errorf("set cgroup proc fail %v", err)
+		}
+		return nil
+	} else {
+		return fmt.Errorf("get cgroup %s error: %v", cgroupPath, err)
+	}
+}
+
+func (s *PidsSubSystem) Name() string {
+	return "pids"
+}
+
+// pids.max只接受正整数或max，0和负数都表示不限制
+func pidsMaxValue(limit string) (string, error) {
+	value, err := strconv.ParseInt(limit, 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("invalid pids limit %s", limit)
+	}
+	if value <= 0 {
+		return "max", nil
+	}
+	return strconv.FormatInt(value, 10), nil
+}
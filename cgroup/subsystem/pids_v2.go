@@ -0,0 +1,40 @@
+package subsystems
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path"
+)
+
+// cgroup v2下的pids controller
+type PidsSubSystemV2 struct {
+}
+
+func (s *PidsSubSystemV2) Set(cgroupPath string, res *ResourceConfig) error {
+	if subsysCgroupPath, err := GetUnifiedCgroupPath(unifiedController(s.Name(), res), cgroupPath, true); err == nil {
+		if res.PidsLimit != "" {
+			limit, err := pidsMaxValue(res.PidsLimit)
+			if err != nil {
+				return err
+			}
+			if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "pids.max"), []byte(limit), 0644); err != nil {
+				return fmt.Errorf("set cgroup pids fail %v", err)
+			}
+		}
+		return nil
+	} else {
+		return err
+	}
+}
+
+func (s *PidsSubSystemV2) Remove(cgroupPath string) error {
+	return removeUnifiedCgroup(cgroupPath)
+}
+
+func (s *PidsSubSystemV2) Apply(cgroupPath string, pid int) error {
+	return applyUnifiedCgroup(cgroupPath, pid)
+}
+
+func (s *PidsSubSystemV2) Name() string {
+	return "pids"
+}
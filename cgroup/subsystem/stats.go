@@ -0,0 +1,156 @@
+package subsystems
+
+import (
+	"bufio"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// 从cgroup的统计文件中读出的资源使用情况
+type CgroupStats struct {
+	CpuUsage    uint64 `json:"cpuUsage"`    //累计使用的CPU时间(纳秒)
+	MemoryUsage uint64 `json:"memoryUsage"` //内存使用量，不包含可回收的page cache
+	MemoryLimit uint64 `json:"memoryLimit"` //内存限制，没有限制时为宿主机内存总量
+	PidsCurrent uint64 `json:"pidsCurrent"` //当前进程数
+	BlkioRead   uint64 `json:"blkioRead"`   //块设备累计读字节数
+	BlkioWrite  uint64 `json:"blkioWrite"`  //块设备累计写字节数
+}
+
+// 读取cgroup的资源使用统计，根据Init探测到的cgroup模式读取v1或v2的统计文件
+// 某个controller没有挂载，或者v2中没有设置限制而没有开启时，对应的统计保持为0
+func GetStats(cgroupPath string) (*CgroupStats, error) {
+	stats := &CgroupStats{}
+	if currentMode == CgroupModeUnified {
+		dir, err := GetUnifiedCgroupPath("", cgroupPath, false)
+		if err != nil {
+			return nil, err
+		}
+		readUnifiedStats(dir, stats)
+	} else {
+		if _, err := GetCgroupPath("memory", cgroupPath, false); err != nil {
+			return nil, err
+		}
+		readLegacyStats(cgroupPath, stats)
+	}
+	if total := hostMemory(); stats.MemoryLimit == 0 || stats.MemoryLimit > total {
+		stats.MemoryLimit = total
+	}
+	return stats, nil
+}
+
+func readLegacyStats(cgroupPath string, stats *CgroupStats) {
+	if dir, err := GetCgroupPath("cpuacct", cgroupPath, false); err == nil {
+		stats.CpuUsage = readUint(path.Join(dir, "cpuacct.usage"))
+	}
+	if dir, err := GetCgroupPath("memory", cgroupPath, false); err == nil {
+		usage := readUint(path.Join(dir, "memory.usage_in_bytes"))
+		inactive := readKeyValue(path.Join(dir, "memory.stat"))["total_inactive_file"]
+		if inactive < usage {
+			usage -= inactive
+		}
+		stats.MemoryUsage = usage
+		stats.MemoryLimit = readUint(path.Join(dir, "memory.limit_in_bytes"))
+	}
+	if dir, err := GetCgroupPath("pids", cgroupPath, false); err == nil {
+		stats.PidsCurrent = readUint(path.Join(dir, "pids.current"))
+	}
+	if dir, err := GetCgroupPath("blkio", cgroupPath, false); err == nil {
+		//每行格式为 major:minor Read|Write|Sync|Async|Total bytes
+		for _, fields := range readFields(path.Join(dir, "blkio.throttle.io_service_bytes")) {
+			if len(fields) != 3 {
+				continue
+			}
+			value, _ := strconv.ParseUint(fields[2], 10, 64)
+			switch fields[1] {
+			case "Read":
+				stats.BlkioRead += value
+			case "Write":
+				stats.BlkioWrite += value
+			}
+		}
+	}
+}
+
+func readUnifiedStats(dir string, stats *CgroupStats) {
+	stats.CpuUsage = readKeyValue(path.Join(dir, "cpu.stat"))["usage_usec"] * 1000
+	usage := readUint(path.Join(dir, "memory.current"))
+	inactive := readKeyValue(path.Join(dir, "memory.stat"))["inactive_file"]
+	if inactive < usage {
+		usage -= inactive
+	}
+	stats.MemoryUsage = usage
+	stats.MemoryLimit = readUint(path.Join(dir, "memory.max"))
+	stats.PidsCurrent = readUint(path.Join(dir, "pids.current"))
+	//每行格式为 major:minor rbytes=N wbytes=N rios=N wios=N ...
+	for _, fields := range readFields(path.Join(dir, "io.stat")) {
+		for _, field := range fields[1:] {
+			kv := strings.SplitN(field, "=", 2)
+			if len(kv) != 2 {
+				continue
+			}
+			value, _ := strconv.ParseUint(kv[1], 10, 64)
+			switch kv[0] {
+			case "rbytes":
+				stats.BlkioRead += value
+			case "wbytes":
+				stats.BlkioWrite += value
+			}
+		}
+	}
+}
+
+// 读取只有一个数值的统计文件，文件不存在或者内容为max时返回0
+func readUint(file string) uint64 {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return 0
+	}
+	value, _ := strconv.ParseUint(strings.TrimSpace(string(content)), 10, 64)
+	return value
+}
+
+// 读取 key value 格式的统计文件，如memory.stat和cpu.stat
+func readKeyValue(file string) map[string]uint64 {
+	result := make(map[string]uint64)
+	for _, fields := range readFields(file) {
+		if len(fields) != 2 {
+			continue
+		}
+		if value, err := strconv.ParseUint(fields[1], 10, 64); err == nil {
+			result[fields[0]] = value
+		}
+	}
+	return result
+}
+
+// 按行读取文件并按空白拆分每一行
+func readFields(file string) [][]string {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	var lines [][]string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if fields := strings.Fields(scanner.Text()); len(fields) > 0 {
+			lines = append(lines, fields)
+		}
+	}
+	return lines
+}
+
+// 宿主机的内存总量，从/proc/meminfo的MemTotal读取
+func hostMemory() uint64 {
+	for _, fields := range readFields("/proc/meminfo") {
+		if len(fields) >= 2 && fields[0] == "MemTotal:" {
+			value, _ := strconv.ParseUint(fields[1], 10, 64)
+			return value * 1024
+		}
+	}
+	return 0
+}
@@ -1,10 +1,25 @@
 package subsystems
 
+import "reflect"
+
 // 传递资源限制配置的结构体
 type ResourceConfig struct {
-	MemoryLimit string //内存限制
-	CpuShare    string //CPU权重
-	CpuSet      string //CPU核心数
+	MemoryLimit       string   `json:"memory,omitempty"`            //内存限制
+	MemorySwap        string   `json:"memorySwap,omitempty"`        //内存+swap总限制，-1表示不限制swap
+	MemoryReservation string   `json:"memoryReservation,omitempty"` //内存软限制
+	CpuShare          string   `json:"cpuShare,omitempty"`          //CPU权重
+	CpuSet            string   `json:"cpuSet,omitempty"`            //CPU核心数
+	Cpus              string   `json:"cpus,omitempty"`              //可使用的CPU数量，可以是小数，如1.5
+	CpuQuota          string   `json:"cpuQuota,omitempty"`          //每个周期内可使用的CPU时间(微秒)，-1表示不限制
+	CpuPeriod         string   `json:"cpuPeriod,omitempty"`         //CFS调度周期(微秒)
+	PidsLimit         string   `json:"pidsLimit,omitempty"`         //进程数限制，-1表示不限制
+	BlkioWeight       string   `json:"blkioWeight,omitempty"`       //块设备IO权重(10-1000)
+	DeviceReadBps     []string `json:"deviceReadBps,omitempty"`     //设备读速率限制 <device>:<bytes>
+	DeviceWriteBps    []string `json:"deviceWriteBps,omitempty"`    //设备写速率限制 <device>:<bytes>
+	DeviceReadIOps    []string `json:"deviceReadIOps,omitempty"`    //设备读IOPS限制 <device>:<iops>
+	DeviceWriteIOps   []string `json:"deviceWriteIOps,omitempty"`   //设备写IOPS限制 <device>:<iops>
+	HugetlbLimit      []string `json:"hugetlbLimit,omitempty"`      //大页限制 <pagesize>:<limit>
+	DeviceRules       []string `json:"deviceRules,omitempty"`       //允许访问的设备 <type> <major>:<minor> <access>
 }
 
 // cgroup抽象为path，即cgruop在hierarchy的路径，也就是虚拟文件系统中的虚拟路径
@@ -21,9 +36,94 @@ type Subsystem interface {
 
 // 通过不同subsystem初始化实例，创建资源限制处理链表
 var (
-	SubsystemsIns = []Subsystem{
+	// cgroup v1 hierarchy下的subsystem
+	LegacySubsystemsIns = []Subsystem{
 		&CpusetSubSystem{},
 		&MemorySubSystem{},
 		&CpuSubSystem{},
+		&PidsSubSystem{},
+		&BlkioSubSystem{},
+		&HugetlbSubSystem{},
+		&DevicesSubSystem{},
+	}
+	// cgroup v2 unified hierarchy下的subsystem
+	UnifiedSubsystemsIns = []Subsystem{
+		&CpusetSubSystemV2{},
+		&MemorySubSystemV2{},
+		&CpuSubSystemV2{},
+		&PidsSubSystemV2{},
+		&IoSubSystemV2{},
+		&HugetlbSubSystemV2{},
 	}
+	// 当前生效的subsystem，由Init根据宿主机的cgroup模式选择
+	SubsystemsIns = LegacySubsystemsIns
 )
+
+// 取出某个subsystem用到的配置字段，其他字段为空，空的列表统一为nil便于比较
+func SubsystemConfig(name string, res *ResourceConfig) ResourceConfig {
+	config := ResourceConfig{}
+	if res == nil {
+		return config
+	}
+	switch name {
+	case "memory":
+		config.MemoryLimit, config.MemorySwap, config.MemoryReservation = res.MemoryLimit, res.MemorySwap, res.MemoryReservation
+	case "cpu":
+		config.CpuShare, config.Cpus, config.CpuQuota, config.CpuPeriod = res.CpuShare, res.Cpus, res.CpuQuota, res.CpuPeriod
+	case "cpuset":
+		config.CpuSet = res.CpuSet
+	case "pids":
+		config.PidsLimit = res.PidsLimit
+	case "blkio", "io":
+		config.BlkioWeight = res.BlkioWeight
+		config.DeviceReadBps = nonEmpty(res.DeviceReadBps)
+		config.DeviceWriteBps = nonEmpty(res.DeviceWriteBps)
+		config.DeviceReadIOps = nonEmpty(res.DeviceReadIOps)
+		config.DeviceWriteIOps = nonEmpty(res.DeviceWriteIOps)
+	case "hugetlb":
+		config.HugetlbLimit = nonEmpty(res.HugetlbLimit)
+	case "devices":
+		config.DeviceRules = nonEmpty(res.DeviceRules)
+	}
+	return config
+}
+
+// 某个subsystem是否有需要写入的配置
+func HasSubsystemConfig(name string, res *ResourceConfig) bool {
+	return !reflect.DeepEqual(SubsystemConfig(name, res), ResourceConfig{})
+}
+
+// 两份配置中某个subsystem用到的字段是否相同
+func SubsystemConfigEqual(name string, a, b *ResourceConfig) bool {
+	return reflect.DeepEqual(SubsystemConfig(name, a), SubsystemConfig(name, b))
+}
+
+// 把src中某个subsystem用到的字段复制到dst
+func CopySubsystemConfig(name string, dst, src *ResourceConfig) {
+	config := SubsystemConfig(name, src)
+	switch name {
+	case "memory":
+		dst.MemoryLimit, dst.MemorySwap, dst.MemoryReservation = config.MemoryLimit, config.MemorySwap, config.MemoryReservation
+	case "cpu":
+		dst.CpuShare, dst.Cpus, dst.CpuQuota, dst.CpuPeriod = config.CpuShare, config.Cpus, config.CpuQuota, config.CpuPeriod
+	case "cpuset":
+		dst.CpuSet = config.CpuSet
+	case "pids":
+		dst.PidsLimit = config.PidsLimit
+	case "blkio", "io":
+		dst.BlkioWeight = config.BlkioWeight
+		dst.DeviceReadBps, dst.DeviceWriteBps = config.DeviceReadBps, config.DeviceWriteBps
+		dst.DeviceReadIOps, dst.DeviceWriteIOps = config.DeviceReadIOps, config.DeviceWriteIOps
+	case "hugetlb":
+		dst.HugetlbLimit = config.HugetlbLimit
+	case "devices":
+		dst.DeviceRules = config.DeviceRules
+	}
+}
+
+func nonEmpty(list []string) []string {
+	if len(list) == 0 {
+		return nil
+	}
+	return list
+}
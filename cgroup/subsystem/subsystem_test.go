@@ -0,0 +1,37 @@
+package subsystems
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSubsystemConfigEqual(t *testing.T) {
+	old := &ResourceConfig{MemoryLimit: "100m", CpuShare: "512", HugetlbLimit: []string{}, DeviceRules: []string{"c 1:3 rwm"}}
+	tests := []struct {
+		name string
+		res  *ResourceConfig
+		want bool
+	}{
+		{name: "memory", res: &ResourceConfig{MemoryLimit: "200m", CpuShare: "512", DeviceRules: []string{"c 1:3 rwm"}}, want: false},
+		{name: "cpu", res: &ResourceConfig{MemoryLimit: "200m", CpuShare: "512"}, want: true},
+		{name: "hugetlb", res: &ResourceConfig{}, want: true},
+		{name: "devices", res: &ResourceConfig{DeviceRules: []string{"c 1:3 rwm"}}, want: true},
+		{name: "devices", res: &ResourceConfig{}, want: false},
+		{name: "pids", res: nil, want: true},
+	}
+	for _, tt := range tests {
+		if got := SubsystemConfigEqual(tt.name, old, tt.res); got != tt.want {
+			t.Errorf("SubsystemConfigEqual(%q, %+v, %+v) = %v, want %v", tt.name, old, tt.res, got, tt.want)
+		}
+	}
+}
+
+func TestCopySubsystemConfig(t *testing.T) {
+	dst := &ResourceConfig{MemoryLimit: "100m", CpuShare: "512"}
+	src := &ResourceConfig{MemoryLimit: "200m", MemorySwap: "400m", CpuShare: "1024"}
+	CopySubsystemConfig("memory", dst, src)
+	//只复制memory用到的字段，cpu的配置保持不变
+	if want := (&ResourceConfig{MemoryLimit: "200m", MemorySwap: "400m", CpuShare: "512"}); !reflect.DeepEqual(dst, want) {
+		t.Errorf("CopySubsystemConfig() = %+v, want %+v", dst, want)
+	}
+}
@@ -3,8 +3,10 @@ package subsystems
 import (
 	"bufio"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"path"
+	"strconv"
 	"strings"
 )
 
@@ -36,9 +38,12 @@ func FindCgroupMountpoint(subsystem string) string {
 // cgroup在文件系统中的绝对路径
 func GetCgroupPath(subsystem string, cgroupPath string, autoCreate bool) (string, error) {
 	cgroupRoot := FindCgroupMountpoint(subsystem)
+	if cgroupRoot == "" {
+		return "", fmt.Errorf("cgroup mountpoint of subsystem %s not found", subsystem)
+	}
 	if _, err := os.Stat(path.Join(cgroupRoot, cgroupPath)); err == nil || (autoCreate && os.IsNotExist(err)) {
 		if os.IsNotExist(err) {
-			if err := os.Mkdir(path.Join(cgroupRoot, cgroupPath), 0755); err == nil {
+			if err := os.MkdirAll(path.Join(cgroupRoot, cgroupPath), 0755); err == nil {
 			} else {
 				return "", fmt.Errorf("error create cgroup %v", err)
 			}
@@ -48,3 +53,98 @@ func GetCgroupPath(subsystem string, cgroupPath string, autoCreate bool) (string
 		return "", fmt.Errorf("cgroup path error %v", err)
 	}
 }
+
+// v1中宿主机没有挂载某个subsystem的hierarchy，并且也没有需要写入的配置时跳过Set
+// 否则不使用blkio、hugetlb的容器也会因为找不到挂载点而设置失败
+func skipLegacySet(subsystem string, res *ResourceConfig) bool {
+	return !HasSubsystemConfig(subsystem, res) && FindCgroupMountpoint(subsystem) == ""
+}
+
+// 找出cgroup2文件系统(unified hierarchy)的挂载点
+func FindCgroup2Mountpoint() string {
+	f, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if mountPoint, fsType := parseMountInfoLine(scanner.Text()); fsType == "cgroup2" {
+			return mountPoint
+		}
+	}
+	return ""
+}
+
+// cgroup v2中cgroup在文件系统中的绝对路径
+// 自动创建时会逐级在父cgroup的cgroup.subtree_control中开启controller，否则子cgroup中看不到对应的接口文件
+// 多个controller共用一个目录，目录已经存在时也要开启，否则只有第一个创建目录的controller生效
+// controller为空时只创建目录，不开启任何controller
+func GetUnifiedCgroupPath(controller string, cgroupPath string, autoCreate bool) (string, error) {
+	cgroupRoot := FindCgroup2Mountpoint()
+	if cgroupRoot == "" {
+		return "", fmt.Errorf("cgroup2 mountpoint not found")
+	}
+	fullPath := path.Join(cgroupRoot, cgroupPath)
+	if _, err := os.Stat(fullPath); err == nil && !autoCreate {
+		return fullPath, nil
+	} else if !autoCreate || (err != nil && !os.IsNotExist(err)) {
+		return "", fmt.Errorf("cgroup path error %v", err)
+	}
+
+	current := cgroupRoot
+	for _, dir := range strings.Split(strings.Trim(cgroupPath, "/"), "/") {
+		if err := enableController(current, controller); err != nil {
+			return "", err
+		}
+		current = path.Join(current, dir)
+		if err := os.Mkdir(current, 0755); err != nil && !os.IsExist(err) {
+			return "", fmt.Errorf("error create cgroup %v", err)
+		}
+	}
+	return fullPath, nil
+}
+
+// Set时需要开启的controller，没有配置对应的限制时返回空，避免开启宿主机上可能不可用的controller
+func unifiedController(controller string, res *ResourceConfig) string {
+	if !HasSubsystemConfig(controller, res) {
+		return ""
+	}
+	return controller
+}
+
+// 在cgroup.subtree_control中为子cgroup开启controller
+func enableController(parent, controller string) error {
+	if controller == "" {
+		return nil
+	}
+	if err := ioutil.WriteFile(path.Join(parent, "cgroup.subtree_control"), []byte("+"+controller), 0644); err != nil {
+		return fmt.Errorf("enable controller %s in %s fail %v", controller, parent, err)
+	}
+	return nil
+}
+
+// cgroup v2中所有controller共用一个目录，将进程PID写入cgroup.procs即可加入cgroup
+func applyUnifiedCgroup(cgroupPath string, pid int) error {
+	if subsysCgroupPath, err := GetUnifiedCgroupPath("", cgroupPath, false); err == nil {
+		if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "cgroup.procs"), []byte(strconv.Itoa(pid)), 0644); err != nil {
+			return fmt.Errorf("set cgroup proc fail %v", err)
+		}
+		return nil
+	} else {
+		return fmt.Errorf("get cgroup %s error: %v", cgroupPath, err)
+	}
+}
+
+// 删除cgroup v2中的cgroup目录，多个controller共用目录，已经被删除时直接返回
+func removeUnifiedCgroup(cgroupPath string) error {
+	subsysCgroupPath, err := GetUnifiedCgroupPath("", cgroupPath, false)
+	if err != nil {
+		return nil
+	}
+	if err := os.Remove(subsysCgroupPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
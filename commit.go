@@ -2,20 +2,41 @@ package main
 
 import (
 	"TinyDocker/container"
+	"TinyDocker/image"
+	"TinyDocker/storage"
 	"fmt"
 	log "github.com/sirupsen/logrus"
-	"os/exec"
 )
 
-// 将容器文件系统打包成${imagename}.tar文件
-// 使用子目录集合制作镜像
-func commitContainer(containerName, imageName string) {
-	mntURL := fmt.Sprintf(container.MntUrl, containerName)
-	mntURL += "/"
-
-	imageTar := container.RootUrl + "/" + imageName + ".tar"
-
-	if _, err := exec.Command("tar", "-czf", imageTar, "-C", mntURL, ".").CombinedOutput(); err != nil {
-		log.Errorf("Tar folder %s error %v", mntURL, err)
+// 只把容器的可写层作为新的一层提交，新镜像以容器的镜像为父镜像
+// 可写层中存储驱动格式的whiteout会转换成镜像中的.wh.文件
+func commitContainer(containerName, imageName, author, message string) error {
+	containerInfo, err := getContainerInfoByName(containerName)
+	if err != nil {
+		return fmt.Errorf("get container %s info error %v", containerName, err)
+	}
+	if containerInfo.ImageName == "" {
+		return fmt.Errorf("container %s has no image", containerName)
+	}
+	driver, err := storage.GetDriver(containerInfo.StorageDriver)
+	if err != nil {
+		return err
+	}
+	//优先使用创建容器时记录的镜像ID，镜像名可能已经指向了其他镜像
+	parent := containerInfo.ImageName
+	if containerInfo.ImageId != "" {
+		parent = containerInfo.ImageId
+	}
+	writeURL := fmt.Sprintf(container.WriteLayerUrl, containerName)
+	img, err := image.Commit(parent, imageName, writeURL, driver, &image.CommitInfo{
+		Author:  author,
+		Message: message,
+		Cmd:     containerInfo.Cmd,
+		Env:     containerInfo.Env,
+	})
+	if err != nil {
+		return err
 	}
+	log.Infof("Commit container %s to image %s %s", containerName, imageName, img.Id)
+	return nil
 }
@@ -0,0 +1,166 @@
+package container
+
+import (
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"syscall"
+)
+
+// init进程通过fd 4的socket把pty的master发送给父进程
+const consoleSocketFd = 4
+
+// 打开devpts中的ptmx，返回pty的master和slave，ptsDir为devpts的挂载点
+// 在宿主机上通过/proc/<pid>/root/dev/pts也可以在容器的devpts中分配pty
+func openPty(ptsDir string) (*os.File, *os.File, error) {
+	master, err := os.OpenFile(filepath.Join(ptsDir, "ptmx"), os.O_RDWR|unix.O_NOCTTY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	//unlockpt，否则不能打开slave
+	if err := unix.IoctlSetPointerInt(int(master.Fd()), unix.TIOCSPTLCK, 0); err != nil {
+		master.Close()
+		return nil, nil, fmt.Errorf("unlockpt error %v", err)
+	}
+	ptyNumber, err := unix.IoctlGetInt(int(master.Fd()), unix.TIOCGPTN)
+	if err != nil {
+		master.Close()
+		return nil, nil, fmt.Errorf("ptsname error %v", err)
+	}
+	slave, err := os.OpenFile(filepath.Join(ptsDir, strconv.Itoa(ptyNumber)), os.O_RDWR|unix.O_NOCTTY, 0)
+	if err != nil {
+		master.Close()
+		return nil, nil, err
+	}
+	return master, slave, nil
+}
+
+// 在运行中的容器的devpts中分配pty，用于exec -ti
+func OpenContainerPty(pid string) (*os.File, *os.File, error) {
+	return openPty(fmt.Sprintf("/proc/%s/root/dev/pts", pid))
+}
+
+// 为使用终端的容器创建传递pty master的socket，子进程的一端作为fd 4传给init进程
+// 返回父进程的一端和子进程的一端，子进程的一端需要在进程启动后关闭
+func NewConsoleSocket(cmd *exec.Cmd) (*os.File, *os.File, error) {
+	fds, err := unix.Socketpair(unix.AF_UNIX, unix.SOCK_STREAM|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return nil, nil, fmt.Errorf("create console socket error %v", err)
+	}
+	parent, child := os.NewFile(uintptr(fds[0]), "console-parent"), os.NewFile(uintptr(fds[1]), "console-child")
+	cmd.ExtraFiles = append(cmd.ExtraFiles, child)
+	//容器使用自己的终端，不能继承宿主机的标准输入
+	cmd.Stdin = nil
+	return parent, child, nil
+}
+
+// 从socket中接收init进程发送的pty master
+func RecvConsole(socket *os.File) (*os.File, error) {
+	defer socket.Close()
+	buf := make([]byte, 1)
+	oob := make([]byte, unix.CmsgSpace(4))
+	_, oobn, _, _, err := unix.Recvmsg(int(socket.Fd()), buf, oob, unix.MSG_CMSG_CLOEXEC)
+	if err != nil {
+		return nil, fmt.Errorf("receive console error %v", err)
+	}
+	messages, err := unix.ParseSocketControlMessage(oob[:oobn])
+	if err != nil || len(messages) == 0 {
+		return nil, fmt.Errorf("container did not send console")
+	}
+	fds, err := unix.ParseUnixRights(&messages[0])
+	if err != nil || len(fds) == 0 {
+		return nil, fmt.Errorf("container did not send console")
+	}
+	return os.NewFile(uintptr(fds[0]), "console"), nil
+}
+
+// 在容器的devpts中分配pty，把master发送给父进程，slave作为用户命令的标准输入输出和控制终端
+func setUpConsole(uid, gid int) error {
+	master, slave, err := openPty("/dev/pts")
+	if err != nil {
+		return err
+	}
+	defer slave.Close()
+	socket := os.NewFile(uintptr(consoleSocketFd), "console")
+	err = unix.Sendmsg(int(socket.Fd()), []byte{0}, unix.UnixRights(int(master.Fd())), nil, 0)
+	master.Close()
+	socket.Close()
+	if err != nil {
+		return fmt.Errorf("send console error %v", err)
+	}
+	if err := slave.Chown(uid, gid); err != nil {
+		return err
+	}
+	for fd := 0; fd < 3; fd++ {
+		if err := unix.Dup2(int(slave.Fd()), fd); err != nil {
+			return fmt.Errorf("dup2 console error %v", err)
+		}
+	}
+	//创建新的会话并把pty设置为控制终端，job control才能正常工作
+	if _, err := unix.Setsid(); err != nil {
+		return fmt.Errorf("setsid error %v", err)
+	}
+	if err := unix.IoctlSetInt(0, unix.TIOCSCTTY, 0); err != nil {
+		return fmt.Errorf("set controlling terminal error %v", err)
+	}
+	return nil
+}
+
+/*
+在宿主机的终端和容器的pty之间转发输入输出，直到容器中所有进程都关闭了pty
+宿主机的标准输入是终端时切换为raw模式，按键直接交给容器处理，窗口大小变化时同步到pty
+*/
+func ProxyConsole(console *os.File) error {
+	defer console.Close()
+	stdinFd := int(os.Stdin.Fd())
+	if state, err := unix.IoctlGetTermios(stdinFd, unix.TCGETS); err == nil {
+		raw := *state
+		raw.Iflag &^= unix.IGNBRK | unix.BRKINT | unix.PARMRK | unix.ISTRIP | unix.INLCR | unix.IGNCR | unix.ICRNL | unix.IXON
+		raw.Oflag &^= unix.OPOST
+		raw.Lflag &^= unix.ECHO | unix.ECHONL | unix.ICANON | unix.ISIG | unix.IEXTEN
+		raw.Cflag &^= unix.CSIZE | unix.PARENB
+		raw.Cflag |= unix.CS8
+		raw.Cc[unix.VMIN] = 1
+		raw.Cc[unix.VTIME] = 0
+		if err := unix.IoctlSetTermios(stdinFd, unix.TCSETS, &raw); err != nil {
+			return fmt.Errorf("set terminal raw mode error %v", err)
+		}
+		defer unix.IoctlSetTermios(stdinFd, unix.TCSETS, state)
+
+		resizeConsole(stdinFd, console)
+		winch := make(chan os.Signal, 1)
+		signal.Notify(winch, syscall.SIGWINCH)
+		defer signal.Stop(winch)
+		go func() {
+			for range winch {
+				resizeConsole(stdinFd, console)
+			}
+		}()
+	}
+
+	go io.Copy(console, os.Stdin)
+	//容器中所有进程都关闭pty后读master会返回EIO
+	if _, err := io.Copy(os.Stdout, console); err != nil {
+		if pathErr, ok := err.(*os.PathError); !ok || pathErr.Err != syscall.EIO {
+			return err
+		}
+	}
+	return nil
+}
+
+// 把宿主机终端的窗口大小设置到pty上
+func resizeConsole(stdinFd int, console *os.File) {
+	size, err := unix.IoctlGetWinsize(stdinFd, unix.TIOCGWINSZ)
+	if err != nil {
+		return
+	}
+	if err := unix.IoctlSetWinsize(int(console.Fd()), unix.TIOCSWINSZ, size); err != nil {
+		log.Warnf("Resize console error %v", err)
+	}
+}
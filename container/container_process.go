@@ -1,6 +1,7 @@
 package container
 
 import (
+	subsystems "TinyDocker/cgroup/subsystem"
 	"fmt"
 	log "github.com/sirupsen/logrus"
 	"os"
@@ -9,7 +10,9 @@ import (
 )
 
 var (
+	CREATED             string = "created"
 	RUNNING             string = "running"
+	RESTARTING          string = "restarting"
 	STOP                string = "stopped"
 	Exit                string = "exited"
 	DefaultInfoLocation string = "/var/run/mydocker/%s/"
@@ -18,6 +21,8 @@ var (
 	RootUrl             string = "/root"
 	MntUrl              string = "/root/mnt/%s"
 	WriteLayerUrl       string = "/root/writeLayer/%s"
+	WorkUrl             string = "/root/work/%s"
+	CgroupParent        string = "mydocker"
 )
 
 type ContainerInfo struct {
@@ -29,12 +34,54 @@ type ContainerInfo struct {
 	Status      string   `json:"status"`      //容器的状态
 	Volume      string   `json:"volume"`      //容器的数据卷
 	PortMapping []string `json:"portmapping"` //端口映射
+	CgroupPath  string   `json:"cgroupPath"`  //容器cgroup相对于hierarchy根目录的路径
+	//容器的资源限制配置
+	ResourceConfig *subsystems.ResourceConfig `json:"resourceConfig"`
+	//挂载容器rootfs使用的存储驱动
+	StorageDriver string `json:"storageDriver"`
+	//创建容器使用的镜像
+	ImageName string `json:"image"`
+	ImageId   string `json:"imageId"` //创建容器时镜像对应的ID，镜像标签改变后依然指向原来的镜像
+	//镜像配置中的Entrypoint，容器内init运行的命令为Entrypoint加上Cmd
+	Entrypoint []string `json:"entrypoint,omitempty"`
+	//容器内init运行命令的参数
+	Cmd []string `json:"cmd"`
+	//用户通过-e指定的环境变量
+	Env []string `json:"env"`
+	//容器进程最终的环境变量，包括镜像和默认的环境变量，exec的进程使用同样的环境变量
+	ProcessEnv []string `json:"processEnv,omitempty"`
+	//容器进程的工作目录和用户，exec的进程同样在这个目录下以这个用户运行
+	ProcessCwd    string `json:"processCwd,omitempty"`
+	ProcessUid    int    `json:"processUid,omitempty"`
+	ProcessGid    int    `json:"processGid,omitempty"`
+	ProcessGroups []int  `json:"processGroups,omitempty"`
+	//容器中/dev/shm的大小
+	ShmSize int64 `json:"shmSize,omitempty"`
+	//用户通过--device添加的设备
+	Devices []string `json:"devices,omitempty"`
+	//容器连接的网络和分配的IP
+	Network   string `json:"network,omitempty"`
+	IPAddress string `json:"ipAddress,omitempty"`
+	//容器进程启动和退出的时间，以及退出码
+	StartedTime  string `json:"startedTime,omitempty"`
+	FinishedTime string `json:"finishedTime,omitempty"`
+	ExitCode     int    `json:"exitCode"`
+	//监控容器进程的shim进程，使用-ti运行的容器没有shim
+	ShimPid string `json:"shimPid,omitempty"`
+	//使用--init运行，PID 1是转发信号和回收僵尸进程的init
+	Init bool `json:"init,omitempty"`
+	//stop时发送的信号，为空时使用SIGTERM
+	StopSignal string `json:"stopSignal,omitempty"`
+	//重启策略和已经重启的次数，上一次的退出码记录在ExitCode中
+	RestartPolicy RestartPolicy `json:"restartPolicy"`
+	RestartCount  int           `json:"restartCount"`
 }
 
 /*
 准备clone新进程的cmd
+用户命令、环境变量、工作目录和用户等配置在进程启动后通过SendInitSpec写入返回的管道
 */
-func NewParentProcess(tty bool, containerName, volume, imageName string, envSlice []string) (*exec.Cmd, *os.File) {
+func NewParentProcess(tty bool, containerName, volume, imageName string) (*exec.Cmd, *os.File) {
 	//通过匿名管道来实现父子进程之间的通信
 	readPipe, writePipe, err := NewPipe()
 	if err != nil {
@@ -63,18 +110,21 @@ func NewParentProcess(tty bool, containerName, volume, imageName string, envSlic
 			return nil, nil
 		}
 		stdLogFilePath := dirURL + ContainerLogFile
-		stdLogFile, err := os.Create(stdLogFilePath)
+		//重新启动的容器的输出追加到之前的日志后面
+		stdLogFile, err := os.OpenFile(stdLogFilePath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
 		if err != nil {
 			log.Errorf("NewParentProcess create file %s error %v", stdLogFilePath, err)
 			return nil, nil
 		}
-		//将容器的标志输出定向到container.log文件
+		//将容器的标志输出和错误输出定向到container.log文件
 		cmd.Stdout = stdLogFile
+		cmd.Stderr = stdLogFile
 	}
 
 	//在外带的文件描述符中传入管道文件读取端的句柄
 	cmd.ExtraFiles = []*os.File{readPipe}
-	cmd.Env = append(os.Environ(), envSlice...)
+	//init进程不需要宿主机的环境变量，用户命令的环境变量在init spec中
+	cmd.Env = []string{}
 	NewWorkSpace(volume, imageName, containerName)
 	cmd.Dir = fmt.Sprintf(MntUrl, containerName)
 	return cmd, writePipe
@@ -0,0 +1,173 @@
+package container
+
+import (
+	"fmt"
+	"golang.org/x/sys/unix"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// 容器中的设备文件
+type Device struct {
+	Path        string      `json:"path"`     //容器内的路径
+	HostPath    string      `json:"hostPath"` //宿主机上的设备，不能mknod时bind mount到容器中
+	Type        string      `json:"type"`     //c为字符设备，b为块设备
+	Major       int64       `json:"major"`
+	Minor       int64       `json:"minor"`
+	FileMode    os.FileMode `json:"fileMode"`
+	Uid         uint32      `json:"uid"`
+	Gid         uint32      `json:"gid"`
+	Permissions string      `json:"permissions"` //devices cgroup中的访问权限，r读 w写 m创建设备文件
+}
+
+// 每个容器都会创建的设备
+var defaultDevices = []Device{
+	{Path: "/dev/null", Type: "c", Major: 1, Minor: 3, FileMode: 0666},
+	{Path: "/dev/zero", Type: "c", Major: 1, Minor: 5, FileMode: 0666},
+	{Path: "/dev/full", Type: "c", Major: 1, Minor: 7, FileMode: 0666},
+	{Path: "/dev/random", Type: "c", Major: 1, Minor: 8, FileMode: 0666},
+	{Path: "/dev/urandom", Type: "c", Major: 1, Minor: 9, FileMode: 0666},
+	{Path: "/dev/tty", Type: "c", Major: 5, Minor: 0, FileMode: 0666},
+}
+
+// /dev下的符号链接
+var defaultDevSymlinks = [][2]string{
+	{"/proc/self/fd", "/dev/fd"},
+	{"/proc/self/fd/0", "/dev/stdin"},
+	{"/proc/self/fd/1", "/dev/stdout"},
+	{"/proc/self/fd/2", "/dev/stderr"},
+	{"pts/ptmx", "/dev/ptmx"},
+}
+
+func defaultDeviceList() []Device {
+	devices := make([]Device, len(defaultDevices))
+	for i, device := range defaultDevices {
+		device.HostPath = device.Path
+		device.Permissions = "rwm"
+		devices[i] = device
+	}
+	return devices
+}
+
+/*
+解析--device参数，格式为 <宿主机设备>[:<容器内路径>][:<权限>]，如 /dev/sda:/dev/xvda:r
+容器内路径默认和宿主机一样，权限默认为rwm
+*/
+func ParseDevice(spec string) (*Device, error) {
+	parts := strings.Split(spec, ":")
+	if len(parts) > 3 || parts[0] == "" {
+		return nil, fmt.Errorf("invalid device %s, should be <host path>[:<container path>][:<permissions>]", spec)
+	}
+	hostPath, containerPath, permissions := parts[0], parts[0], "rwm"
+	switch len(parts) {
+	case 2:
+		if isDevicePermissions(parts[1]) {
+			permissions = parts[1]
+		} else {
+			containerPath = parts[1]
+		}
+	case 3:
+		containerPath, permissions = parts[1], parts[2]
+	}
+	if !isDevicePermissions(permissions) {
+		return nil, fmt.Errorf("invalid device permissions %s", permissions)
+	}
+	if !filepath.IsAbs(containerPath) {
+		return nil, fmt.Errorf("device path %s in container is not an absolute path", containerPath)
+	}
+
+	var stat syscall.Stat_t
+	if err := syscall.Stat(hostPath, &stat); err != nil {
+		return nil, fmt.Errorf("stat device %s error %v", hostPath, err)
+	}
+	device := &Device{
+		Path:        filepath.Clean(containerPath),
+		HostPath:    hostPath,
+		Major:       int64(unix.Major(stat.Rdev)),
+		Minor:       int64(unix.Minor(stat.Rdev)),
+		FileMode:    os.FileMode(stat.Mode & 0777),
+		Uid:         stat.Uid,
+		Gid:         stat.Gid,
+		Permissions: permissions,
+	}
+	switch stat.Mode & syscall.S_IFMT {
+	case syscall.S_IFCHR:
+		device.Type = "c"
+	case syscall.S_IFBLK:
+		device.Type = "b"
+	default:
+		return nil, fmt.Errorf("%s is not a device", hostPath)
+	}
+	return device, nil
+}
+
+func isDevicePermissions(permissions string) bool {
+	if permissions == "" {
+		return false
+	}
+	for _, c := range permissions {
+		if !strings.ContainsRune("rwm", c) {
+			return false
+		}
+	}
+	return true
+}
+
+// devices cgroup中允许访问这个设备的规则，如 c 10:200 rwm
+func (d *Device) CgroupRule() string {
+	return fmt.Sprintf("%s %d:%d %s", d.Type, d.Major, d.Minor, d.Permissions)
+}
+
+// 在rootfs中创建设备文件，没有权限mknod时(如在user namespace中)把宿主机的设备bind mount进来
+func createDevice(rootfs string, device Device) error {
+	dest, err := securePath(rootfs, device.Path)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return err
+	}
+	mode := uint32(device.FileMode.Perm())
+	if device.Type == "b" {
+		mode |= syscall.S_IFBLK
+	} else {
+		mode |= syscall.S_IFCHR
+	}
+	os.Remove(dest)
+	err = unix.Mknod(dest, mode, int(unix.Mkdev(uint32(device.Major), uint32(device.Minor))))
+	if err == nil {
+		//mknod受umask影响，需要再设置一次权限
+		if err := os.Chmod(dest, device.FileMode.Perm()); err != nil {
+			return err
+		}
+		return os.Chown(dest, int(device.Uid), int(device.Gid))
+	}
+	if err != syscall.EPERM {
+		return fmt.Errorf("mknod %s error %v", device.Path, err)
+	}
+	file, err := os.OpenFile(dest, os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	file.Close()
+	if err := syscall.Mount(device.HostPath, dest, "bind", syscall.MS_BIND, ""); err != nil {
+		return fmt.Errorf("bind mount device %s error %v", device.HostPath, err)
+	}
+	return nil
+}
+
+// 在rootfs中创建/dev下的符号链接
+func createDevSymlinks(rootfs string) error {
+	for _, link := range defaultDevSymlinks {
+		dest, err := securePath(rootfs, link[1])
+		if err != nil {
+			return err
+		}
+		if err := os.Symlink(link[0], dest); err != nil && !os.IsExist(err) {
+			return fmt.Errorf("symlink %s error %v", link[1], err)
+		}
+	}
+	return nil
+}
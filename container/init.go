@@ -3,7 +3,6 @@ package container
 import (
 	"fmt"
 	log "github.com/sirupsen/logrus"
-	"io/ioutil"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -15,63 +14,174 @@ import (
 init函数在容器内执行，代码执行到这里后，容器进程已经创建
 */
 func RunContainerInitProcess() error {
-	cmdArray := readUserCommand()
-	if cmdArray == nil || len(cmdArray) == 0 {
-		return fmt.Errorf("Run container get user command error, cmdArray is nil")
+	spec, err := readInitSpec()
+	if err != nil {
+		return err
+	}
+	//挂载proc等文件系统，创建设备并切换rootfs
+	if err := setUpMount(spec.Mounts, spec.Devices); err != nil {
+		log.Errorf("Set up mount error %v", err)
+		return err
+	}
+	if err := syscall.Sethostname([]byte(spec.Hostname)); err != nil {
+		return fmt.Errorf("sethostname error %v", err)
+	}
+	//pty需要在切换用户之前创建，并把slave的所有者改为容器的用户
+	if spec.Terminal {
+		if err := setUpConsole(spec.Uid, spec.Gid); err != nil {
+			log.Errorf("Set up console error %v", err)
+			return err
+		}
+	}
+	//在切换用户之前设置资源上限，普通用户不能提高hard limit
+	if err := setUpRlimits(spec.Rlimits); err != nil {
+		return err
+	}
+	if err := setUpWorkDir(spec.Cwd); err != nil {
+		log.Errorf("Set up working dir error %v", err)
+		return err
+	}
+	if err := setUpUser(spec.Uid, spec.Gid, spec.AdditionalGids); err != nil {
+		log.Errorf("Set up user error %v", err)
+		return err
 	}
-	//挂载proc文件系统
-	setUpMount()
 
+	//用户命令的环境变量替换init进程的环境变量，这样LookPath使用的是容器内的PATH
+	os.Clearenv()
+	for _, env := range spec.Env {
+		if i := strings.Index(env, "="); i > 0 {
+			os.Setenv(env[:i], env[i+1:])
+		}
+	}
 	//帮助我们在当前系统的Path中找到命令的绝对路径
-	path, err := exec.LookPath(cmdArray[0])
+	path, err := exec.LookPath(spec.Args[0])
 	if err != nil {
 		log.Errorf("Exec loop path error %v", err)
 		return err
 	}
 	log.Infof("Find path %s", path)
+	//使用--init时init进程不会被替换，而是作为PID 1转发信号、回收僵尸进程
+	if spec.Init {
+		os.Exit(runReaper(path, spec))
+	}
 	//Docker创建起来第一个容器后，PID为1的进程不是用户进程而是init进程， 不符合预期。如果直接kill该进程，容器也就
 	//syscall.Exec最终调用了Kernel的int execve(const char *filename, char *const argv[], char *const envp[])这个函数
 	//他的作用是执行当前filename对应的程序，并覆盖当前进程的镜像、数据、和堆栈等信息，包括PID
 	//也就是说，调用这个方法，将用户指定的进程运行起来，吧init进程替换掉
-	if err := syscall.Exec(path, cmdArray[0:], os.Environ()); err != nil {
+	if err := syscall.Exec(path, spec.Args, spec.Env); err != nil {
 		log.Errorf(err.Error())
 	}
 	return nil
 }
 
-func readUserCommand() []string {
-	pipe := os.NewFile(uintptr(3), "pipe")
-	defer pipe.Close()
-	msg, err := ioutil.ReadAll(pipe)
-	if err != nil {
-		log.Errorf("init read pipe error %v", err)
-		return nil
-	}
-	msgStr := string(msg)
-	return strings.Split(msgStr, " ")
-}
-
 /*
 Init 挂载点
+先在rootfs中挂载文件系统、创建设备，此时还能访问宿主机上的设备，再切换到rootfs
 */
-func setUpMount() {
+func setUpMount(mounts []Mount, devices []Device) error {
 	//获取当前路径
 	pwd, err := os.Getwd()
 	if err != nil {
-		log.Errorf("Get current location error %v", err)
-		return
+		return fmt.Errorf("Get current location error %v", err)
 	}
 	log.Infof("Current location is %s", pwd)
-	pivotRoot(pwd)
+	//容器中的挂载不能传播到宿主机
+	if err := syscall.Mount("", "/", "", syscall.MS_PRIVATE|syscall.MS_REC, ""); err != nil {
+		return fmt.Errorf("make mount namespace private error %v", err)
+	}
+	for _, m := range mounts {
+		dest, err := securePath(pwd, m.Destination)
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(dest, 0755); err != nil {
+			return err
+		}
+		if err := syscall.Mount(m.Source, dest, m.Type, m.Flags, m.Data); err != nil {
+			return fmt.Errorf("mount %s to %s error %v", m.Source, m.Destination, err)
+		}
+	}
+	for _, device := range devices {
+		if err := createDevice(pwd, device); err != nil {
+			return err
+		}
+	}
+	if err := createDevSymlinks(pwd); err != nil {
+		return err
+	}
+	return pivotRoot(pwd)
+}
+
+// 设置资源上限，会被用户命令继承
+func setUpRlimits(rlimits []Rlimit) error {
+	for _, rlimit := range rlimits {
+		resource, ok := rlimitTypes[rlimit.Type]
+		if !ok {
+			return fmt.Errorf("unknown rlimit type %s", rlimit.Type)
+		}
+		limit := &syscall.Rlimit{Cur: rlimit.Soft, Max: rlimit.Hard}
+		if err := syscall.Setrlimit(resource, limit); err != nil {
+			return fmt.Errorf("setrlimit %s error %v", rlimit.Type, err)
+		}
+	}
+	return nil
+}
 
-	//MS_NOEXEC:在本文件系统中不允许运行其他程序
-	//MS_NOSUID:在本系统中运行程序时，不允许set-user-ID或set-group-ID
-	//MS_NODEV:默认参数
-	defaultMountFlags := syscall.MS_NOEXEC | syscall.MS_NOSUID | syscall.MS_NODEV
-	//挂载proc文件系统
-	syscall.Mount("proc", "/proc", "proc", uintptr(defaultMountFlags), "")
-	//tmpfs是基于内存的文件系统，可以
-	syscall.Mount("tmpfs", "/dev", "tmpfs", syscall.MS_NOSUID|syscall.MS_STRICTATIME, "mode=755")
+// 切换到工作目录，目录不存在时先创建
+func setUpWorkDir(workDir string) error {
+	if workDir == "" {
+		return nil
+	}
+	if !filepath.IsAbs(workDir) {
+		return fmt.Errorf("working dir %s is not an absolute path", workDir)
+	}
+	if err := os.MkdirAll(workDir, 0755); err != nil {
+		return err
+	}
+	return syscall.Chdir(workDir)
+}
+
+// 切换到父进程解析好的用户，先设置附加组和主组，最后设置uid
+func setUpUser(uid, gid int, groups []int) error {
+	log.Infof("Run as uid %d gid %d", uid, gid)
+	if groups == nil {
+		groups = []int{}
+	}
+	if err := syscall.Setgroups(groups); err != nil {
+		return fmt.Errorf("setgroups error %v", err)
+	}
+	if err := syscall.Setgid(gid); err != nil {
+		return fmt.Errorf("setgid error %v", err)
+	}
+	if err := syscall.Setuid(uid); err != nil {
+		return fmt.Errorf("setuid error %v", err)
+	}
+	return nil
+}
+
+/*
+返回rootfs中name对应的宿主机路径
+在宿主机上访问rootfs时，路径中的符号链接可能指向rootfs之外，因此不允许路径中有符号链接
+*/
+func securePath(rootfs, name string) (string, error) {
+	current := rootfs
+	for _, part := range strings.Split(strings.Trim(filepath.Clean("/"+name), "/"), "/") {
+		if part == "" {
+			continue
+		}
+		current = filepath.Join(current, part)
+		info, err := os.Lstat(current)
+		if err != nil {
+			if os.IsNotExist(err) {
+				return filepath.Join(rootfs, filepath.Clean("/"+name)), nil
+			}
+			return "", err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return "", fmt.Errorf("%s in container must not be a symlink", strings.TrimPrefix(current, rootfs))
+		}
+	}
+	return current, nil
 }
 
 /*
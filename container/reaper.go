@@ -0,0 +1,78 @@
+package container
+
+import (
+	log "github.com/sirupsen/logrus"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+)
+
+/*
+--init模式下init进程留作容器的PID 1：
+1. 用户命令作为子进程在独立的进程组中运行，使用终端时这个进程组在前台
+2. 收到的信号转发给用户命令的进程组，PID 1没有默认的信号处理，用户命令自己作为PID 1时会忽略SIGTERM
+3. 回收所有退出的子进程，包括孤儿进程
+用户命令退出后返回它的退出码，被信号杀死时为128加信号值
+*/
+func runReaper(path string, spec *InitSpec) int {
+	//在启动用户命令之前注册，避免漏掉用户命令很快退出时的SIGCHLD
+	signals := make(chan os.Signal, 32)
+	signal.Notify(signals)
+
+	cmd := exec.Command(path)
+	cmd.Args = spec.Args
+	cmd.Env = spec.Env
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if spec.Terminal {
+		//用户命令的进程组成为终端的前台进程组，Ctrl-C等由终端直接发送给它
+		cmd.SysProcAttr.Foreground = true
+		cmd.SysProcAttr.Ctty = 0
+	}
+	if err := cmd.Start(); err != nil {
+		log.Errorf("Start %s error %v", path, err)
+		return 127
+	}
+	child := cmd.Process.Pid
+
+	for sig := range signals {
+		switch sig {
+		case syscall.SIGCHLD:
+			if exitCode, exited := reapChildren(child); exited {
+				return exitCode
+			}
+		case syscall.SIGURG:
+			//Go运行时用于抢占调度的信号，不需要转发
+		default:
+			if err := syscall.Kill(-child, sig.(syscall.Signal)); err == syscall.ESRCH {
+				syscall.Kill(child, sig.(syscall.Signal))
+			}
+		}
+	}
+	return 0
+}
+
+// 回收所有已经退出的子进程，用户命令退出时返回它的退出码
+func reapChildren(child int) (int, bool) {
+	exitCode, exited := 0, false
+	for {
+		var status syscall.WaitStatus
+		pid, err := syscall.Wait4(-1, &status, syscall.WNOHANG, nil)
+		if err == syscall.EINTR {
+			continue
+		}
+		if pid <= 0 || err != nil {
+			return exitCode, exited
+		}
+		if pid != child {
+			continue
+		}
+		exited = true
+		if status.Signaled() {
+			exitCode = 128 + int(status.Signal())
+		} else {
+			exitCode = status.ExitStatus()
+		}
+	}
+}
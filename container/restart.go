@@ -0,0 +1,55 @@
+package container
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// 容器的重启策略，由shim在容器退出后执行
+type RestartPolicy struct {
+	Name              string `json:"name"`                        //no、on-failure、always或unless-stopped
+	MaximumRetryCount int    `json:"maximumRetryCount,omitempty"` //on-failure最多重启的次数，0为不限制
+}
+
+/*
+解析--restart参数，格式为 no、on-failure[:最大重启次数]、always、unless-stopped
+没有守护进程，always和unless-stopped的行为一样：除了通过stop停止，容器退出后总是重启
+*/
+func ParseRestartPolicy(policy string) (RestartPolicy, error) {
+	name, count, hasCount := strings.Cut(policy, ":")
+	switch name {
+	case "", "no", "always", "unless-stopped":
+		if hasCount {
+			return RestartPolicy{}, fmt.Errorf("maximum retry count is only valid with on-failure")
+		}
+		if name == "" {
+			name = "no"
+		}
+		return RestartPolicy{Name: name}, nil
+	case "on-failure":
+		restartPolicy := RestartPolicy{Name: name}
+		if hasCount {
+			maxRetry, err := strconv.Atoi(count)
+			if err != nil || maxRetry < 0 {
+				return RestartPolicy{}, fmt.Errorf("invalid maximum retry count %s", count)
+			}
+			restartPolicy.MaximumRetryCount = maxRetry
+		}
+		return restartPolicy, nil
+	default:
+		return RestartPolicy{}, fmt.Errorf("invalid restart policy %s", policy)
+	}
+}
+
+// 容器以exitCode退出并且已经重启过restartCount次时，是否需要再次重启
+func (p RestartPolicy) ShouldRestart(exitCode, restartCount int) bool {
+	switch p.Name {
+	case "always", "unless-stopped":
+		return true
+	case "on-failure":
+		return exitCode != 0 && (p.MaximumRetryCount == 0 || restartCount < p.MaximumRetryCount)
+	default:
+		return false
+	}
+}
@@ -0,0 +1,69 @@
+package container
+
+import (
+	"testing"
+)
+
+func TestParseRestartPolicy(t *testing.T) {
+	tests := []struct {
+		policy  string
+		want    RestartPolicy
+		wantErr bool
+	}{
+		{policy: "", want: RestartPolicy{Name: "no"}},
+		{policy: "no", want: RestartPolicy{Name: "no"}},
+		{policy: "always", want: RestartPolicy{Name: "always"}},
+		{policy: "unless-stopped", want: RestartPolicy{Name: "unless-stopped"}},
+		{policy: "on-failure", want: RestartPolicy{Name: "on-failure"}},
+		{policy: "on-failure:3", want: RestartPolicy{Name: "on-failure", MaximumRetryCount: 3}},
+		{policy: "on-failure:0", want: RestartPolicy{Name: "on-failure"}},
+		{policy: "on-failure:", wantErr: true},
+		{policy: "on-failure:-1", wantErr: true},
+		{policy: "on-failure:x", wantErr: true},
+		{policy: "always:3", wantErr: true},
+		{policy: "no:1", wantErr: true},
+		{policy: "sometimes", wantErr: true},
+		{policy: "Always", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseRestartPolicy(tt.policy)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParseRestartPolicy(%q) = %+v, want error", tt.policy, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseRestartPolicy(%q) error %v", tt.policy, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseRestartPolicy(%q) = %+v, want %+v", tt.policy, got, tt.want)
+		}
+	}
+}
+
+func TestShouldRestart(t *testing.T) {
+	tests := []struct {
+		policy       RestartPolicy
+		exitCode     int
+		restartCount int
+		want         bool
+	}{
+		{policy: RestartPolicy{Name: "no"}, exitCode: 1, want: false},
+		{policy: RestartPolicy{}, exitCode: 1, want: false},
+		{policy: RestartPolicy{Name: "always"}, exitCode: 0, want: true},
+		{policy: RestartPolicy{Name: "always"}, exitCode: 137, restartCount: 100, want: true},
+		{policy: RestartPolicy{Name: "unless-stopped"}, exitCode: 0, want: true},
+		{policy: RestartPolicy{Name: "on-failure"}, exitCode: 0, want: false},
+		{policy: RestartPolicy{Name: "on-failure"}, exitCode: 1, restartCount: 100, want: true},
+		{policy: RestartPolicy{Name: "on-failure", MaximumRetryCount: 3}, exitCode: 1, restartCount: 2, want: true},
+		{policy: RestartPolicy{Name: "on-failure", MaximumRetryCount: 3}, exitCode: 1, restartCount: 3, want: false},
+		{policy: RestartPolicy{Name: "on-failure", MaximumRetryCount: 3}, exitCode: 0, restartCount: 0, want: false},
+	}
+	for _, tt := range tests {
+		if got := tt.policy.ShouldRestart(tt.exitCode, tt.restartCount); got != tt.want {
+			t.Errorf("%+v.ShouldRestart(%d, %d) = %v, want %v", tt.policy, tt.exitCode, tt.restartCount, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package container
+
+import (
+	"TinyDocker/image"
+	"encoding/json"
+	"fmt"
+	"golang.org/x/sys/unix"
+	"os"
+	"path"
+	"syscall"
+)
+
+// 父进程通过管道发送给容器init进程的配置，init进程按照它配置好容器后再执行用户命令
+type InitSpec struct {
+	Args           []string `json:"args"`                     //用户命令及参数
+	Env            []string `json:"env"`                      //用户命令的环境变量
+	Cwd            string   `json:"cwd"`                      //用户命令的工作目录
+	Hostname       string   `json:"hostname"`                 //容器的主机名
+	Terminal       bool     `json:"terminal"`                 //分配pty作为用户命令的控制终端
+	Uid            int      `json:"uid"`                      //用户命令运行的uid
+	Gid            int      `json:"gid"`                      //用户命令运行的gid
+	AdditionalGids []int    `json:"additionalGids,omitempty"` //附加组
+	Mounts         []Mount  `json:"mounts"`                   //pivot_root之前按顺序挂载到rootfs中
+	Devices        []Device `json:"devices"`                  //挂载完成后在/dev下创建的设备
+	Rlimits        []Rlimit `json:"rlimits,omitempty"`        //资源上限
+	Init           bool     `json:"init,omitempty"`           //init进程留作PID 1，用户命令作为它的子进程运行
+}
+
+// 容器内的挂载点，Destination为容器内的路径
+type Mount struct {
+	Source      string  `json:"source"`
+	Destination string  `json:"destination"`
+	Type        string  `json:"type"`
+	Flags       uintptr `json:"flags"`
+	Data        string  `json:"data,omitempty"`
+}
+
+// 进程的资源上限，Type为 RLIMIT_NOFILE 这样的名称
+type Rlimit struct {
+	Type string `json:"type"`
+	Hard uint64 `json:"hard"`
+	Soft uint64 `json:"soft"`
+}
+
+const (
+	// 镜像没有设置PATH时容器使用的PATH
+	DefaultPath = "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+	// /dev/shm默认的大小，和docker一样为64MB
+	DefaultShmSize = 64 << 20
+)
+
+// 支持的rlimit名称
+var rlimitTypes = map[string]int{
+	"RLIMIT_AS":      unix.RLIMIT_AS,
+	"RLIMIT_CORE":    unix.RLIMIT_CORE,
+	"RLIMIT_CPU":     unix.RLIMIT_CPU,
+	"RLIMIT_DATA":    unix.RLIMIT_DATA,
+	"RLIMIT_FSIZE":   unix.RLIMIT_FSIZE,
+	"RLIMIT_MEMLOCK": unix.RLIMIT_MEMLOCK,
+	"RLIMIT_NOFILE":  unix.RLIMIT_NOFILE,
+	"RLIMIT_NPROC":   unix.RLIMIT_NPROC,
+	"RLIMIT_STACK":   unix.RLIMIT_STACK,
+}
+
+// 容器默认的挂载点
+func defaultMounts() []Mount {
+	return []Mount{
+		{
+			//MS_NOEXEC:在本文件系统中不允许运行其他程序
+			//MS_NOSUID:在本系统中运行程序时，不允许set-user-ID或set-group-ID
+			Source:      "proc",
+			Destination: "/proc",
+			Type:        "proc",
+			Flags:       syscall.MS_NOEXEC | syscall.MS_NOSUID | syscall.MS_NODEV,
+		},
+		{
+			//tmpfs是基于内存的文件系统
+			Source:      "tmpfs",
+			Destination: "/dev",
+			Type:        "tmpfs",
+			Flags:       syscall.MS_NOSUID | syscall.MS_STRICTATIME,
+			Data:        "mode=755",
+		},
+		{
+			//newinstance使容器拥有独立的pty编号，/dev/ptmx指向这里的ptmx
+			Source:      "devpts",
+			Destination: "/dev/pts",
+			Type:        "devpts",
+			Flags:       syscall.MS_NOSUID | syscall.MS_NOEXEC,
+			Data:        "newinstance,ptmxmode=0666,mode=0620,gid=5",
+		},
+		{
+			Source:      "shm",
+			Destination: "/dev/shm",
+			Type:        "tmpfs",
+			Flags:       syscall.MS_NOSUID | syscall.MS_NOEXEC | syscall.MS_NODEV,
+			Data:        fmt.Sprintf("mode=1777,size=%d", DefaultShmSize),
+		},
+	}
+}
+
+// 修改/dev/shm的大小，size为字节数
+func (s *InitSpec) SetShmSize(size int64) {
+	for i := range s.Mounts {
+		if s.Mounts[i].Destination == "/dev/shm" {
+			s.Mounts[i].Data = fmt.Sprintf("mode=1777,size=%d", size)
+		}
+	}
+}
+
+// 容器默认的资源上限，打开文件数的soft limit提高到宿主机的hard limit
+func defaultRlimits() []Rlimit {
+	var nofile syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &nofile); err != nil {
+		return nil
+	}
+	return []Rlimit{
+		{Type: "RLIMIT_NOFILE", Hard: nofile.Max, Soft: nofile.Max},
+	}
+}
+
+/*
+根据镜像配置生成init进程的配置，rootfs为容器挂载后的根目录
+镜像配置中的用户在rootfs的/etc/passwd和/etc/group中查找
+env为镜像和用户指定的环境变量，不会继承宿主机的环境变量，只在默认的PATH、HOME、HOSTNAME和TERM之上覆盖
+*/
+func NewInitSpec(rootfs, hostname string, tty bool, args, env []string, config *image.ContainerConfig) (*InitSpec, error) {
+	if len(args) == 0 {
+		return nil, fmt.Errorf("no command specified")
+	}
+	spec := &InitSpec{
+		Args:     args,
+		Cwd:      "/",
+		Hostname: hostname,
+		Terminal: tty,
+		Mounts:   defaultMounts(),
+		Devices:  defaultDeviceList(),
+		Rlimits:  defaultRlimits(),
+	}
+	home := "/root"
+	if config != nil && config.WorkingDir != "" {
+		if !path.IsAbs(config.WorkingDir) {
+			return nil, fmt.Errorf("working dir %s is not an absolute path", config.WorkingDir)
+		}
+		spec.Cwd = path.Clean(config.WorkingDir)
+	}
+	if config != nil && config.User != "" {
+		user, err := lookupUser(rootfs, config.User)
+		if err != nil {
+			return nil, err
+		}
+		spec.Uid, spec.Gid, spec.AdditionalGids = user.Uid, user.Gid, user.Groups
+		home = user.Home
+	}
+
+	defaultEnv := []string{"PATH=" + DefaultPath, "HOSTNAME=" + hostname, "HOME=" + home}
+	if tty {
+		defaultEnv = append(defaultEnv, "TERM=xterm")
+	}
+	spec.Env = image.MergeEnv(defaultEnv, env)
+	return spec, nil
+}
+
+// 把配置以JSON的格式写入管道，写完后关闭管道，init进程读到EOF后开始配置容器
+func SendInitSpec(spec *InitSpec, writePipe *os.File) error {
+	defer writePipe.Close()
+	content, err := json.Marshal(spec)
+	if err != nil {
+		return fmt.Errorf("marshal init spec error %v", err)
+	}
+	if _, err := writePipe.Write(content); err != nil {
+		return fmt.Errorf("write init spec error %v", err)
+	}
+	return nil
+}
+
+// 从fd 3的管道中读取父进程发送的配置
+func readInitSpec() (*InitSpec, error) {
+	pipe := os.NewFile(uintptr(3), "pipe")
+	defer pipe.Close()
+	spec := &InitSpec{}
+	if err := json.NewDecoder(pipe).Decode(spec); err != nil {
+		return nil, fmt.Errorf("init read pipe error %v", err)
+	}
+	if len(spec.Args) == 0 {
+		return nil, fmt.Errorf("Run container get user command error, args is empty")
+	}
+	return spec, nil
+}
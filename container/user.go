@@ -0,0 +1,130 @@
+package container
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+var (
+	PasswdPath string = "/etc/passwd"
+	GroupPath  string = "/etc/group"
+)
+
+// 容器进程运行的用户
+type execUser struct {
+	Uid    int
+	Gid    int
+	Groups []int  //附加组
+	Home   string //用户的主目录，/etc/passwd中没有这个用户时为/
+}
+
+// 解析镜像配置中的User，格式为 user、uid、user:group 或者 uid:gid
+// 用户名和组名在容器rootfs的/etc/passwd和/etc/group中查找，数字形式的uid和gid可以不存在
+func lookupUser(rootfs, spec string) (*execUser, error) {
+	userPart, groupPart := spec, ""
+	if i := strings.Index(spec, ":"); i >= 0 {
+		userPart, groupPart = spec[:i], spec[i+1:]
+	}
+	passwd, err := readColonFile(rootfs, PasswdPath, 7)
+	if err != nil {
+		return nil, err
+	}
+	groups, err := readColonFile(rootfs, GroupPath, 4)
+	if err != nil {
+		return nil, err
+	}
+
+	user := &execUser{Home: "/"}
+	name := userPart
+	uid, uidErr := strconv.Atoi(userPart)
+	found := false
+	for _, entry := range passwd {
+		if entry[0] == userPart || (uidErr == nil && entry[2] == userPart) {
+			if user.Uid, err = strconv.Atoi(entry[2]); err != nil {
+				return nil, fmt.Errorf("invalid uid %s in %s", entry[2], PasswdPath)
+			}
+			if user.Gid, err = strconv.Atoi(entry[3]); err != nil {
+				return nil, fmt.Errorf("invalid gid %s in %s", entry[3], PasswdPath)
+			}
+			name = entry[0]
+			if entry[5] != "" {
+				user.Home = entry[5]
+			}
+			found = true
+			break
+		}
+	}
+	if !found {
+		if uidErr != nil {
+			return nil, fmt.Errorf("unable to find user %s", userPart)
+		}
+		user.Uid = uid
+	}
+
+	if groupPart != "" {
+		gid, gidErr := strconv.Atoi(groupPart)
+		found = false
+		for _, entry := range groups {
+			if entry[0] == groupPart || (gidErr == nil && entry[2] == groupPart) {
+				if user.Gid, err = strconv.Atoi(entry[2]); err != nil {
+					return nil, fmt.Errorf("invalid gid %s in %s", entry[2], GroupPath)
+				}
+				found = true
+				break
+			}
+		}
+		if !found {
+			if gidErr != nil {
+				return nil, fmt.Errorf("unable to find group %s", groupPart)
+			}
+			user.Gid = gid
+		}
+	}
+
+	//用户所属的附加组
+	user.Groups = []int{user.Gid}
+	for _, entry := range groups {
+		for _, member := range strings.Split(entry[3], ",") {
+			if member != name {
+				continue
+			}
+			if gid, err := strconv.Atoi(entry[2]); err == nil && gid != user.Gid {
+				user.Groups = append(user.Groups, gid)
+			}
+		}
+	}
+	return user, nil
+}
+
+// 读取容器rootfs中/etc/passwd、/etc/group这类以冒号分隔的文件，文件不存在时返回空
+func readColonFile(rootfs, name string, fields int) ([][]string, error) {
+	filePath, err := securePath(rootfs, name)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(filePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer file.Close()
+	var entries [][]string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		entry := strings.Split(line, ":")
+		if len(entry) < fields {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
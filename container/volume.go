@@ -1,18 +1,26 @@
 package container
 
 import (
+	"TinyDocker/image"
+	"TinyDocker/storage"
 	"fmt"
 	log "github.com/sirupsen/logrus"
 	"os"
-	"os/exec"
+	"path/filepath"
 	"strings"
+	"syscall"
 )
 
-// 为每个容器创建文件系统
+// 为每个容器创建文件系统，使用当前选择的存储驱动挂载rootfs
 func NewWorkSpace(volume, imageName, containerName string) {
-	CreateReadOnlyLayer(imageName)
+	driver, err := storage.GetDriver("")
+	if err != nil {
+		log.Errorf("Get storage driver error %v", err)
+		return
+	}
+	CreateReadOnlyLayer(imageName, driver)
 	CreateWriteLayer(containerName)
-	CreateMountPoint(containerName, imageName)
+	CreateMountPoint(containerName, imageName, driver)
 	//根据volume参数判断是否执行挂载数据卷操作
 	if volume != "" {
 		volumeURLs := strings.Split(volume, ":")
@@ -26,25 +34,22 @@ func NewWorkSpace(volume, imageName, containerName string) {
 	}
 }
 
-// 解压tar格式的镜像文件为只读层
-func CreateReadOnlyLayer(imageName string) error {
-	unTarFolderUrl := RootUrl + "/" + imageName + "/"
+// 准备镜像的只读层，镜像不在镜像存储中时把/root下tar格式的镜像文件作为单层镜像导入
+// 导入后的层按内容保存在层存储中，和其他镜像共享
+func CreateReadOnlyLayer(imageName string, driver storage.Driver) error {
+	if image.Exists(imageName) {
+		return nil
+	}
 	imageUrl := RootUrl + "/" + imageName + ".tar"
-	exist, err := PathExists(unTarFolderUrl)
+	file, err := os.Open(imageUrl)
 	if err != nil {
-		log.Infof("Fail to judge whether dir %s exists. %v", unTarFolderUrl, err)
+		log.Errorf("Open image %s error %v", imageUrl, err)
 		return err
 	}
-	if !exist {
-		if err := os.MkdirAll(unTarFolderUrl, 0622); err != nil {
-			log.Errorf("Mkdir %s error %v", unTarFolderUrl, err)
-			return err
-		}
-
-		if _, err := exec.Command("tar", "-xvf", imageUrl, "-C", unTarFolderUrl).CombinedOutput(); err != nil {
-			log.Errorf("Untar dir %s error %v", unTarFolderUrl, err)
-			return err
-		}
+	defer file.Close()
+	if err := image.ImportRootfs(file, imageName, driver); err != nil {
+		log.Errorf("Import image %s error %v", imageUrl, err)
+		return err
 	}
 	return nil
 }
@@ -68,32 +73,41 @@ func MountVolume(volumeURLs []string, containerName string) error {
 	containerUrl := volumeURLs[1]
 	mntURL := fmt.Sprintf(MntUrl, containerName)
 	containerVolumeURL := mntURL + "/" + containerUrl
-	if err := os.Mkdir(containerVolumeURL, 0777); err != nil {
+	//重新启动容器时数据卷可能还挂载着
+	if isMountPoint(containerVolumeURL) {
+		return nil
+	}
+	if err := os.MkdirAll(containerVolumeURL, 0777); err != nil {
 		log.Infof("Mkdir container dir %s error. %v", containerVolumeURL, err)
 	}
-	//将宿主机文件挂载到容器挂载点
-	dirs := "dirs=" + parentUrl
-	_, err := exec.Command("mount", "-t", "aufs", "-o", dirs, "none", containerVolumeURL).CombinedOutput()
-	if err != nil {
+	//将宿主机文件bind mount到容器挂载点
+	if err := syscall.Mount(parentUrl, containerVolumeURL, "", syscall.MS_BIND, ""); err != nil {
 		log.Errorf("Mount volume failed. %v", err)
 		return err
 	}
 	return nil
 }
 
-// 创建容器的根目录，把镜像的只读层和容器读写层挂载到容器根目录，成为容器文件系统
-func CreateMountPoint(containerName, imageName string) error {
+// 创建容器的根目录，通过存储驱动把镜像的只读层和容器读写层挂载到容器根目录，成为容器文件系统
+func CreateMountPoint(containerName, imageName string, driver storage.Driver) error {
 	mntUrl := fmt.Sprintf(MntUrl, containerName)
 	if err := os.MkdirAll(mntUrl, 0777); err != nil {
 		log.Errorf("Mkdir mountpoint dir %s error. %v", mntUrl, err)
 		return err
 	}
+	//停止的容器的rootfs保持挂载，重新启动时直接使用，宿主机重启后才需要重新挂载可写层
+	if isMountPoint(mntUrl) {
+		return nil
+	}
 	tmpWriteLayer := fmt.Sprintf(WriteLayerUrl, containerName)
-	tmpImageLocation := RootUrl + "/" + imageName
-	mntURL := fmt.Sprintf(MntUrl, containerName)
-	dirs := "dirs=" + tmpWriteLayer + ":" + tmpImageLocation
-	_, err := exec.Command("mount", "-t", "aufs", "-o", dirs, "none", mntURL).CombinedOutput()
+	workDir := fmt.Sprintf(WorkUrl, containerName)
+	//镜像的各层按从上到下的顺序作为只读层挂载在可写层下面
+	lowerDirs, err := image.LayerDirs(imageName, driver.Name())
 	if err != nil {
+		log.Errorf("Get read only layers of image %s error %v", imageName, err)
+		return err
+	}
+	if err := driver.Mount(lowerDirs, tmpWriteLayer, workDir, mntUrl); err != nil {
 		log.Errorf("Run command for creating mount point failed %v", err)
 		return err
 	}
@@ -103,23 +117,31 @@ func CreateMountPoint(containerName, imageName string) error {
 // 1. 只有在volume不为空时，并且使用volumeUrlExtract函数解析volume字符串返回的字符数组长度为2，数据元素不为空时，
 // 才执行DeleteMountPointWithVolume函数
 // 2. 其余情况任然使用DeleteMountPoint
-func DeleteWorkSpace(volume, containerName string) {
+// driverName为创建容器时使用的存储驱动，必须通过同一个驱动卸载
+func DeleteWorkSpace(volume, containerName, driverName string) {
+	driver, err := storage.GetDriver(driverName)
+	if err != nil {
+		log.Errorf("Get storage driver %s error %v", driverName, err)
+		return
+	}
 	if volume != "" {
 		volumeURLs := strings.Split(volume, ":")
 		length := len(volumeURLs)
 		if length == 2 && volumeURLs[0] != "" && volumeURLs[1] != "" {
-			DeleteMountPointWithVolume(volumeURLs, containerName)
+			DeleteMountPointWithVolume(volumeURLs, containerName, driver)
 		}
 	}
-	DeleteMountPoint(containerName)
+	DeleteMountPoint(containerName, driver)
 	DeleteWriteLayer(containerName)
 }
 
 // umount挂载点
-func DeleteMountPoint(containerName string) error {
+func DeleteMountPoint(containerName string, driver storage.Driver) error {
 	mntURL := fmt.Sprintf(MntUrl, containerName)
-	_, err := exec.Command("umount", mntURL).CombinedOutput()
-	if err != nil {
+	if exist, _ := PathExists(mntURL); !exist {
+		return nil
+	}
+	if err := driver.Unmount(mntURL); err != nil {
 		log.Errorf("Unmount %s error %v", mntURL, err)
 		return err
 	}
@@ -132,36 +154,29 @@ func DeleteMountPoint(containerName string) error {
 
 /*
 1. 首先卸载volume挂载点的文件系统(/root/mnt/${containerUrl}), 保证整个容器的挂载点没有被使用
-2. 然后卸载整个容器文件系统的挂载点(/root/mnt)
-3. 删除容器文件系统挂载点
+2. 然后通过存储驱动卸载整个容器文件系统的挂载点(/root/mnt)，并删除挂载点
 */
-func DeleteMountPointWithVolume(volumeURLs []string, containerName string) error {
+func DeleteMountPointWithVolume(volumeURLs []string, containerName string, driver storage.Driver) error {
 	//卸载容器里volume挂载点的文件系统
 	mntURL := fmt.Sprintf(MntUrl, containerName)
 	containerUrl := mntURL + "/" + volumeURLs[1]
-	if _, err := exec.Command("umount", containerUrl).CombinedOutput(); err != nil {
+	if err := syscall.Unmount(containerUrl, 0); err != nil {
 		log.Errorf("Umount volume %s failed. %v", containerUrl, err)
 		return err
 	}
-	//卸载整个容器文件系统的挂载点
-	if _, err := exec.Command("umount", mntURL).CombinedOutput(); err != nil {
-		log.Errorf("Umount mountpoint %s failed. %v", mntURL, err)
-		return err
-	}
-	//删除容器文件系统挂载点
-	if err := os.RemoveAll(mntURL); err != nil {
-		log.Errorf("Remove mountpoint dir %s error %v", mntURL, err)
-	}
-
-	return nil
+	return DeleteMountPoint(containerName, driver)
 }
 
-// 删除容器读写层
+// 删除容器读写层和存储驱动的工作目录
 func DeleteWriteLayer(containerName string) {
 	writeURL := fmt.Sprintf(WriteLayerUrl, containerName)
 	if err := os.RemoveAll(writeURL); err != nil {
 		log.Infof("Remove writeLayer dir %s error %v", writeURL, err)
 	}
+	workURL := fmt.Sprintf(WorkUrl, containerName)
+	if err := os.RemoveAll(workURL); err != nil {
+		log.Infof("Remove work dir %s error %v", workURL, err)
+	}
 }
 
 // 判断文件路径是否存在
@@ -175,3 +190,15 @@ func PathExists(path string) (bool, error) {
 	}
 	return false, err
 }
+
+// 判断路径是否是挂载点，挂载点和它的上级目录在不同的文件系统中
+func isMountPoint(path string) bool {
+	var stat, parentStat syscall.Stat_t
+	if err := syscall.Stat(path, &stat); err != nil {
+		return false
+	}
+	if err := syscall.Stat(filepath.Dir(filepath.Clean(path)), &parentStat); err != nil {
+		return false
+	}
+	return stat.Dev != parentStat.Dev
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+/*
+读取--env-file指定的文件，每行一个 KEY=VALUE，空行和#开头的行会被忽略
+行首的export会被去掉，值两边成对的引号会被去掉，只有KEY没有=时使用宿主机上同名的环境变量
+*/
+func readEnvFile(filePath string) ([]string, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var envs []string
+	scanner := bufio.NewScanner(file)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimSpace(strings.TrimPrefix(line, "export "))
+		key, value := line, ""
+		hasValue := false
+		if i := strings.Index(line, "="); i >= 0 {
+			key, value, hasValue = strings.TrimSpace(line[:i]), strings.TrimSpace(line[i+1:]), true
+		}
+		if key == "" || strings.ContainsAny(key, " \t") {
+			return nil, fmt.Errorf("%s line %d: invalid variable name %q", filePath, lineNo, key)
+		}
+		if !hasValue {
+			if hostValue, ok := os.LookupEnv(key); ok {
+				envs = append(envs, key+"="+hostValue)
+			}
+			continue
+		}
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		envs = append(envs, key+"="+value)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return envs, nil
+}
+
+// 合并--env-file和-e指定的环境变量，-e中的变量覆盖文件中的同名变量
+func getEnvSlice(envFiles, envs []string) ([]string, error) {
+	var result []string
+	for _, envFile := range envFiles {
+		fileEnvs, err := readEnvFile(envFile)
+		if err != nil {
+			return nil, fmt.Errorf("read env file %s error %v", envFile, err)
+		}
+		result = append(result, fileEnvs...)
+	}
+	return append(result, envs...), nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestReadEnvFile(t *testing.T) {
+	t.Setenv("MYDOCKER_TEST_HOST", "from host")
+	os.Unsetenv("MYDOCKER_TEST_UNSET")
+	tests := []struct {
+		name    string
+		content string
+		want    []string
+		wantErr bool
+	}{
+		{name: "plain", content: "A=1\nB=two words\n", want: []string{"A=1", "B=two words"}},
+		{name: "comments and blank lines", content: "# comment\n\n  \nA=1\n  # indented comment\n", want: []string{"A=1"}},
+		{name: "spaces around", content: "  A = 1  \n", want: []string{"A=1"}},
+		{name: "empty value", content: "A=\n", want: []string{"A="}},
+		{name: "value with equals", content: "URL=http://host/?a=b\n", want: []string{"URL=http://host/?a=b"}},
+		{name: "double quotes", content: `A="quoted value"` + "\n", want: []string{"A=quoted value"}},
+		{name: "single quotes", content: "A='single # quoted'\n", want: []string{"A=single # quoted"}},
+		{name: "empty quotes", content: `A=""` + "\n", want: []string{"A="}},
+		{name: "unmatched quotes", content: `A="open` + "\nB='mixed\"\n", want: []string{`A="open`, `B='mixed"`}},
+		{name: "inner quotes", content: `A=say "hi"` + "\n", want: []string{`A=say "hi"`}},
+		{name: "export", content: "export A=1\nexport  B='2'\n", want: []string{"A=1", "B=2"}},
+		{name: "host passthrough", content: "MYDOCKER_TEST_HOST\n", want: []string{"MYDOCKER_TEST_HOST=from host"}},
+		{name: "exported host passthrough", content: "export MYDOCKER_TEST_HOST\n", want: []string{"MYDOCKER_TEST_HOST=from host"}},
+		{name: "host variable not set", content: "MYDOCKER_TEST_UNSET\nA=1\n", want: []string{"A=1"}},
+		{name: "empty file", content: "", want: nil},
+		{name: "missing name", content: "=1\n", wantErr: true},
+		{name: "name with space", content: "A B=1\n", wantErr: true},
+	}
+	for _, tt := range tests {
+		filePath := filepath.Join(t.TempDir(), "env")
+		if err := os.WriteFile(filePath, []byte(tt.content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		got, err := readEnvFile(filePath)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s: readEnvFile() = %q, want error", tt.name, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: readEnvFile() error %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: readEnvFile() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestReadEnvFileNotExist(t *testing.T) {
+	if _, err := readEnvFile(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Errorf("readEnvFile() of missing file succeeded, want error")
+	}
+}
+
+func TestGetEnvSlice(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "env")
+	if err := os.WriteFile(filePath, []byte("A=file\nB=file\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	//-e中的变量放在后面，覆盖文件中的同名变量
+	got, err := getEnvSlice([]string{filePath}, []string{"A=flag"})
+	if err != nil {
+		t.Fatalf("getEnvSlice() error %v", err)
+	}
+	if want := []string{"A=file", "B=file", "A=flag"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("getEnvSlice() = %q, want %q", got, want)
+	}
+}
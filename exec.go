@@ -2,61 +2,105 @@ package main
 
 import (
 	"TinyDocker/container"
-	"encoding/json"
+	_ "TinyDocker/nsenter"
 	"fmt"
 	log "github.com/sirupsen/logrus"
 	"io/ioutil"
 	"os"
 	"os/exec"
+	"strconv"
 	"strings"
 )
 
 // 控制是否执行c代码中的setns
 const ENV_EXEC_PID = "mydocker_pid"
-const ENV_EXEC_CMD = "mydocker_cmd"
+const ENV_EXEC_TTY = "mydocker_tty"
 
-func ExecContainer(containerName string, comArray []string) {
+// c代码在容器内执行命令前切换到的工作目录和用户
+const ENV_EXEC_CWD = "mydocker_cwd"
+const ENV_EXEC_UID = "mydocker_uid"
+const ENV_EXEC_GID = "mydocker_gid"
+const ENV_EXEC_GROUPS = "mydocker_groups"
+
+func ExecContainer(containerName string, comArray []string, tty bool) {
 	//获取PID
-	pid, err := GetContainerPidByName(containerName)
+	containerInfo, err := getContainerInfoByName(containerName)
 	if err != nil {
-		log.Errorf("Exec container getContainerPidByName %s error %v", containerName, err)
+		log.Errorf("Exec container getContainerInfoByName %s error %v", containerName, err)
 		return
 	}
-	//拼接命令字符串
-	cmdStr := strings.Join(comArray, " ")
+	pid := containerInfo.Pid
 	log.Infof("container pid %s", pid)
-	log.Infof("command %s", cmdStr)
+	log.Infof("command %q", comArray)
 	//当容器名和对应命令传递进来后，exec程序就已经执行了，c代码也运行完毕了
 	//这时候需要fork一个进程，把这个进程的输入输出绑定到宿主机上，然后run，实际上是有运行了一遍自己的程序
 	//在一次运行时已经指定了环境变量，c代码拿到变量就可以进入指定的namespace中进行操作
-	cmd := exec.Command("/proc/self/exe", "exec")
+	//命令的每个参数原样放在exec之后，c代码从/proc/self/cmdline中读出后直接execvp，不经过shell
+	cmd := exec.Command("/proc/self/exe", append([]string{"exec"}, comArray...)...)
 	cmd.Stdin = os.Stdin
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
+	//-ti时在容器的devpts中分配pty，作为exec进程的控制终端
+	var console, slave *os.File
+	if tty {
+		if console, slave, err = container.OpenContainerPty(pid); err != nil {
+			log.Errorf("Open pty in container %s error %v", containerName, err)
+			return
+		}
+		cmd.Stdin, cmd.Stdout, cmd.Stderr = slave, slave, slave
+	}
 
-	os.Setenv(ENV_EXEC_PID, pid)
-	os.Setenv(ENV_EXEC_CMD, cmdStr)
-	//获取容器的环境变量，并放置到exec进程中
-	containerEnvs := getEnvsByPid(pid)
-	cmd.Env = append(os.Environ(), containerEnvs...)
+	//获取容器的环境变量，并放置到exec进程中，不继承宿主机的环境变量
+	//使用--init时PID 1是init，它的environ是空的，所以使用启动时记录的环境变量，旧版本创建的容器没有记录时才读取/proc
+	containerEnvs := containerInfo.ProcessEnv
+	if containerEnvs == nil {
+		containerEnvs = getEnvsByPid(pid)
+	}
+	cmd.Env = append([]string{ENV_EXEC_PID + "=" + pid}, execUserEnv(containerInfo)...)
+	cmd.Env = append(cmd.Env, containerEnvs...)
+	if tty {
+		//c代码在容器内的进程中把pty设置为控制终端
+		cmd.Env = append(cmd.Env, ENV_EXEC_TTY+"=1")
+	}
 
-	if err := cmd.Run(); err != nil {
+	if console == nil {
+		if err := cmd.Run(); err != nil {
+			log.Errorf("Exec container %s error %v", containerName, err)
+		}
+		return
+	}
+	err = cmd.Start()
+	//关闭父进程中的slave，exec进程退出后读master才会返回EIO
+	slave.Close()
+	if err != nil {
+		log.Errorf("Exec container %s error %v", containerName, err)
+		console.Close()
+		return
+	}
+	if err := container.ProxyConsole(console); err != nil {
+		log.Errorf("Proxy console error %v", err)
+	}
+	if err := cmd.Wait(); err != nil {
 		log.Errorf("Exec container %s error %v", containerName, err)
 	}
 }
 
-func GetContainerPidByName(containerName string) (string, error) {
-	dirURL := fmt.Sprintf(container.DefaultInfoLocation, containerName)
-	configFilePath := dirURL + container.ConfigName
-	contentBytes, err := ioutil.ReadFile(configFilePath)
-	if err != nil {
-		return "", err
+// 容器进程的工作目录和用户，旧版本创建的容器没有记录时以root在/下运行
+func execUserEnv(containerInfo *container.ContainerInfo) []string {
+	cwd := containerInfo.ProcessCwd
+	if cwd == "" {
+		cwd = "/"
+	}
+	var groups []string
+	for _, gid := range containerInfo.ProcessGroups {
+		groups = append(groups, strconv.Itoa(gid))
 	}
-	var containerInfo container.ContainerInfo
-	if err := json.Unmarshal(contentBytes, &containerInfo); err != nil {
-		return "", err
+	return []string{
+		ENV_EXEC_CWD + "=" + cwd,
+		ENV_EXEC_UID + "=" + strconv.Itoa(containerInfo.ProcessUid),
+		ENV_EXEC_GID + "=" + strconv.Itoa(containerInfo.ProcessGid),
+		ENV_EXEC_GROUPS + "=" + strings.Join(groups, ","),
 	}
-	return containerInfo.Pid, nil
 }
 
 func getEnvsByPid(pid string) []string {
@@ -0,0 +1,114 @@
+package image
+
+import (
+	"TinyDocker/storage"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// 提交容器时记录到新镜像配置中的信息
+type CommitInfo struct {
+	Author  string
+	Message string
+	Cmd     []string //容器运行的命令，作为新镜像的Cmd
+	Env     []string //容器的环境变量，覆盖父镜像中同名的变量
+}
+
+// 把容器的可写层作为父镜像之上新的一层，生成新镜像并打上标签imageName
+func Commit(parentName, imageName, writeDir string, driver storage.Driver, info *CommitInfo) (*Image, error) {
+	if _, err := ParseReference(imageName); err != nil {
+		return nil, err
+	}
+	parent, err := GetImage(parentName)
+	if err != nil {
+		return nil, err
+	}
+	if parent.Driver != driver.Name() {
+		return nil, fmt.Errorf("image %s was imported with storage driver %s, can not commit with %s", parentName, parent.Driver, driver.Name())
+	}
+	unlock, err := LockLayers()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	diffID, err := CommitLayer(writeDir, driver)
+	if err != nil {
+		return nil, err
+	}
+	history := History{
+		CreatedBy: strings.Join(info.Cmd, " "),
+		Author:    info.Author,
+		Comment:   info.Message,
+	}
+	return Derive(parent, imageName, diffID, history, func(config *ImageConfig) {
+		config.Author = info.Author
+		config.Config.Env = MergeEnv(parent.Config.Config.Env, info.Env)
+		if len(info.Cmd) > 0 {
+			config.Config.Cmd = info.Cmd
+		}
+	})
+}
+
+// 在父镜像的基础上生成新镜像，diffID不为空时在最上面增加一层，update用于修改新镜像的配置
+// imageName为空时新镜像不打标签，只能通过镜像ID引用
+func Derive(parent *Image, imageName, diffID string, history History, update func(config *ImageConfig)) (*Image, error) {
+	now := time.Now().UTC().Format(time.RFC3339)
+	config := parent.Config
+	config.Created = now
+	//复制切片和map，避免修改父镜像的配置
+	config.Config.Env = append([]string{}, parent.Config.Config.Env...)
+	config.RootFS.DiffIDs = append([]string{}, parent.Config.RootFS.DiffIDs...)
+	config.History = append([]History{}, parent.Config.History...)
+	layers := append([]string{}, parent.Layers...)
+	if diffID != "" {
+		config.RootFS.DiffIDs = append(config.RootFS.DiffIDs, diffID)
+		layers = append(layers, diffID)
+	}
+	history.Created = now
+	history.EmptyLayer = diffID == ""
+	config.History = append(config.History, history)
+	if update != nil {
+		update(&config)
+	}
+	img := &Image{
+		Parent: parent.Id,
+		Driver: parent.Driver,
+		Layers: layers,
+		Config: config,
+	}
+	if err := saveAndTag(img, imageName); err != nil {
+		return nil, err
+	}
+	return img, nil
+}
+
+// 容器要运行的命令，为Entrypoint加上args，args为空时使用镜像的Cmd
+func (c *ContainerConfig) Command(args []string) []string {
+	if len(args) == 0 {
+		args = c.Cmd
+	}
+	return append(append([]string{}, c.Entrypoint...), args...)
+}
+
+// 合并环境变量，override中的变量覆盖base中同名的变量
+func MergeEnv(base, override []string) []string {
+	result := append([]string{}, base...)
+	index := make(map[string]int)
+	for i, env := range result {
+		index[envKey(env)] = i
+	}
+	for _, env := range override {
+		if i, ok := index[envKey(env)]; ok {
+			result[i] = env
+			continue
+		}
+		index[envKey(env)] = len(result)
+		result = append(result, env)
+	}
+	return result
+}
+
+func envKey(env string) string {
+	return strings.SplitN(env, "=", 2)[0]
+}
@@ -0,0 +1,124 @@
+package image
+
+import (
+	"TinyDocker/storage"
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// 把目录打包成tar流写入w，driver不为空时把驱动格式的whiteout转换成镜像中的.wh.文件
+func WriteDiff(dir string, driver storage.Driver, w io.Writer) error {
+	tw := tar.NewWriter(w)
+	//同一个inode的多个硬链接只打包一次内容，其余的作为硬链接
+	inodes := make(map[uint64]string)
+	err := filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			return nil
+		}
+		if driver != nil {
+			if whiteout, ok := driver.Whiteout(path, fi); ok {
+				if whiteout == "" {
+					if fi.IsDir() {
+						return filepath.SkipDir
+					}
+					return nil
+				}
+				return tw.WriteHeader(&tar.Header{
+					Name:     filepath.Join(filepath.Dir(rel), whiteout),
+					Typeflag: tar.TypeReg,
+					Mode:     0600,
+					ModTime:  fi.ModTime().Truncate(time.Second),
+				})
+			}
+		}
+
+		link := ""
+		if fi.Mode()&os.ModeSymlink != 0 {
+			if link, err = os.Readlink(path); err != nil {
+				return err
+			}
+		}
+		hdr, err := tar.FileInfoHeader(fi, link)
+		if err != nil {
+			return err
+		}
+		hdr.Name = rel
+		if fi.IsDir() {
+			hdr.Name += "/"
+		}
+		//只保留文件系统中有意义的属性，不记录宿主机的用户名和访问时间
+		hdr.Uname, hdr.Gname = "", ""
+		hdr.AccessTime, hdr.ChangeTime = time.Time{}, time.Time{}
+		hdr.ModTime = hdr.ModTime.Truncate(time.Second)
+		if stat, ok := fi.Sys().(*syscall.Stat_t); ok {
+			hdr.Uid, hdr.Gid = int(stat.Uid), int(stat.Gid)
+			if fi.Mode().IsRegular() && stat.Nlink > 1 {
+				if first, ok := inodes[stat.Ino]; ok {
+					hdr.Typeflag = tar.TypeLink
+					hdr.Linkname = first
+					hdr.Size = 0
+				} else {
+					inodes[stat.Ino] = rel
+				}
+			}
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if hdr.Typeflag == tar.TypeReg {
+			file, err := os.Open(path)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(tw, file)
+			file.Close()
+			if err != nil {
+				return err
+			}
+		}
+		if fi.IsDir() && driver != nil && driver.IsOpaque(path) {
+			return tw.WriteHeader(&tar.Header{
+				Name:     filepath.Join(rel, storage.WhiteoutOpaqueDir),
+				Typeflag: tar.TypeReg,
+				Mode:     0600,
+				ModTime:  fi.ModTime().Truncate(time.Second),
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+// 把容器的可写层作为新的一层保存到层存储中，返回这一层的diff_id
+func CommitLayer(writeDir string, driver storage.Driver) (string, error) {
+	reader, writer := io.Pipe()
+	go func() {
+		writer.CloseWithError(WriteDiff(writeDir, driver, writer))
+	}()
+	tmpDest, diffID, err := unpackToTemp(reader, driver)
+	//unpack出错时需要让WriteDiff退出
+	reader.CloseWithError(fmt.Errorf("layer committed"))
+	if err != nil {
+		return "", fmt.Errorf("commit layer %s error %v", writeDir, err)
+	}
+	defer os.RemoveAll(tmpDest)
+	if err := commitLayer(tmpDest, driver.Name(), diffID); err != nil {
+		return "", err
+	}
+	return diffID, nil
+}
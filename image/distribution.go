@@ -0,0 +1,72 @@
+package image
+
+import (
+	"strings"
+)
+
+var (
+	// 层在镜像仓库中的信息，本地层的diff_id到distributionInfo的映射
+	DistributionName string = "distribution.json"
+	// pull时下载中的blob，中断后从已下载的位置继续
+	DownloadDirName string = "downloads"
+	// push时重新打包的层和上传会话，中断后继续上传
+	UploadDirName string = "uploads"
+)
+
+// 传输中断时自动重试的次数，每次都从中断的位置继续
+const transferRetries = 3
+
+// 本地的一层在镜像仓库中对应的blob，用于push时跳过仓库中已有的层以及从同一仓库的其他镜像挂载
+type distributionInfo struct {
+	Digest    string   `json:"digest"` //压缩后blob的digest
+	Size      int64    `json:"size"`
+	MediaType string   `json:"mediaType"`
+	DiffID    string   `json:"diffId"` //blob解压后的digest，本地层重新打包后可能和本地的diff_id不同
+	Repos     []string `json:"repos"`  //已经有这个blob的镜像，如 localhost:5000/team/app
+}
+
+func (info *distributionInfo) descriptor() Descriptor {
+	return Descriptor{MediaType: info.MediaType, Digest: info.Digest, Size: info.Size}
+}
+
+func getDistribution(diffID string) (*distributionInfo, error) {
+	infos := make(map[string]*distributionInfo)
+	if err := loadJSON(DistributionName, &infos); err != nil {
+		return nil, err
+	}
+	return infos[diffID], nil
+}
+
+// 记录本地的一层对应的blob已经在仓库repo中
+func recordDistribution(diffID string, desc Descriptor, blobDiffID, repo string) error {
+	infos := make(map[string]*distributionInfo)
+	if err := loadJSON(DistributionName, &infos); err != nil {
+		return err
+	}
+	info := infos[diffID]
+	if info == nil || info.Digest != desc.Digest {
+		info = &distributionInfo{Digest: desc.Digest, Size: desc.Size, MediaType: desc.MediaType, DiffID: blobDiffID}
+		infos[diffID] = info
+	}
+	if !contains(info.Repos, repo) {
+		info.Repos = append(info.Repos, repo)
+	}
+	return saveJSON(DistributionName, infos)
+}
+
+// 拆分 registry/repository
+func splitRepo(repo string) (string, string) {
+	i := strings.Index(repo, "/")
+	if i < 0 {
+		return "", repo
+	}
+	return repo[:i], repo[i+1:]
+}
+
+func shortDigest(digest string) string {
+	digest = strings.TrimPrefix(digest, "sha256:")
+	if len(digest) > 12 {
+		return digest[:12]
+	}
+	return digest
+}
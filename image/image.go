@@ -0,0 +1,264 @@
+package image
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	// 镜像的元数据保存在这个目录下
+	ImageRoot string = "/root/images"
+	// 保存镜像元数据的目录，每个镜像一个以镜像ID命名的文件，记录层的顺序和镜像配置
+	ImageDBName string = "imagedb"
+)
+
+// 镜像元数据
+type Image struct {
+	Id     string      `json:"-"`                //镜像配置的digest，也就是元数据文件名
+	Parent string      `json:"parent,omitempty"` //commit和build时的父镜像ID
+	Driver string      `json:"driver"`           //解压各层时使用的存储驱动，决定了层中whiteout的格式
+	Layers []string    `json:"layers"`           //各层解压后内容的digest(diff_id)，从最底层到最上层
+	Config ImageConfig `json:"config"`           //OCI格式的镜像配置
+	//原始的镜像配置，镜像ID就是它的digest，导入和pull的镜像保留镜像中原来的内容，不会丢掉ImageConfig中没有的字段
+	RawConfig []byte `json:"-"`
+}
+
+// OCI镜像配置，见 https://github.com/opencontainers/image-spec/blob/main/config.md
+type ImageConfig struct {
+	Created      string          `json:"created,omitempty"`
+	Author       string          `json:"author,omitempty"`
+	Architecture string          `json:"architecture,omitempty"`
+	OS           string          `json:"os,omitempty"`
+	Config       ContainerConfig `json:"config"`
+	RootFS       RootFS          `json:"rootfs"`
+	History      []History       `json:"history,omitempty"`
+}
+
+// 镜像中运行容器时使用的默认配置
+type ContainerConfig struct {
+	User         string              `json:"User,omitempty"`
+	ExposedPorts map[string]struct{} `json:"ExposedPorts,omitempty"`
+	Env          []string            `json:"Env,omitempty"`
+	Entrypoint   []string            `json:"Entrypoint,omitempty"`
+	Cmd          []string            `json:"Cmd,omitempty"`
+	Volumes      map[string]struct{} `json:"Volumes,omitempty"`
+	WorkingDir   string              `json:"WorkingDir,omitempty"`
+	Labels       map[string]string   `json:"Labels,omitempty"`
+	StopSignal   string              `json:"StopSignal,omitempty"`
+}
+
+type RootFS struct {
+	Type    string   `json:"type"`
+	DiffIDs []string `json:"diff_ids"`
+}
+
+type History struct {
+	Created    string `json:"created,omitempty"`
+	CreatedBy  string `json:"created_by,omitempty"`
+	Author     string `json:"author,omitempty"`
+	Comment    string `json:"comment,omitempty"`
+	EmptyLayer bool   `json:"empty_layer,omitempty"`
+}
+
+// 镜像元数据文件的路径，以镜像ID命名
+func imagePath(id string) string {
+	return path.Join(ImageRoot, ImageDBName, strings.TrimPrefix(id, "sha256:")+".json")
+}
+
+// 镜像原始配置文件的路径，和元数据文件放在一起
+func configPath(id string) string {
+	return path.Join(ImageRoot, ImageDBName, strings.TrimPrefix(id, "sha256:")+".config")
+}
+
+// 判断镜像是否已经导入到镜像存储中，ref可以是镜像名、镜像ID或者ID的前缀
+func Exists(ref string) bool {
+	if ref == "" {
+		return false
+	}
+	_, err := Resolve(ref)
+	return err == nil
+}
+
+// 读取镜像的元数据
+func GetImage(ref string) (*Image, error) {
+	id, err := Resolve(ref)
+	if err != nil {
+		return nil, err
+	}
+	return getImageByID(id)
+}
+
+func getImageByID(id string) (*Image, error) {
+	content, err := ioutil.ReadFile(imagePath(id))
+	if err != nil {
+		return nil, fmt.Errorf("read image %s error %v", id, err)
+	}
+	var img Image
+	if err := json.Unmarshal(content, &img); err != nil {
+		return nil, fmt.Errorf("unmarshal image %s error %v", id, err)
+	}
+	img.Id = id
+	//旧版本保存的镜像没有原始配置文件，镜像ID是元数据中配置的digest
+	raw, err := ioutil.ReadFile(configPath(id))
+	if os.IsNotExist(err) {
+		if img.RawConfig, err = json.Marshal(img.Config); err != nil {
+			return nil, fmt.Errorf("marshal image %s config error %v", id, err)
+		}
+		return &img, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read image %s config error %v", id, err)
+	}
+	img.RawConfig = raw
+	if err := json.Unmarshal(raw, &img.Config); err != nil {
+		return nil, fmt.Errorf("unmarshal image %s config error %v", id, err)
+	}
+	return &img, nil
+}
+
+// 保存镜像的元数据和原始配置，镜像的ID为原始配置的digest
+// RawConfig为空时(commit、build和import生成的镜像)使用Config序列化后的内容
+func saveImage(img *Image) error {
+	if img.RawConfig == nil {
+		config, err := json.Marshal(img.Config)
+		if err != nil {
+			return fmt.Errorf("marshal image config error %v", err)
+		}
+		img.RawConfig = config
+	}
+	img.Id = digestOf(img.RawConfig)
+	if err := os.MkdirAll(path.Join(ImageRoot, ImageDBName), 0755); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(configPath(img.Id), img.RawConfig, 0644); err != nil {
+		return fmt.Errorf("write file %s error %v", configPath(img.Id), err)
+	}
+	content, err := json.Marshal(img)
+	if err != nil {
+		return fmt.Errorf("marshal image %s error %v", img.Id, err)
+	}
+	if err := ioutil.WriteFile(imagePath(img.Id), content, 0644); err != nil {
+		return fmt.Errorf("write file %s error %v", imagePath(img.Id), err)
+	}
+	return nil
+}
+
+/*
+返回把rootfs的diff_ids替换为diffIDs后的镜像配置，push和save重新打包的层可能和原来的tar不完全一样
+diff_ids没有变化时直接返回原始配置，保证镜像ID不变，否则只替换rootfs，保留原始配置中的其他字段
+*/
+func (img *Image) configWithDiffIDs(diffIDs []string) ([]byte, error) {
+	same := len(diffIDs) == len(img.Config.RootFS.DiffIDs)
+	for i := 0; same && i < len(diffIDs); i++ {
+		same = diffIDs[i] == img.Config.RootFS.DiffIDs[i]
+	}
+	if same && img.RawConfig != nil {
+		return img.RawConfig, nil
+	}
+	fields := make(map[string]json.RawMessage)
+	if img.RawConfig != nil {
+		if err := json.Unmarshal(img.RawConfig, &fields); err != nil {
+			return nil, fmt.Errorf("unmarshal image %s config error %v", img.Id, err)
+		}
+	} else {
+		config, err := json.Marshal(img.Config)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(config, &fields); err != nil {
+			return nil, err
+		}
+	}
+	rootfs, err := json.Marshal(RootFS{Type: "layers", DiffIDs: diffIDs})
+	if err != nil {
+		return nil, err
+	}
+	fields["rootfs"] = rootfs
+	return json.Marshal(fields)
+}
+
+// 保存镜像，ref不为空时给镜像打上这个标签
+func saveAndTag(img *Image, ref string) error {
+	if ref != "" {
+		if _, err := ParseReference(ref); err != nil {
+			return err
+		}
+	}
+	if err := saveImage(img); err != nil {
+		return err
+	}
+	if ref == "" {
+		return nil
+	}
+	return Tag(img.Id, ref)
+}
+
+// 读取所有镜像的元数据
+func ListImages() ([]*Image, error) {
+	files, err := ioutil.ReadDir(path.Join(ImageRoot, ImageDBName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var images []*Image
+	for _, file := range files {
+		if !strings.HasSuffix(file.Name(), ".json") {
+			continue
+		}
+		img, err := getImageByID("sha256:" + strings.TrimSuffix(file.Name(), ".json"))
+		if err != nil {
+			return nil, err
+		}
+		images = append(images, img)
+	}
+	return images, nil
+}
+
+// 返回镜像各层的目录，从最上层到最底层，用于按顺序叠加挂载成容器的rootfs
+// 各层中的whiteout只有导入时使用的存储驱动才能识别，driver不一致时返回错误
+func LayerDirs(ref, driver string) ([]string, error) {
+	img, err := GetImage(ref)
+	if err != nil {
+		return nil, err
+	}
+	if img.Driver != "" && img.Driver != driver {
+		return nil, fmt.Errorf("image %s was imported with storage driver %s, can not be used with %s", ref, img.Driver, driver)
+	}
+	//同一层出现多次时只保留最上面的一次，上层的内容和whiteout已经覆盖了下层相同的那一层
+	dirs := make([]string, 0, len(img.Layers))
+	seen := make(map[string]bool)
+	for i := len(img.Layers) - 1; i >= 0; i-- {
+		if seen[img.Layers[i]] {
+			continue
+		}
+		seen[img.Layers[i]] = true
+		dirs = append(dirs, layerDir(img.Driver, img.Layers[i]))
+	}
+	return dirs, nil
+}
+
+// 镜像所有层解压后占用的磁盘空间
+func (img *Image) Size() int64 {
+	var size int64
+	seen := make(map[string]bool)
+	for _, diffID := range img.Layers {
+		if seen[diffID] {
+			continue
+		}
+		seen[diffID] = true
+		filepath.Walk(layerDir(img.Driver, diffID), func(p string, fi os.FileInfo, err error) error {
+			if err == nil && fi.Mode().IsRegular() {
+				size += fi.Size()
+			}
+			return nil
+		})
+	}
+	return size
+}
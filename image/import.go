@@ -0,0 +1,440 @@
+package image
+
+import (
+	"TinyDocker/storage"
+	"encoding/json"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+const (
+	MediaTypeImageIndex    = "application/vnd.oci.image.index.v1+json"
+	MediaTypeImageManifest = "application/vnd.oci.image.manifest.v1+json"
+	MediaTypeImageConfig   = "application/vnd.oci.image.config.v1+json"
+	MediaTypeLayer         = "application/vnd.oci.image.layer.v1.tar"
+	MediaTypeLayerGzip     = "application/vnd.oci.image.layer.v1.tar+gzip"
+	MediaTypeLayerZstd     = "application/vnd.oci.image.layer.v1.tar+zstd"
+	// docker的manifest list和manifest，和OCI的格式兼容
+	MediaTypeDockerManifestList = "application/vnd.docker.distribution.manifest.list.v2+json"
+	MediaTypeDockerManifest     = "application/vnd.docker.distribution.manifest.v2+json"
+
+	// OCI image layout中index.json的annotation，记录镜像的名称
+	AnnotationRefName = "org.opencontainers.image.ref.name"
+	// containerd和docker记录完整镜像名的annotation
+	AnnotationImageName = "io.containerd.image.name"
+	// OCI image layout的版本
+	ImageLayoutVersion = "1.0.0"
+)
+
+// 指向一个blob的描述符
+type Descriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	Platform    *Platform         `json:"platform,omitempty"`
+}
+
+type Platform struct {
+	Architecture string `json:"architecture"`
+	OS           string `json:"os"`
+	Variant      string `json:"variant,omitempty"`
+}
+
+// OCI image index，也就是OCI image layout中的index.json
+type Index struct {
+	SchemaVersion int          `json:"schemaVersion"`
+	MediaType     string       `json:"mediaType,omitempty"`
+	Manifests     []Descriptor `json:"manifests"`
+}
+
+type Manifest struct {
+	SchemaVersion int          `json:"schemaVersion"`
+	MediaType     string       `json:"mediaType,omitempty"`
+	Config        Descriptor   `json:"config"`
+	Layers        []Descriptor `json:"layers"`
+}
+
+// docker save生成的manifest.json中的一项
+type dockerManifest struct {
+	Config   string   `json:"Config"`
+	RepoTags []string `json:"RepoTags"`
+	Layers   []string `json:"Layers"`
+}
+
+// archive中的一个镜像
+type archiveImage struct {
+	img    *Image
+	refs   []string //镜像的标签，可能为空
+	layers []layerSource
+}
+
+// 导入OCI image layout或者docker save生成的镜像，source可以是目录也可以是tar包
+// imageName为空时使用镜像中记录的名称，同名的镜像已经存在时标签改为指向新导入的镜像
+// archive中有多个镜像时只导入第一个，各层按driver的whiteout格式解压，返回导入后镜像的 name:tag
+func Import(source, imageName string, driver storage.Driver) (string, error) {
+	layoutDir := source
+	fi, err := os.Stat(source)
+	if err != nil {
+		return "", err
+	}
+	if !fi.IsDir() {
+		file, err := os.Open(source)
+		if err != nil {
+			return "", err
+		}
+		defer file.Close()
+		tmpDir, err := extractArchive(file)
+		if err != nil {
+			return "", fmt.Errorf("extract %s error %v", source, err)
+		}
+		defer os.RemoveAll(tmpDir)
+		layoutDir = tmpDir
+	}
+
+	images, err := readArchive(layoutDir)
+	if err != nil {
+		return "", err
+	}
+	unlock, err := LockLayers()
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
+	image := images[0]
+	if imageName != "" {
+		image.refs = []string{imageName}
+	}
+	if len(image.refs) == 0 {
+		return "", fmt.Errorf("no image name in %s, please specify one", source)
+	}
+	if err := loadImage(image, driver); err != nil {
+		return "", err
+	}
+	return image.refs[0], nil
+}
+
+// 从tar流中导入archive中所有的镜像和标签，archive为docker save或者OCI image layout的格式
+// 返回导入的镜像的标签，没有标签的镜像返回镜像ID
+func Load(reader io.Reader, driver storage.Driver) ([]string, error) {
+	tmpDir, err := extractArchive(reader)
+	if err != nil {
+		return nil, fmt.Errorf("extract archive error %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	images, err := readArchive(tmpDir)
+	if err != nil {
+		return nil, err
+	}
+	unlock, err := LockLayers()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	var loaded []string
+	for _, image := range images {
+		if err := loadImage(image, driver); err != nil {
+			return loaded, err
+		}
+		if len(image.refs) == 0 {
+			loaded = append(loaded, image.img.Id)
+		}
+		loaded = append(loaded, image.refs...)
+	}
+	return loaded, nil
+}
+
+// 把archive解压到临时目录
+func extractArchive(reader io.Reader) (string, error) {
+	tmpDir, err := ioutil.TempDir("", "mydocker-import-")
+	if err != nil {
+		return "", err
+	}
+	if err := untar(reader, tmpDir, nil); err != nil {
+		os.RemoveAll(tmpDir)
+		return "", err
+	}
+	return tmpDir, nil
+}
+
+// 解析archive中的所有镜像，docker save的manifest.json中记录了完整的镜像名，优先使用
+func readArchive(layoutDir string) ([]*archiveImage, error) {
+	var images []*archiveImage
+	var err error
+	if _, statErr := os.Stat(filepath.Join(layoutDir, "manifest.json")); statErr == nil {
+		images, err = readDockerArchive(layoutDir)
+	} else if _, statErr := os.Stat(filepath.Join(layoutDir, "oci-layout")); statErr == nil {
+		images, err = readOCILayout(layoutDir)
+	} else {
+		return nil, fmt.Errorf("neither an OCI image layout nor a docker save archive")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(images) == 0 {
+		return nil, fmt.Errorf("no image in archive")
+	}
+	return images, nil
+}
+
+// 导入一个镜像的各层，保存镜像并打上所有标签
+func loadImage(image *archiveImage, driver storage.Driver) error {
+	img := image.img
+	var refs []string
+	for _, ref := range image.refs {
+		named, err := ParseReference(ref)
+		if err != nil {
+			return err
+		}
+		refs = append(refs, named)
+	}
+	image.refs = refs
+	if len(image.layers) != len(img.Config.RootFS.DiffIDs) {
+		return fmt.Errorf("image has %d layers but %d diff_ids", len(image.layers), len(img.Config.RootFS.DiffIDs))
+	}
+
+	img.Driver = driver.Name()
+	img.Layers = nil
+	for i, layer := range image.layers {
+		diffID := img.Config.RootFS.DiffIDs[i]
+		if err := importLayer(layer, diffID, driver); err != nil {
+			return err
+		}
+		img.Layers = append(img.Layers, diffID)
+	}
+	if err := saveImage(img); err != nil {
+		return err
+	}
+	for _, ref := range refs {
+		if err := Tag(img.Id, ref); err != nil {
+			return err
+		}
+	}
+	log.Infof("Imported image %s %s with %d layers", strings.Join(refs, ","), img.Id, len(img.Layers))
+	return nil
+}
+
+// 待导入的一层，digest为空时表示不需要校验压缩数据的digest
+type layerSource struct {
+	path   string
+	digest string
+}
+
+// 解压一层并校验blob的digest和解压后的diff_id
+// 层存储中已经有相同diff_id的层时直接复用，不再重复解压
+func importLayer(layer layerSource, diffID string, driver storage.Driver) error {
+	if err := validateDigest(diffID); err != nil {
+		return err
+	}
+	if layerExists(driver.Name(), diffID) {
+		return nil
+	}
+	file, err := os.Open(layer.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	blobReader := newDigestReader(file)
+	tmpDest, unpackedDigest, err := unpackToTemp(blobReader, driver)
+	if err != nil {
+		return fmt.Errorf("unpack layer %s error %v", layer.path, err)
+	}
+	defer os.RemoveAll(tmpDest)
+	if _, err := io.Copy(io.Discard, blobReader); err != nil {
+		return err
+	}
+	if layer.digest != "" && blobReader.Digest() != layer.digest {
+		return fmt.Errorf("layer digest mismatch, expected %s got %s", layer.digest, blobReader.Digest())
+	}
+	if unpackedDigest != diffID {
+		return fmt.Errorf("layer diff_id mismatch, expected %s got %s", diffID, unpackedDigest)
+	}
+	return commitLayer(tmpDest, driver.Name(), diffID)
+}
+
+// 读取OCI image layout中blobs目录下的blob并校验digest
+func readBlob(layoutDir string, desc Descriptor) ([]byte, error) {
+	if err := validateDigest(desc.Digest); err != nil {
+		return nil, err
+	}
+	content, err := ioutil.ReadFile(blobPath(layoutDir, desc.Digest))
+	if err != nil {
+		return nil, err
+	}
+	if digest := digestOf(content); digest != desc.Digest {
+		return nil, fmt.Errorf("blob digest mismatch, expected %s got %s", desc.Digest, digest)
+	}
+	return content, nil
+}
+
+func blobPath(layoutDir, digest string) string {
+	return filepath.Join(layoutDir, "blobs", "sha256", strings.TrimPrefix(digest, "sha256:"))
+}
+
+// 解析OCI image layout，index.json中的每一项为一个镜像，多平台的镜像使用匹配当前平台的manifest
+// 指向同一个manifest的多项作为同一个镜像的多个标签
+func readOCILayout(layoutDir string) ([]*archiveImage, error) {
+	content, err := ioutil.ReadFile(filepath.Join(layoutDir, "index.json"))
+	if err != nil {
+		return nil, err
+	}
+	var index Index
+	if err := json.Unmarshal(content, &index); err != nil {
+		return nil, fmt.Errorf("unmarshal index.json error %v", err)
+	}
+	//skopeo copy --all这类工具把多平台镜像的各个manifest直接放在index.json中，只导入当前平台的
+	manifests, err := filterPlatform(index.Manifests)
+	if err != nil {
+		return nil, err
+	}
+	var images []*archiveImage
+	byManifest := make(map[string]*archiveImage)
+	for _, desc := range manifests {
+		//containerd和新版本docker在io.containerd.image.name中记录完整的镜像名，ref.name中只有标签
+		ref := desc.Annotations[AnnotationImageName]
+		if ref == "" {
+			ref = desc.Annotations[AnnotationRefName]
+		}
+		//index中可能嵌套了多平台的index，逐级找到当前平台的manifest
+		for desc.MediaType == MediaTypeImageIndex || desc.MediaType == MediaTypeDockerManifestList {
+			content, err := readBlob(layoutDir, desc)
+			if err != nil {
+				return nil, err
+			}
+			var nested Index
+			if err := json.Unmarshal(content, &nested); err != nil {
+				return nil, fmt.Errorf("unmarshal index %s error %v", desc.Digest, err)
+			}
+			matched, err := matchPlatform(nested.Manifests)
+			if err != nil {
+				return nil, err
+			}
+			desc = matched
+		}
+		if image, ok := byManifest[desc.Digest]; ok {
+			if ref != "" {
+				image.refs = append(image.refs, ref)
+			}
+			continue
+		}
+		image, err := readOCIManifest(layoutDir, desc)
+		if err != nil {
+			return nil, err
+		}
+		if ref != "" {
+			image.refs = append(image.refs, ref)
+		}
+		byManifest[desc.Digest] = image
+		images = append(images, image)
+	}
+	return images, nil
+}
+
+// 读取OCI image layout中的一个manifest和它的config
+func readOCIManifest(layoutDir string, desc Descriptor) (*archiveImage, error) {
+	content, err := readBlob(layoutDir, desc)
+	if err != nil {
+		return nil, err
+	}
+	var manifest Manifest
+	if err := json.Unmarshal(content, &manifest); err != nil {
+		return nil, fmt.Errorf("unmarshal manifest %s error %v", desc.Digest, err)
+	}
+	content, err = readBlob(layoutDir, manifest.Config)
+	if err != nil {
+		return nil, err
+	}
+	image := &archiveImage{img: &Image{RawConfig: content}}
+	if err := json.Unmarshal(content, &image.img.Config); err != nil {
+		return nil, fmt.Errorf("unmarshal image config error %v", err)
+	}
+	for _, layer := range manifest.Layers {
+		if err := validateDigest(layer.Digest); err != nil {
+			return nil, err
+		}
+		image.layers = append(image.layers, layerSource{path: blobPath(layoutDir, layer.Digest), digest: layer.Digest})
+	}
+	return image, nil
+}
+
+// 从多平台的manifest中选出和当前系统架构一致的
+func matchPlatform(manifests []Descriptor) (Descriptor, error) {
+	for _, desc := range manifests {
+		if desc.matchesPlatform() {
+			return desc, nil
+		}
+	}
+	return Descriptor{}, fmt.Errorf("no manifest for platform %s/%s", runtime.GOOS, runtime.GOARCH)
+}
+
+// 去掉其他平台的manifest，没有记录平台的manifest都保留
+func filterPlatform(manifests []Descriptor) ([]Descriptor, error) {
+	var result []Descriptor
+	for _, desc := range manifests {
+		if desc.matchesPlatform() {
+			result = append(result, desc)
+		}
+	}
+	if len(manifests) > 0 && len(result) == 0 {
+		return nil, fmt.Errorf("no manifest for platform %s/%s", runtime.GOOS, runtime.GOARCH)
+	}
+	return result, nil
+}
+
+// 描述符没有记录平台，或者平台和当前系统一致
+func (desc Descriptor) matchesPlatform() bool {
+	return desc.Platform == nil || (desc.Platform.OS == runtime.GOOS && desc.Platform.Architecture == runtime.GOARCH)
+}
+
+// 解析docker save生成的archive，manifest.json中的每一项为一个镜像，层为未压缩的tar，通过diff_id校验
+func readDockerArchive(layoutDir string) ([]*archiveImage, error) {
+	content, err := ioutil.ReadFile(filepath.Join(layoutDir, "manifest.json"))
+	if err != nil {
+		return nil, err
+	}
+	var manifests []dockerManifest
+	if err := json.Unmarshal(content, &manifests); err != nil {
+		return nil, fmt.Errorf("unmarshal manifest.json error %v", err)
+	}
+	var images []*archiveImage
+	for _, manifest := range manifests {
+		configPath, err := safeJoin(layoutDir, manifest.Config)
+		if err != nil {
+			return nil, err
+		}
+		content, err = ioutil.ReadFile(configPath)
+		if err != nil {
+			return nil, err
+		}
+		//config文件以自己的sha256命名
+		configDigest := "sha256:" + strings.TrimSuffix(filepath.Base(manifest.Config), ".json")
+		if validateDigest(configDigest) == nil && digestOf(content) != configDigest {
+			return nil, fmt.Errorf("image config digest mismatch %s", manifest.Config)
+		}
+		image := &archiveImage{img: &Image{RawConfig: content}, refs: manifest.RepoTags}
+		if err := json.Unmarshal(content, &image.img.Config); err != nil {
+			return nil, fmt.Errorf("unmarshal image config error %v", err)
+		}
+		for _, layer := range manifest.Layers {
+			layerPath, err := safeJoin(layoutDir, layer)
+			if err != nil {
+				return nil, err
+			}
+			//新版本docker save的层保存在blobs/sha256/下，文件名就是digest
+			source := layerSource{path: layerPath}
+			if digest := "sha256:" + filepath.Base(layer); strings.Contains(layer, "blobs/sha256/") && validateDigest(digest) == nil {
+				source.digest = digest
+			}
+			image.layers = append(image.layers, source)
+		}
+		images = append(images, image)
+	}
+	return images, nil
+}
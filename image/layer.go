@@ -0,0 +1,275 @@
+package image
+
+import (
+	"TinyDocker/storage"
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"golang.org/x/sys/unix"
+	"hash"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// 计算读取过的数据的sha256，用于校验blob的digest
+type digestReader struct {
+	reader io.Reader
+	hash   hash.Hash
+}
+
+func newDigestReader(reader io.Reader) *digestReader {
+	h := sha256.New()
+	return &digestReader{reader: io.TeeReader(reader, h), hash: h}
+}
+
+func (r *digestReader) Read(p []byte) (int, error) {
+	return r.reader.Read(p)
+}
+
+func (r *digestReader) Digest() string {
+	return "sha256:" + hex.EncodeToString(r.hash.Sum(nil))
+}
+
+// 计算一段数据的digest
+func digestOf(content []byte) string {
+	sum := sha256.Sum256(content)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// 校验digest的格式，只支持sha256
+func validateDigest(digest string) error {
+	hexPart := strings.TrimPrefix(digest, "sha256:")
+	if hexPart == digest || len(hexPart) != sha256.Size*2 {
+		return fmt.Errorf("unsupported digest %s", digest)
+	}
+	if _, err := hex.DecodeString(hexPart); err != nil {
+		return fmt.Errorf("invalid digest %s", digest)
+	}
+	return nil
+}
+
+// 根据数据开头的magic number判断压缩格式，返回解压后的数据流
+// gzip使用标准库解压，zstd调用zstd命令解压，其余按未压缩的tar处理
+func decompressStream(reader io.Reader) (io.ReadCloser, error) {
+	buf := bufio.NewReader(reader)
+	head, err := buf.Peek(4)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	switch {
+	case bytes.HasPrefix(head, gzipMagic):
+		return gzip.NewReader(buf)
+	case bytes.HasPrefix(head, zstdMagic):
+		cmd := exec.Command("zstd", "-dc")
+		cmd.Stdin = buf
+		stdout, err := cmd.StdoutPipe()
+		if err != nil {
+			return nil, err
+		}
+		if err := cmd.Start(); err != nil {
+			return nil, fmt.Errorf("start zstd error %v", err)
+		}
+		return &cmdReader{ReadCloser: stdout, cmd: cmd}, nil
+	default:
+		return io.NopCloser(buf), nil
+	}
+}
+
+// 外部解压命令的输出，关闭时等待命令退出
+type cmdReader struct {
+	io.ReadCloser
+	cmd *exec.Cmd
+}
+
+func (r *cmdReader) Close() error {
+	r.ReadCloser.Close()
+	if err := r.cmd.Wait(); err != nil {
+		return fmt.Errorf("%s error %v", r.cmd.Path, err)
+	}
+	return nil
+}
+
+// 解压一层的内容到dest目录，返回解压后tar流的digest(diff_id)
+// 镜像中的whiteout文件由存储驱动转换成挂载时能识别的格式
+func unpackLayer(reader io.Reader, dest string, driver storage.Driver) (string, error) {
+	stream, err := decompressStream(reader)
+	if err != nil {
+		return "", fmt.Errorf("decompress layer error %v", err)
+	}
+	defer stream.Close()
+	diffReader := newDigestReader(stream)
+	if err := untar(diffReader, dest, driver); err != nil {
+		return "", err
+	}
+	//读完tar结尾的填充数据，保证diff_id是整个tar流的digest
+	if _, err := io.Copy(io.Discard, diffReader); err != nil {
+		return "", err
+	}
+	if err := stream.Close(); err != nil {
+		return "", err
+	}
+	return diffReader.Digest(), nil
+}
+
+// 将tar流解压到dest目录，driver不为空时通过driver创建whiteout
+func untar(reader io.Reader, dest string, driver storage.Driver) error {
+	tr := tar.NewReader(reader)
+	type dirTime struct {
+		path    string
+		modTime time.Time
+	}
+	var dirs []dirTime
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("read tar error %v", err)
+		}
+		target, err := safeJoin(dest, hdr.Name)
+		if err != nil {
+			return err
+		}
+		if target == dest {
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		base := filepath.Base(target)
+		if driver != nil && strings.HasPrefix(base, storage.WhiteoutPrefix) {
+			if err := driver.CreateWhiteout(target); err != nil {
+				return err
+			}
+			continue
+		}
+		//同一层中后出现的条目覆盖先出现的
+		if fi, err := os.Lstat(target); err == nil && !(fi.IsDir() && hdr.Typeflag == tar.TypeDir) {
+			if err := os.RemoveAll(target); err != nil {
+				return err
+			}
+		}
+		mode := os.FileMode(hdr.Mode).Perm() | tarModeBits(hdr.Mode)
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, mode); err != nil {
+				return err
+			}
+			dirs = append(dirs, dirTime{target, hdr.ModTime})
+		case tar.TypeReg:
+			file, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+			if err != nil {
+				return err
+			}
+			if _, err := io.Copy(file, tr); err != nil {
+				file.Close()
+				return err
+			}
+			file.Close()
+		case tar.TypeSymlink:
+			if err := os.Symlink(hdr.Linkname, target); err != nil {
+				return err
+			}
+		case tar.TypeLink:
+			linkTarget, err := safeJoin(dest, hdr.Linkname)
+			if err != nil {
+				return err
+			}
+			if err := os.Link(linkTarget, target); err != nil {
+				return err
+			}
+		case tar.TypeChar, tar.TypeBlock, tar.TypeFifo:
+			devMode := uint32(mode.Perm())
+			switch hdr.Typeflag {
+			case tar.TypeChar:
+				devMode |= syscall.S_IFCHR
+			case tar.TypeBlock:
+				devMode |= syscall.S_IFBLK
+			default:
+				devMode |= syscall.S_IFIFO
+			}
+			dev := mkdev(hdr.Devmajor, hdr.Devminor)
+			if err := syscall.Mknod(target, devMode, int(dev)); err != nil {
+				return fmt.Errorf("mknod %s error %v", target, err)
+			}
+		case tar.TypeXGlobalHeader:
+			continue
+		default:
+			return fmt.Errorf("unsupported tar entry %s type %c", hdr.Name, hdr.Typeflag)
+		}
+		if err := os.Lchown(target, hdr.Uid, hdr.Gid); err != nil {
+			return err
+		}
+		if hdr.Typeflag != tar.TypeSymlink && hdr.Typeflag != tar.TypeLink {
+			//chown会清除setuid等位，需要重新设置权限
+			if err := os.Chmod(target, mode); err != nil {
+				return err
+			}
+			if hdr.Typeflag != tar.TypeDir {
+				os.Chtimes(target, hdr.AccessTime, hdr.ModTime)
+			}
+		} else if hdr.Typeflag == tar.TypeSymlink {
+			//符号链接的修改时间也要保留，否则重新打包后层的digest会变化
+			modTime := unix.NsecToTimespec(hdr.ModTime.UnixNano())
+			times := []unix.Timespec{modTime, modTime}
+			unix.UtimesNanoAt(unix.AT_FDCWD, target, times, unix.AT_SYMLINK_NOFOLLOW)
+		}
+	}
+	//目录的修改时间在写入子文件时会改变，最后再设置
+	for _, dir := range dirs {
+		os.Chtimes(dir.path, dir.modTime, dir.modTime)
+	}
+	return nil
+}
+
+// tar头中的setuid、setgid和sticky位
+func tarModeBits(mode int64) os.FileMode {
+	var bits os.FileMode
+	if mode&04000 != 0 {
+		bits |= os.ModeSetuid
+	}
+	if mode&02000 != 0 {
+		bits |= os.ModeSetgid
+	}
+	if mode&01000 != 0 {
+		bits |= os.ModeSticky
+	}
+	return bits
+}
+
+func mkdev(major, minor int64) uint64 {
+	return uint64((minor & 0xff) | ((major & 0xfff) << 8) | ((minor &^ 0xff) << 12) | ((major &^ 0xfff) << 32))
+}
+
+// 把tar中的路径拼接到root下，拒绝通过..或者符号链接逃逸到root之外的路径
+func safeJoin(root, name string) (string, error) {
+	cleaned := filepath.Clean("/" + name)
+	target := filepath.Join(root, cleaned)
+	current := root
+	parts := strings.Split(strings.Trim(cleaned, "/"), "/")
+	for _, part := range parts[:len(parts)-1] {
+		if part == "" {
+			continue
+		}
+		current = filepath.Join(current, part)
+		if fi, err := os.Lstat(current); err == nil && fi.Mode()&os.ModeSymlink != 0 {
+			return "", fmt.Errorf("path %s goes through symlink %s", name, current)
+		}
+	}
+	return target, nil
+}
@@ -0,0 +1,263 @@
+package image
+
+import (
+	"TinyDocker/storage"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+)
+
+// manifest和config的大小上限，防止仓库返回异常大的内容
+const maxManifestSize = 4 << 20
+
+// 从镜像仓库拉取镜像并打上标签ref，层存储中已经有的层不会重复下载
+// 下载中断的层保存在ImageRoot/downloads下，重新pull时从中断的位置继续下载，返回镜像的 name:tag
+func Pull(ref string, driver storage.Driver, auth *RegistryAuth, insecure bool) (string, error) {
+	named, err := ParseReference(ref)
+	if err != nil {
+		return "", err
+	}
+	remote, err := ParseRemoteReference(named)
+	if err != nil {
+		return "", err
+	}
+	unlock, err := LockLayers()
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
+	c := newRegistryClient(remote.Registry, auth, insecure)
+	scope := repositoryScope(remote.Repository, "pull")
+	manifest, err := c.getManifest(remote.Repository, remote.Tag, scope)
+	if err != nil {
+		return "", err
+	}
+	content, err := c.fetchBlob(remote.Repository, manifest.Config, scope)
+	if err != nil {
+		return "", err
+	}
+	//保留仓库中的原始配置，镜像ID和manifest中config的digest一致
+	img := &Image{Driver: driver.Name(), RawConfig: content}
+	if err := json.Unmarshal(content, &img.Config); err != nil {
+		return "", fmt.Errorf("unmarshal image config error %v", err)
+	}
+	if len(manifest.Layers) != len(img.Config.RootFS.DiffIDs) {
+		return "", fmt.Errorf("image has %d layers but %d diff_ids", len(manifest.Layers), len(img.Config.RootFS.DiffIDs))
+	}
+
+	repo := remote.Registry + "/" + remote.Repository
+	for i, layer := range manifest.Layers {
+		diffID := img.Config.RootFS.DiffIDs[i]
+		if layerExists(driver.Name(), diffID) {
+			log.Infof("Layer %s already exists", shortDigest(layer.Digest))
+		} else {
+			filePath, err := c.downloadBlob(remote.Repository, layer, scope)
+			if err != nil {
+				return "", err
+			}
+			err = importLayer(layerSource{path: filePath, digest: layer.Digest}, diffID, driver)
+			os.Remove(filePath)
+			if err != nil {
+				return "", err
+			}
+			log.Infof("Layer %s pull complete", shortDigest(layer.Digest))
+		}
+		if err := recordDistribution(diffID, layer, diffID, repo); err != nil {
+			return "", err
+		}
+		img.Layers = append(img.Layers, diffID)
+	}
+	if err := saveAndTag(img, named); err != nil {
+		return "", err
+	}
+	log.Infof("Pulled image %s %s from %s", named, img.Id, remote)
+	return named, nil
+}
+
+// 获取镜像的manifest，reference为标签或者digest
+// 多平台镜像返回的是index，需要再获取当前平台的manifest
+func (c *registryClient) getManifest(repository, reference, scope string) (*Manifest, error) {
+	header := http.Header{}
+	header.Set("Accept", strings.Join([]string{
+		MediaTypeImageManifest, MediaTypeDockerManifest, MediaTypeImageIndex, MediaTypeDockerManifestList,
+	}, ", "))
+	resp, err := c.do(http.MethodGet, c.url(repository, "manifests", reference), header, nil, scope)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, registryError(resp, "get manifest "+repository+":"+reference)
+	}
+	content, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxManifestSize))
+	if err != nil {
+		return nil, err
+	}
+	if validateDigest(reference) == nil && digestOf(content) != reference {
+		return nil, fmt.Errorf("manifest digest mismatch, expected %s got %s", reference, digestOf(content))
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	var versioned struct {
+		MediaType string `json:"mediaType"`
+	}
+	if err := json.Unmarshal(content, &versioned); err != nil {
+		return nil, fmt.Errorf("unmarshal manifest error %v", err)
+	}
+	if versioned.MediaType != "" {
+		mediaType = versioned.MediaType
+	}
+	switch mediaType {
+	case MediaTypeImageIndex, MediaTypeDockerManifestList:
+		var index Index
+		if err := json.Unmarshal(content, &index); err != nil {
+			return nil, fmt.Errorf("unmarshal index error %v", err)
+		}
+		desc, err := matchPlatform(index.Manifests)
+		if err != nil {
+			return nil, err
+		}
+		return c.getManifest(repository, desc.Digest, scope)
+	case MediaTypeImageManifest, MediaTypeDockerManifest:
+		var manifest Manifest
+		if err := json.Unmarshal(content, &manifest); err != nil {
+			return nil, fmt.Errorf("unmarshal manifest error %v", err)
+		}
+		return &manifest, nil
+	default:
+		return nil, fmt.Errorf("unsupported manifest media type %s", mediaType)
+	}
+}
+
+// 获取config这类较小的blob并校验digest
+func (c *registryClient) fetchBlob(repository string, desc Descriptor, scope string) ([]byte, error) {
+	if err := validateDigest(desc.Digest); err != nil {
+		return nil, err
+	}
+	resp, err := c.do(http.MethodGet, c.url(repository, "blobs", desc.Digest), nil, nil, scope)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, registryError(resp, "get blob "+desc.Digest)
+	}
+	content, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxManifestSize))
+	if err != nil {
+		return nil, err
+	}
+	if digest := digestOf(content); digest != desc.Digest {
+		return nil, fmt.Errorf("blob digest mismatch, expected %s got %s", desc.Digest, digest)
+	}
+	return content, nil
+}
+
+// 把一层下载到ImageRoot/downloads下，返回文件的路径
+// 连接中断时从已经下载的位置继续，下载完成后校验digest
+func (c *registryClient) downloadBlob(repository string, desc Descriptor, scope string) (string, error) {
+	if err := validateDigest(desc.Digest); err != nil {
+		return "", err
+	}
+	dir := path.Join(ImageRoot, DownloadDirName)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	filePath := path.Join(dir, strings.TrimPrefix(desc.Digest, "sha256:"))
+	for attempt := 1; ; attempt++ {
+		err := c.resumeDownload(repository, desc, filePath, scope)
+		if err == nil {
+			break
+		}
+		if attempt >= transferRetries {
+			return "", fmt.Errorf("download blob %s error %v, pull again to resume", desc.Digest, err)
+		}
+		log.Warnf("Download blob %s interrupted: %v, resuming", shortDigest(desc.Digest), err)
+	}
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	h := sha256.New()
+	size, err := io.Copy(h, file)
+	if err != nil {
+		return "", err
+	}
+	if digest := "sha256:" + hex.EncodeToString(h.Sum(nil)); digest != desc.Digest {
+		//内容已经损坏，不能再用于继续下载
+		os.Remove(filePath)
+		return "", fmt.Errorf("blob digest mismatch, expected %s got %s", desc.Digest, digest)
+	}
+	if desc.Size > 0 && size != desc.Size {
+		os.Remove(filePath)
+		return "", fmt.Errorf("blob %s size mismatch, expected %d got %d", desc.Digest, desc.Size, size)
+	}
+	return filePath, nil
+}
+
+// 从文件末尾的位置继续下载，仓库不支持Range请求时重新下载
+func (c *registryClient) resumeDownload(repository string, desc Descriptor, filePath, scope string) error {
+	file, err := os.OpenFile(filePath, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	offset, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	if desc.Size > 0 && offset == desc.Size {
+		return nil
+	}
+	if desc.Size > 0 && offset > desc.Size {
+		if offset, err = restartDownload(file); err != nil {
+			return err
+		}
+	}
+
+	header := http.Header{}
+	if offset > 0 {
+		header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		log.Infof("Resume download blob %s from %d", shortDigest(desc.Digest), offset)
+	}
+	resp, err := c.do(http.MethodGet, c.url(repository, "blobs", desc.Digest), header, nil, scope)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		if !strings.HasPrefix(resp.Header.Get("Content-Range"), fmt.Sprintf("bytes %d-", offset)) {
+			restartDownload(file)
+			return fmt.Errorf("unexpected Content-Range %s", resp.Header.Get("Content-Range"))
+		}
+	case http.StatusOK:
+		if offset > 0 {
+			if _, err := restartDownload(file); err != nil {
+				return err
+			}
+		}
+	default:
+		return registryError(resp, "get blob "+desc.Digest)
+	}
+	_, err = io.Copy(file, resp.Body)
+	return err
+}
+
+// 清空已下载的内容，从头开始下载
+func restartDownload(file *os.File) (int64, error) {
+	if err := file.Truncate(0); err != nil {
+		return 0, err
+	}
+	return file.Seek(0, io.SeekStart)
+}
@@ -0,0 +1,147 @@
+package image
+
+import (
+	"TinyDocker/storage"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"runtime"
+	"testing"
+)
+
+func TestPull(t *testing.T) {
+	setupImageStore(t)
+	fr := newFakeRegistry(t)
+	fr.authMode = "bearer"
+	manifest, config := fr.addImage(t, "team/app", "v1",
+		map[string]string{"etc/hostname": "app"},
+		map[string]string{"bin/app": "#!/bin/sh\necho app\n"},
+	)
+	driver, err := storage.GetDriver("overlay")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	named, err := Pull(fr.host()+"/team/app:v1", driver, nil, false)
+	if err != nil {
+		t.Fatalf("Pull error %v", err)
+	}
+	if want := fr.host() + "/team/app:v1"; named != want {
+		t.Errorf("Pull = %s, want %s", named, want)
+	}
+	img, err := GetImage(named)
+	if err != nil {
+		t.Fatalf("GetImage error %v", err)
+	}
+	//镜像ID为仓库中config的digest，原始配置中ImageConfig没有的字段也要保留
+	if img.Id != manifest.Config.Digest {
+		t.Errorf("image id = %s, want %s", img.Id, manifest.Config.Digest)
+	}
+	if !bytes.Equal(img.RawConfig, config) {
+		t.Errorf("image config = %s, want %s", img.RawConfig, config)
+	}
+	if len(img.Layers) != 2 {
+		t.Fatalf("image has %d layers, want 2", len(img.Layers))
+	}
+	content, err := ioutil.ReadFile(path.Join(layerDir(driver.Name(), img.Layers[1]), "bin/app"))
+	if err != nil || string(content) != "#!/bin/sh\necho app\n" {
+		t.Errorf("layer file bin/app = %q, %v", content, err)
+	}
+	if files, _ := ioutil.ReadDir(path.Join(ImageRoot, DownloadDirName)); len(files) != 0 {
+		t.Errorf("%d files left in downloads", len(files))
+	}
+
+	//再次pull时层存储中已有的层不再下载
+	before := len(fr.requestLines())
+	if _, err := Pull(fr.host()+"/team/app:v1", driver, nil, false); err != nil {
+		t.Fatalf("Pull again error %v", err)
+	}
+	for _, line := range fr.requestLines()[before:] {
+		for _, layer := range manifest.Layers {
+			if line == "GET /v2/team/app/blobs/"+layer.Digest+" 200" {
+				t.Errorf("layer %s downloaded again", layer.Digest)
+			}
+		}
+	}
+}
+
+func TestPullResumeTruncatedBlob(t *testing.T) {
+	tests := []struct {
+		name    string
+		noRange bool
+		status  int
+	}{
+		{name: "range", status: 206},
+		{name: "no range", noRange: true, status: 200},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupImageStore(t)
+			fr := newFakeRegistry(t)
+			fr.noRange = tt.noRange
+			manifest, _ := fr.addImage(t, "app", "latest", map[string]string{"data": string(make([]byte, 64<<10))})
+			layer := manifest.Layers[0]
+			fr.truncate[layer.Digest] = 100
+			driver, err := storage.GetDriver("overlay")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := Pull(fr.host()+"/app", driver, nil, false); err != nil {
+				t.Fatalf("Pull error %v", err)
+			}
+			gets := fr.findRequests(func(req fakeRequest) bool {
+				return req.method == "GET" && req.path == "/v2/app/blobs/"+layer.Digest
+			})
+			if len(gets) != 2 {
+				t.Fatalf("layer requested %d times, want 2", len(gets))
+			}
+			//中断后从已经下载的位置继续，仓库不支持Range时重新下载
+			if got := gets[1].header.Get("Range"); got != "bytes=100-" {
+				t.Errorf("resume Range = %q, want %q", got, "bytes=100-")
+			}
+			if gets[1].status != tt.status {
+				t.Errorf("resume status = %d, want %d", gets[1].status, tt.status)
+			}
+			img, err := GetImage(fr.host() + "/app")
+			if err != nil {
+				t.Fatalf("GetImage error %v", err)
+			}
+			if fi, err := os.Stat(path.Join(layerDir(driver.Name(), img.Layers[0]), "data")); err != nil || fi.Size() != 64<<10 {
+				t.Errorf("layer file data = %v, %v", fi, err)
+			}
+		})
+	}
+}
+
+func TestPullIndex(t *testing.T) {
+	setupImageStore(t)
+	fr := newFakeRegistry(t)
+	manifest, _ := fr.addImage(t, "app", "current", map[string]string{"os": "current"})
+	other, _ := fr.addImage(t, "app", "other", map[string]string{"os": "other"})
+	content, _ := json.Marshal(manifest)
+	otherContent, _ := json.Marshal(other)
+	index := Index{SchemaVersion: 2, MediaType: MediaTypeImageIndex, Manifests: []Descriptor{
+		{MediaType: MediaTypeImageManifest, Digest: digestOf(otherContent), Size: int64(len(otherContent)), Platform: &Platform{OS: "plan9", Architecture: "mips"}},
+		{MediaType: MediaTypeImageManifest, Digest: digestOf(content), Size: int64(len(content)), Platform: &Platform{OS: runtime.GOOS, Architecture: runtime.GOARCH}},
+	}}
+	indexContent, _ := json.Marshal(index)
+	fr.addManifest("app", "v1", MediaTypeImageIndex, indexContent)
+	driver, err := storage.GetDriver("overlay")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Pull(fr.host()+"/app:v1", driver, nil, false); err != nil {
+		t.Fatalf("Pull error %v", err)
+	}
+	img, err := GetImage(fr.host() + "/app:v1")
+	if err != nil {
+		t.Fatalf("GetImage error %v", err)
+	}
+	if img.Id != manifest.Config.Digest {
+		t.Errorf("image id = %s, want %s", img.Id, manifest.Config.Digest)
+	}
+}
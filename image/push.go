@@ -0,0 +1,388 @@
+package image
+
+import (
+	"TinyDocker/storage"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// 分块上传时每块的大小，仓库通过OCI-Chunk-Min-Length要求更大的块时使用仓库的要求
+const defaultChunkSize = 8 << 20
+
+// 上传中的blob，中断后通过Location查询仓库已经收到的数据，从中断的位置继续上传
+type uploadSession struct {
+	Location  string `json:"location"`
+	ChunkSize int64  `json:"chunkSize"`
+}
+
+// 为push重新打包的一层
+type layerBlob struct {
+	Descriptor Descriptor `json:"descriptor"`
+	DiffID     string     `json:"diffId"` //重新打包后未压缩的tar的digest
+}
+
+// 把本地镜像推送到镜像仓库，镜像仓库的地址、镜像在仓库中的名称和标签都从ref中解析
+// 仓库中已有的层不再上传，同一仓库其他镜像中已有的层直接挂载，返回manifest的digest
+func Push(ref string, auth *RegistryAuth, insecure bool) (string, error) {
+	img, err := GetImage(ref)
+	if err != nil {
+		return "", err
+	}
+	remote, err := ParseRemoteReference(ref)
+	if err != nil {
+		return "", err
+	}
+	driver, err := storage.GetDriver(img.Driver)
+	if err != nil {
+		return "", err
+	}
+	c := newRegistryClient(remote.Registry, auth, insecure)
+	scope := repositoryScope(remote.Repository, "pull,push")
+
+	diffIDs := make([]string, len(img.Layers))
+	layers := make([]Descriptor, 0, len(img.Layers))
+	for i, diffID := range img.Layers {
+		desc, blobDiffID, err := c.pushLayer(remote, driver, diffID, scope)
+		if err != nil {
+			return "", err
+		}
+		//重新打包的层和原来的tar不完全一样，config中要记录上传的blob解压后的digest
+		diffIDs[i] = blobDiffID
+		layers = append(layers, desc)
+	}
+
+	content, err := img.configWithDiffIDs(diffIDs)
+	if err != nil {
+		return "", err
+	}
+	configDesc := Descriptor{MediaType: MediaTypeImageConfig, Digest: digestOf(content), Size: int64(len(content))}
+	exists, err := c.blobExists(remote.Repository, configDesc.Digest, scope)
+	if err != nil {
+		return "", err
+	}
+	if !exists {
+		if err := c.uploadBlob(remote.Repository, configDesc, bytes.NewReader(content), scope); err != nil {
+			return "", err
+		}
+	}
+
+	manifest := Manifest{
+		SchemaVersion: 2,
+		MediaType:     MediaTypeImageManifest,
+		Config:        configDesc,
+		Layers:        layers,
+	}
+	if content, err = json.Marshal(manifest); err != nil {
+		return "", err
+	}
+	header := http.Header{}
+	header.Set("Content-Type", MediaTypeImageManifest)
+	resp, err := c.do(http.MethodPut, c.url(remote.Repository, "manifests", remote.Tag), header, content, scope)
+	if err != nil {
+		return "", err
+	}
+	if err := checkStatus(resp, "put manifest "+remote.String(), http.StatusCreated, http.StatusOK); err != nil {
+		return "", err
+	}
+	digest := digestOf(content)
+	log.Infof("Pushed image %s to %s digest %s", ref, remote, digest)
+	return digest, nil
+}
+
+// 上传一层，返回层的描述符和blob解压后的digest
+func (c *registryClient) pushLayer(remote *RemoteReference, driver storage.Driver, diffID, scope string) (Descriptor, string, error) {
+	repo := remote.Registry + "/" + remote.Repository
+	info, err := getDistribution(diffID)
+	if err != nil {
+		return Descriptor{}, "", err
+	}
+	if info != nil {
+		exists, err := c.blobExists(remote.Repository, info.Digest, scope)
+		if err != nil {
+			return Descriptor{}, "", err
+		}
+		if exists {
+			log.Infof("Layer %s already exists", shortDigest(info.Digest))
+			return info.descriptor(), info.DiffID, recordDistribution(diffID, info.descriptor(), info.DiffID, repo)
+		}
+		//同一个仓库中其他镜像已有的blob可以直接挂载，不需要上传
+		for _, source := range info.Repos {
+			registry, from := splitRepo(source)
+			if registry != remote.Registry || from == remote.Repository {
+				continue
+			}
+			mounted, err := c.mountBlob(remote.Repository, from, info.Digest)
+			if err != nil {
+				return Descriptor{}, "", err
+			}
+			if mounted {
+				log.Infof("Layer %s mounted from %s", shortDigest(info.Digest), from)
+				return info.descriptor(), info.DiffID, recordDistribution(diffID, info.descriptor(), info.DiffID, repo)
+			}
+		}
+	}
+
+	blob, blobPath, err := prepareLayerBlob(driver, diffID)
+	if err != nil {
+		return Descriptor{}, "", err
+	}
+	exists, err := c.blobExists(remote.Repository, blob.Descriptor.Digest, scope)
+	if err != nil {
+		return Descriptor{}, "", err
+	}
+	if exists {
+		log.Infof("Layer %s already exists", shortDigest(blob.Descriptor.Digest))
+	} else {
+		file, err := os.Open(blobPath)
+		if err != nil {
+			return Descriptor{}, "", err
+		}
+		err = c.uploadBlob(remote.Repository, blob.Descriptor, file, scope)
+		file.Close()
+		if err != nil {
+			return Descriptor{}, "", err
+		}
+		log.Infof("Layer %s pushed", shortDigest(blob.Descriptor.Digest))
+	}
+	if err := recordDistribution(diffID, blob.Descriptor, blob.DiffID, repo); err != nil {
+		return Descriptor{}, "", err
+	}
+	os.Remove(blobPath)
+	os.Remove(blobPath + ".json")
+	return blob.Descriptor, blob.DiffID, nil
+}
+
+// 把层存储中的一层重新打包为gzip压缩的tar，保存在ImageRoot/uploads下
+// 上传中断后再次push时直接使用已经打包好的文件，保证blob的digest不变
+func prepareLayerBlob(driver storage.Driver, diffID string) (*layerBlob, string, error) {
+	dir := path.Join(ImageRoot, UploadDirName)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, "", err
+	}
+	blobPath := path.Join(dir, strings.TrimPrefix(diffID, "sha256:")+".tar.gz")
+	blob := &layerBlob{}
+	if err := loadJSON(path.Join(UploadDirName, path.Base(blobPath)+".json"), blob); err == nil && blob.DiffID != "" {
+		if fi, err := os.Stat(blobPath); err == nil && fi.Size() == blob.Descriptor.Size {
+			return blob, blobPath, nil
+		}
+	}
+
+	file, err := os.Create(blobPath + ".tmp")
+	if err != nil {
+		return nil, "", err
+	}
+	defer os.Remove(blobPath + ".tmp")
+	blobDigest, tarDigest := sha256.New(), sha256.New()
+	gz := gzip.NewWriter(io.MultiWriter(file, blobDigest))
+	err = WriteDiff(layerDir(driver.Name(), diffID), driver, io.MultiWriter(gz, tarDigest))
+	if err == nil {
+		err = gz.Close()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("pack layer %s error %v", diffID, err)
+	}
+	fi, err := os.Stat(blobPath + ".tmp")
+	if err != nil {
+		return nil, "", err
+	}
+	blob.Descriptor = Descriptor{
+		MediaType: MediaTypeLayerGzip,
+		Digest:    "sha256:" + hex.EncodeToString(blobDigest.Sum(nil)),
+		Size:      fi.Size(),
+	}
+	blob.DiffID = "sha256:" + hex.EncodeToString(tarDigest.Sum(nil))
+	if err := os.Rename(blobPath+".tmp", blobPath); err != nil {
+		return nil, "", err
+	}
+	if err := saveJSON(path.Join(UploadDirName, path.Base(blobPath)+".json"), blob); err != nil {
+		return nil, "", err
+	}
+	return blob, blobPath, nil
+}
+
+// 判断仓库中是否已经有这个blob
+func (c *registryClient) blobExists(repository, digest, scope string) (bool, error) {
+	resp, err := c.do(http.MethodHead, c.url(repository, "blobs", digest), nil, nil, scope)
+	if err != nil {
+		return false, err
+	}
+	if err := checkStatus(resp, "head blob "+digest, http.StatusOK, http.StatusNotFound); err != nil {
+		return false, err
+	}
+	return resp.StatusCode == http.StatusOK, nil
+}
+
+// 从同一仓库的另一个镜像挂载blob，仓库不支持挂载时返回false
+func (c *registryClient) mountBlob(repository, from, digest string) (bool, error) {
+	query := url.Values{}
+	query.Set("mount", digest)
+	query.Set("from", from)
+	scope := repositoryScope(repository, "pull,push") + " " + repositoryScope(from, "pull")
+	resp, err := c.do(http.MethodPost, c.baseURL+repository+"/blobs/uploads/?"+query.Encode(), nil, nil, scope)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusCreated:
+		return true, nil
+	case http.StatusAccepted:
+		//仓库没有挂载而是开始了一次普通的上传，取消这次上传
+		if location, err := resolveLocation(resp); err == nil {
+			if resp, err := c.do(http.MethodDelete, location, nil, nil, scope); err == nil {
+				resp.Body.Close()
+			}
+		}
+		return false, nil
+	default:
+		return false, registryError(resp, "mount blob "+digest+" from "+from)
+	}
+}
+
+// 分块上传blob，中断时自动从仓库已经收到的位置继续
+// 上传会话保存在ImageRoot/uploads下，重试次数用完后再次push也能继续上传
+func (c *registryClient) uploadBlob(repository string, desc Descriptor, content io.ReaderAt, scope string) error {
+	sessionName := path.Join(UploadDirName, strings.TrimPrefix(desc.Digest, "sha256:")+".session")
+	for attempt := 1; ; attempt++ {
+		err := c.resumeUpload(repository, desc, content, sessionName, scope)
+		if err == nil {
+			break
+		}
+		if attempt >= transferRetries {
+			return fmt.Errorf("upload blob %s error %v, push again to resume", desc.Digest, err)
+		}
+		log.Warnf("Upload blob %s interrupted: %v, resuming", shortDigest(desc.Digest), err)
+	}
+	return os.Remove(path.Join(ImageRoot, sessionName))
+}
+
+func (c *registryClient) resumeUpload(repository string, desc Descriptor, content io.ReaderAt, sessionName, scope string) error {
+	session := &uploadSession{}
+	if err := loadJSON(sessionName, session); err != nil {
+		return err
+	}
+	var offset int64
+	if session.Location != "" {
+		//查询仓库已经收到的数据
+		resp, err := c.do(http.MethodGet, session.Location, nil, nil, scope)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusNoContent {
+			if offset, err = uploadedOffset(resp); err != nil {
+				return err
+			}
+			if location, err := resolveLocation(resp); err == nil {
+				session.Location = location
+			}
+			log.Infof("Resume upload blob %s from %d", shortDigest(desc.Digest), offset)
+		} else {
+			session.Location = ""
+		}
+	}
+	if session.Location == "" {
+		resp, err := c.do(http.MethodPost, c.baseURL+repository+"/blobs/uploads/", nil, nil, scope)
+		if err != nil {
+			return err
+		}
+		if err := checkStatus(resp, "start upload blob "+desc.Digest, http.StatusAccepted); err != nil {
+			return err
+		}
+		if session.Location, err = resolveLocation(resp); err != nil {
+			return err
+		}
+		session.ChunkSize = defaultChunkSize
+		if minLength, err := strconv.ParseInt(resp.Header.Get("OCI-Chunk-Min-Length"), 10, 64); err == nil && minLength > session.ChunkSize {
+			session.ChunkSize = minLength
+		}
+		offset = 0
+		if err := saveJSON(sessionName, session); err != nil {
+			return err
+		}
+	}
+
+	for offset < desc.Size {
+		size := session.ChunkSize
+		if desc.Size-offset < size {
+			size = desc.Size - offset
+		}
+		chunk := make([]byte, size)
+		if _, err := content.ReadAt(chunk, offset); err != nil {
+			return err
+		}
+		header := http.Header{}
+		header.Set("Content-Type", "application/octet-stream")
+		header.Set("Content-Range", fmt.Sprintf("%d-%d", offset, offset+size-1))
+		resp, err := c.do(http.MethodPatch, session.Location, header, chunk, scope)
+		if err != nil {
+			return err
+		}
+		if err := checkStatus(resp, "upload blob "+desc.Digest, http.StatusAccepted); err != nil {
+			//仓库记录的位置和本地不一致，重新开始上传
+			if resp.StatusCode == http.StatusRequestedRangeNotSatisfiable {
+				session.Location = ""
+				saveJSON(sessionName, session)
+			}
+			return err
+		}
+		if session.Location, err = resolveLocation(resp); err != nil {
+			return err
+		}
+		offset += size
+		if err := saveJSON(sessionName, session); err != nil {
+			return err
+		}
+	}
+
+	//所有数据上传完成后带上digest结束上传
+	location, err := url.Parse(session.Location)
+	if err != nil {
+		return err
+	}
+	query := location.Query()
+	query.Set("digest", desc.Digest)
+	location.RawQuery = query.Encode()
+	resp, err := c.do(http.MethodPut, location.String(), nil, nil, scope)
+	if err != nil {
+		return err
+	}
+	if err := checkStatus(resp, "finish upload blob "+desc.Digest, http.StatusCreated); err != nil {
+		session.Location = ""
+		saveJSON(sessionName, session)
+		return err
+	}
+	return nil
+}
+
+// 解析上传状态中的 Range: 0-<end>，返回下一个要上传的位置
+func uploadedOffset(resp *http.Response) (int64, error) {
+	value := strings.TrimPrefix(resp.Header.Get("Range"), "bytes=")
+	if value == "" {
+		return 0, nil
+	}
+	i := strings.LastIndex(value, "-")
+	if i < 0 {
+		return 0, fmt.Errorf("invalid upload Range %s", value)
+	}
+	end, err := strconv.ParseInt(value[i+1:], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid upload Range %s", value)
+	}
+	return end + 1, nil
+}
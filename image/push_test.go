@@ -0,0 +1,218 @@
+package image
+
+import (
+	"TinyDocker/storage"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestPush(t *testing.T) {
+	setupImageStore(t)
+	fr := newFakeRegistry(t)
+	fr.authMode = "bearer"
+	driver, err := storage.GetDriver("overlay")
+	if err != nil {
+		t.Fatal(err)
+	}
+	blob, _ := buildLayer(t, map[string]string{"etc/hostname": "app"})
+	ref := fr.host() + "/team/app:v1"
+	if err := ImportRootfs(bytes.NewReader(blob), ref, driver); err != nil {
+		t.Fatalf("ImportRootfs error %v", err)
+	}
+
+	digest, err := Push(ref, nil, false)
+	if err != nil {
+		t.Fatalf("Push error %v", err)
+	}
+	m := fr.manifest("team/app", "v1")
+	if m == nil {
+		t.Fatalf("manifest team/app:v1 not found in registry")
+	}
+	if digestOf(m.content) != digest {
+		t.Errorf("Push = %s, want manifest digest %s", digest, digestOf(m.content))
+	}
+	if m.mediaType != MediaTypeImageManifest {
+		t.Errorf("manifest media type = %s, want %s", m.mediaType, MediaTypeImageManifest)
+	}
+	var manifest Manifest
+	if err := json.Unmarshal(m.content, &manifest); err != nil {
+		t.Fatal(err)
+	}
+	var config ImageConfig
+	if err := json.Unmarshal(fr.blob(manifest.Config.Digest), &config); err != nil {
+		t.Fatalf("unmarshal pushed config error %v", err)
+	}
+	if len(manifest.Layers) != 1 || len(config.RootFS.DiffIDs) != 1 {
+		t.Fatalf("pushed %d layers and %d diff_ids, want 1", len(manifest.Layers), len(config.RootFS.DiffIDs))
+	}
+	//config中的diff_id必须是上传的blob解压后的digest
+	gz, err := gzip.NewReader(bytes.NewReader(fr.blob(manifest.Layers[0].Digest)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader := newDigestReader(gz)
+	if _, err := io.Copy(io.Discard, reader); err != nil {
+		t.Fatal(err)
+	}
+	if reader.Digest() != config.RootFS.DiffIDs[0] {
+		t.Errorf("diff_id = %s, want %s", config.RootFS.DiffIDs[0], reader.Digest())
+	}
+	if files, _ := os.ReadDir(path.Join(ImageRoot, UploadDirName)); len(files) != 0 {
+		t.Errorf("%d files left in uploads", len(files))
+	}
+
+	//再次push时仓库中已有的层不再上传
+	before := len(fr.requestLines())
+	if _, err := Push(ref, nil, false); err != nil {
+		t.Fatalf("Push again error %v", err)
+	}
+	for _, line := range fr.requestLines()[before:] {
+		if strings.HasPrefix(line, "PATCH ") || strings.HasPrefix(line, "POST ") {
+			t.Errorf("unexpected request %s", line)
+		}
+	}
+}
+
+func TestPushPulledImageKeepsID(t *testing.T) {
+	setupImageStore(t)
+	fr := newFakeRegistry(t)
+	manifest, config := fr.addImage(t, "base", "v1", map[string]string{"etc/hostname": "base"})
+	driver, err := storage.GetDriver("overlay")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Pull(fr.host()+"/base:v1", driver, nil, false); err != nil {
+		t.Fatalf("Pull error %v", err)
+	}
+	if err := Tag(fr.host()+"/base:v1", fr.host()+"/base:v2"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Push(fr.host()+"/base:v2", nil, false); err != nil {
+		t.Fatalf("Push error %v", err)
+	}
+	//层没有变化时使用原始配置，仓库中的镜像和pull下来的完全一致
+	m := fr.manifest("base", "v2")
+	if m == nil {
+		t.Fatalf("manifest base:v2 not found in registry")
+	}
+	var pushed Manifest
+	if err := json.Unmarshal(m.content, &pushed); err != nil {
+		t.Fatal(err)
+	}
+	if pushed.Config.Digest != manifest.Config.Digest || !bytes.Equal(fr.blob(pushed.Config.Digest), config) {
+		t.Errorf("pushed config %s, want %s", pushed.Config.Digest, manifest.Config.Digest)
+	}
+}
+
+func TestPushMountBlob(t *testing.T) {
+	tests := []struct {
+		name  string
+		mount bool
+	}{
+		{name: "mounted", mount: true},
+		{name: "mount not supported", mount: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupImageStore(t)
+			fr := newFakeRegistry(t)
+			fr.mount = tt.mount
+			manifest, _ := fr.addImage(t, "base", "v1", map[string]string{"etc/hostname": "base"})
+			layer := manifest.Layers[0]
+			driver, err := storage.GetDriver("overlay")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := Pull(fr.host()+"/base:v1", driver, nil, false); err != nil {
+				t.Fatalf("Pull error %v", err)
+			}
+			if err := Tag(fr.host()+"/base:v1", fr.host()+"/app:v1"); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := Push(fr.host()+"/app:v1", nil, false); err != nil {
+				t.Fatalf("Push error %v", err)
+			}
+			m := fr.manifest("app", "v1")
+			if m == nil {
+				t.Fatalf("manifest app:v1 not found in registry")
+			}
+			var pushed Manifest
+			if err := json.Unmarshal(m.content, &pushed); err != nil {
+				t.Fatal(err)
+			}
+			//挂载时直接使用base中的blob，否则上传重新打包的层
+			if mounted := pushed.Layers[0].Digest == layer.Digest; mounted != tt.mount {
+				t.Errorf("pushed layer %s, mounted = %v, want %v", pushed.Layers[0].Digest, mounted, tt.mount)
+			}
+			mounts := fr.findRequests(func(req fakeRequest) bool {
+				return req.method == "POST" && strings.Contains(req.query, "mount="+strings.Replace(layer.Digest, ":", "%3A", 1))
+			})
+			if len(mounts) != 1 {
+				t.Fatalf("mount requested %d times, want 1", len(mounts))
+			}
+			if !strings.Contains(mounts[0].query, "from=base") {
+				t.Errorf("mount query = %s, want from=base", mounts[0].query)
+			}
+			//仓库不支持挂载时取消仓库开始的上传，再正常上传
+			deletes := fr.findRequests(func(req fakeRequest) bool {
+				return req.method == "DELETE"
+			})
+			patches := fr.findRequests(func(req fakeRequest) bool {
+				return req.method == "PATCH"
+			})
+			wantDeletes, wantPatches := 0, 1
+			if !tt.mount {
+				wantDeletes, wantPatches = 1, 2
+			}
+			if len(deletes) != wantDeletes || len(patches) != wantPatches {
+				t.Errorf("%d DELETE and %d PATCH requests, want %d and %d", len(deletes), len(patches), wantDeletes, wantPatches)
+			}
+			if n := fr.uploadCount(); n != 0 {
+				t.Errorf("%d uploads left in registry", n)
+			}
+		})
+	}
+}
+
+func TestUploadBlobResume(t *testing.T) {
+	setupImageStore(t)
+	fr := newFakeRegistry(t)
+	content := []byte("0123456789")
+	desc := Descriptor{MediaType: MediaTypeLayerGzip, Digest: digestOf(content), Size: int64(len(content))}
+	//上次push已经上传了前4个字节，每块3个字节
+	sessionName := path.Join(UploadDirName, strings.TrimPrefix(desc.Digest, "sha256:")+".session")
+	session := &uploadSession{Location: fr.startUpload("app", content[:4]), ChunkSize: 3}
+	if err := saveJSON(sessionName, session); err != nil {
+		t.Fatal(err)
+	}
+	fr.failPatch = 1
+
+	c := newRegistryClient(fr.host(), nil, false)
+	if err := c.uploadBlob("app", desc, bytes.NewReader(content), repositoryScope("app", "pull,push")); err != nil {
+		t.Fatalf("uploadBlob error %v", err)
+	}
+	if got := fr.blob(desc.Digest); !bytes.Equal(got, content) {
+		t.Errorf("uploaded blob = %q, want %q", got, content)
+	}
+	var ranges []string
+	for _, req := range fr.findRequests(func(req fakeRequest) bool { return req.method == "PATCH" }) {
+		ranges = append(ranges, fmt.Sprintf("%s %d", req.header.Get("Content-Range"), req.status))
+	}
+	//第一次PATCH失败后查询仓库已经收到的数据，从第4个字节继续上传
+	want := []string{"4-6 500", "4-6 202", "7-9 202"}
+	if strings.Join(ranges, ",") != strings.Join(want, ",") {
+		t.Errorf("PATCH ranges = %q, want %q", ranges, want)
+	}
+	if _, err := os.Stat(path.Join(ImageRoot, sessionName)); !os.IsNotExist(err) {
+		t.Errorf("session file not removed: %v", err)
+	}
+}
@@ -0,0 +1,217 @@
+package image
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const DefaultTag = "latest"
+
+var (
+	// 镜像名和标签到镜像ID的映射，如 {"busybox:latest": "sha256:..."}
+	RepositoriesName string = "repositories.json"
+	// build的缓存，构建步骤的key到中间镜像ID的映射
+	BuildCacheName string = "buildcache.json"
+
+	tagPattern = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]{0,127}$`)
+)
+
+// 解析镜像引用 name[:tag]，没有标签时使用latest，返回 name:tag
+func ParseReference(ref string) (string, error) {
+	name, tag := ref, DefaultTag
+	if i := strings.LastIndex(ref, ":"); i > strings.LastIndex(ref, "/") {
+		name, tag = ref[:i], ref[i+1:]
+	}
+	//镜像名的第一段可以是带端口的镜像仓库地址，如 localhost:5000/busybox
+	repository := name
+	if i := strings.Index(name, "/"); i >= 0 {
+		repository = name[i+1:]
+	}
+	if name == "" || strings.ContainsAny(name, " \t\n,@") || strings.Contains(repository, ":") || strings.Contains(name, "..") {
+		return "", fmt.Errorf("invalid image name %s", ref)
+	}
+	if !tagPattern.MatchString(tag) {
+		return "", fmt.Errorf("invalid tag %s", ref)
+	}
+	return name + ":" + tag, nil
+}
+
+// 拆分 name:tag
+func SplitReference(ref string) (string, string) {
+	i := strings.LastIndex(ref, ":")
+	return ref[:i], ref[i+1:]
+}
+
+// 把镜像名、镜像ID或者ID的前缀解析为完整的镜像ID
+func Resolve(ref string) (string, error) {
+	if named, err := ParseReference(ref); err == nil {
+		refs, err := loadMap(RepositoriesName)
+		if err != nil {
+			return "", err
+		}
+		if id, ok := refs[named]; ok {
+			return id, nil
+		}
+	}
+	prefix := strings.TrimPrefix(ref, "sha256:")
+	if _, err := hex.DecodeString(prefix + strings.Repeat("0", len(prefix)%2)); err != nil || prefix == "" {
+		return "", fmt.Errorf("image %s not found", ref)
+	}
+	images, err := ListImages()
+	if err != nil {
+		return "", err
+	}
+	var matched []string
+	for _, img := range images {
+		if strings.HasPrefix(strings.TrimPrefix(img.Id, "sha256:"), prefix) {
+			matched = append(matched, img.Id)
+		}
+	}
+	switch len(matched) {
+	case 0:
+		return "", fmt.Errorf("image %s not found", ref)
+	case 1:
+		return matched[0], nil
+	default:
+		return "", fmt.Errorf("image id prefix %s is ambiguous", ref)
+	}
+}
+
+// 给source对应的镜像打上标签target，target已经指向其他镜像时改为指向这个镜像
+func Tag(source, target string) error {
+	id, err := Resolve(source)
+	if err != nil {
+		return err
+	}
+	named, err := ParseReference(target)
+	if err != nil {
+		return err
+	}
+	refs, err := loadMap(RepositoriesName)
+	if err != nil {
+		return err
+	}
+	refs[named] = id
+	return saveMap(RepositoriesName, refs)
+}
+
+// 删除镜像的标签
+func Untag(ref string) error {
+	named, err := ParseReference(ref)
+	if err != nil {
+		return err
+	}
+	refs, err := loadMap(RepositoriesName)
+	if err != nil {
+		return err
+	}
+	if _, ok := refs[named]; !ok {
+		return fmt.Errorf("tag %s not found", ref)
+	}
+	delete(refs, named)
+	return saveMap(RepositoriesName, refs)
+}
+
+// 返回指向某个镜像的所有标签
+func References(id string) ([]string, error) {
+	refs, err := loadMap(RepositoriesName)
+	if err != nil {
+		return nil, err
+	}
+	var result []string
+	for named, target := range refs {
+		if target == id {
+			result = append(result, named)
+		}
+	}
+	sort.Strings(result)
+	return result, nil
+}
+
+// 读取build缓存中key对应的镜像
+func GetBuildCache(key string) (*Image, error) {
+	cache, err := loadMap(BuildCacheName)
+	if err != nil {
+		return nil, err
+	}
+	id, ok := cache[key]
+	if !ok {
+		return nil, fmt.Errorf("build cache %s not found", key)
+	}
+	return getImageByID(id)
+}
+
+// 记录build缓存
+func SetBuildCache(key, id string) error {
+	cache, err := loadMap(BuildCacheName)
+	if err != nil {
+		return err
+	}
+	cache[key] = id
+	return saveMap(BuildCacheName, cache)
+}
+
+// 删除指向某个镜像的build缓存
+func removeBuildCache(id string) error {
+	cache, err := loadMap(BuildCacheName)
+	if err != nil {
+		return err
+	}
+	for key, target := range cache {
+		if target == id {
+			delete(cache, key)
+		}
+	}
+	return saveMap(BuildCacheName, cache)
+}
+
+// 读取ImageRoot下json格式的映射文件，文件不存在时返回空的映射
+func loadMap(name string) (map[string]string, error) {
+	result := make(map[string]string)
+	if err := loadJSON(name, &result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func saveMap(name string, content map[string]string) error {
+	return saveJSON(name, content)
+}
+
+// 读取ImageRoot下的json文件，文件不存在时不修改v
+func loadJSON(name string, v interface{}) error {
+	content, err := ioutil.ReadFile(path.Join(ImageRoot, name))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if err := json.Unmarshal(content, v); err != nil {
+		return fmt.Errorf("unmarshal %s error %v", name, err)
+	}
+	return nil
+}
+
+// 先写临时文件再重命名，避免写到一半时文件损坏
+func saveJSON(name string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	filePath := path.Join(ImageRoot, name)
+	if err := os.MkdirAll(path.Dir(filePath), 0755); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filePath+".tmp", data, 0644); err != nil {
+		return fmt.Errorf("write file %s error %v", filePath, err)
+	}
+	return os.Rename(filePath+".tmp", filePath)
+}
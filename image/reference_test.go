@@ -0,0 +1,49 @@
+package image
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseReference(t *testing.T) {
+	tests := []struct {
+		ref     string
+		want    string
+		wantErr bool
+	}{
+		{ref: "busybox", want: "busybox:latest"},
+		{ref: "busybox:1.36", want: "busybox:1.36"},
+		{ref: "library/nginx:1.25", want: "library/nginx:1.25"},
+		{ref: "localhost:5000/app", want: "localhost:5000/app:latest"},
+		{ref: "localhost:5000/app:v1", want: "localhost:5000/app:v1"},
+		{ref: "registry.example.com/team/app:1.0-rc_1", want: "registry.example.com/team/app:1.0-rc_1"},
+		//本地镜像只能通过标签引用，不支持digest
+		{ref: "busybox@sha256:" + strings.Repeat("a", 64), wantErr: true},
+		{ref: "localhost:5000/app@sha256:" + strings.Repeat("a", 64), wantErr: true},
+		{ref: "", wantErr: true},
+		{ref: ":latest", wantErr: true},
+		{ref: "busybox:", wantErr: true},
+		{ref: "busy box", wantErr: true},
+		{ref: "a,b", wantErr: true},
+		{ref: "../busybox", wantErr: true},
+		{ref: "library/app:v1:v2", wantErr: true},
+		{ref: "busybox:-dev", wantErr: true},
+		{ref: "busybox:" + strings.Repeat("a", 129), wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseReference(tt.ref)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParseReference(%q) = %q, want error", tt.ref, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseReference(%q) error %v", tt.ref, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseReference(%q) = %q, want %q", tt.ref, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,290 @@
+package image
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	// 镜像名中没有仓库地址时使用docker hub
+	DefaultRegistry = "registry-1.docker.io"
+	// docker hub上的官方镜像在library下
+	officialRepoPrefix = "library/"
+)
+
+var repositoryPattern = regexp.MustCompile(`^[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*(?:/[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*)*$`)
+
+// 镜像仓库中的镜像，如 localhost:5000/team/app:v1
+type RemoteReference struct {
+	Registry   string //镜像仓库的地址 host[:port]
+	Repository string //镜像在仓库中的名称
+	Tag        string
+}
+
+// 从本地的镜像名解析出镜像仓库的地址、镜像在仓库中的名称和标签
+// 第一段包含.或:或者为localhost时作为镜像仓库的地址，否则使用docker hub
+func ParseRemoteReference(ref string) (*RemoteReference, error) {
+	named, err := ParseReference(ref)
+	if err != nil {
+		return nil, err
+	}
+	name, tag := SplitReference(named)
+	remote := &RemoteReference{Registry: DefaultRegistry, Repository: name, Tag: tag}
+	if i := strings.Index(name, "/"); i >= 0 {
+		host := name[:i]
+		if strings.ContainsAny(host, ".:") || host == "localhost" {
+			remote.Registry, remote.Repository = host, name[i+1:]
+		}
+	}
+	if remote.Registry == DefaultRegistry && !strings.Contains(remote.Repository, "/") {
+		remote.Repository = officialRepoPrefix + remote.Repository
+	}
+	if !repositoryPattern.MatchString(remote.Repository) {
+		return nil, fmt.Errorf("invalid repository name %s", remote.Repository)
+	}
+	return remote, nil
+}
+
+func (r *RemoteReference) String() string {
+	return r.Registry + "/" + r.Repository + ":" + r.Tag
+}
+
+// 访问镜像仓库使用的用户名和密码
+type RegistryAuth struct {
+	Username string
+	Password string
+}
+
+// OCI distribution API的客户端，遇到401时按WWW-Authenticate的要求使用basic认证或者获取bearer token
+type registryClient struct {
+	registry string
+	baseURL  string
+	auth     *RegistryAuth
+	client   *http.Client
+	tokens   map[string]string //scope到bearer token的映射
+	basic    bool              //仓库要求basic认证
+}
+
+// insecure为true或者仓库在本机时使用http，否则使用https
+func newRegistryClient(registry string, auth *RegistryAuth, insecure bool) *registryClient {
+	scheme := "https"
+	host := registry
+	if h, _, err := net.SplitHostPort(registry); err == nil {
+		host = h
+	}
+	if ip := net.ParseIP(host); insecure || host == "localhost" || (ip != nil && ip.IsLoopback()) {
+		scheme = "http"
+	}
+	return &registryClient{
+		registry: registry,
+		baseURL:  scheme + "://" + registry + "/v2/",
+		auth:     auth,
+		client:   &http.Client{Timeout: 30 * time.Minute},
+		tokens:   make(map[string]string),
+	}
+}
+
+// 镜像在仓库中的API地址，如 /v2/<name>/manifests/<reference>
+func (c *registryClient) url(repository, kind, reference string) string {
+	return c.baseURL + repository + "/" + kind + "/" + reference
+}
+
+// 发送请求，返回401时认证后重试一次，scope为bearer token需要的权限，多个scope用空格分隔
+func (c *registryClient) do(method, rawURL string, header http.Header, body []byte, scope string) (*http.Response, error) {
+	for retry := false; ; retry = true {
+		var reader io.Reader
+		if body != nil {
+			reader = bytes.NewReader(body)
+		}
+		req, err := http.NewRequest(method, rawURL, reader)
+		if err != nil {
+			return nil, err
+		}
+		for key, values := range header {
+			req.Header[key] = values
+		}
+		if body != nil {
+			req.ContentLength = int64(len(body))
+		}
+		if token, ok := c.tokens[scope]; ok {
+			req.Header.Set("Authorization", "Bearer "+token)
+		} else if c.basic && c.auth != nil {
+			req.SetBasicAuth(c.auth.Username, c.auth.Password)
+		}
+		resp, err := c.client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusUnauthorized || retry {
+			return resp, nil
+		}
+		challenge := resp.Header.Get("WWW-Authenticate")
+		resp.Body.Close()
+		if err := c.authenticate(challenge, scope); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// 根据WWW-Authenticate的要求进行认证
+func (c *registryClient) authenticate(challenge, scope string) error {
+	scheme, params := parseChallenge(challenge)
+	switch strings.ToLower(scheme) {
+	case "basic":
+		if c.auth == nil {
+			return fmt.Errorf("registry %s requires username and password", c.registry)
+		}
+		c.basic = true
+		return nil
+	case "bearer":
+		token, err := c.fetchToken(params, scope)
+		if err != nil {
+			return err
+		}
+		c.tokens[scope] = token
+		return nil
+	default:
+		return fmt.Errorf("unsupported authentication challenge %q from registry %s", challenge, c.registry)
+	}
+}
+
+// 向认证服务器获取bearer token，有用户名密码时使用basic认证，否则获取匿名的token
+func (c *registryClient) fetchToken(params map[string]string, scope string) (string, error) {
+	realm, err := url.Parse(params["realm"])
+	if err != nil || params["realm"] == "" {
+		return "", fmt.Errorf("invalid bearer realm %q", params["realm"])
+	}
+	query := realm.Query()
+	if params["service"] != "" {
+		query.Set("service", params["service"])
+	}
+	if scope == "" {
+		scope = params["scope"]
+	}
+	for _, s := range strings.Fields(scope) {
+		query.Add("scope", s)
+	}
+	realm.RawQuery = query.Encode()
+	req, err := http.NewRequest(http.MethodGet, realm.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	if c.auth != nil {
+		req.SetBasicAuth(c.auth.Username, c.auth.Password)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("fetch token from %s error %v", realm.Host, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("fetch token from %s error %s", realm.Host, resp.Status)
+	}
+	var result struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("decode token response error %v", err)
+	}
+	if result.Token != "" {
+		return result.Token, nil
+	}
+	if result.AccessToken != "" {
+		return result.AccessToken, nil
+	}
+	return "", fmt.Errorf("no token in response from %s", realm.Host)
+}
+
+// 解析 WWW-Authenticate: Bearer realm="...",service="...",scope="..."
+func parseChallenge(challenge string) (string, map[string]string) {
+	params := make(map[string]string)
+	challenge = strings.TrimSpace(challenge)
+	i := strings.Index(challenge, " ")
+	if i < 0 {
+		return challenge, params
+	}
+	scheme, rest := challenge[:i], challenge[i+1:]
+	for rest != "" {
+		rest = strings.TrimLeft(rest, " ,")
+		eq := strings.Index(rest, "=")
+		if eq < 0 {
+			break
+		}
+		key := strings.ToLower(strings.TrimSpace(rest[:eq]))
+		rest = rest[eq+1:]
+		var value string
+		if strings.HasPrefix(rest, `"`) {
+			//带引号的值中可能有逗号，如 scope="repository:app:pull,push"
+			end := strings.Index(rest[1:], `"`)
+			if end < 0 {
+				value, rest = rest[1:], ""
+			} else {
+				value, rest = rest[1:end+1], rest[end+2:]
+			}
+		} else if comma := strings.Index(rest, ","); comma >= 0 {
+			value, rest = rest[:comma], rest[comma+1:]
+		} else {
+			value, rest = rest, ""
+		}
+		params[key] = value
+	}
+	return scheme, params
+}
+
+// 把仓库返回的错误转换为error，仓库按规范返回 {"errors":[{"code":"","message":""}]}
+func registryError(resp *http.Response, action string) error {
+	content, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	var result struct {
+		Errors []struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	if json.Unmarshal(content, &result) == nil && len(result.Errors) > 0 {
+		var messages []string
+		for _, e := range result.Errors {
+			messages = append(messages, e.Code+": "+e.Message)
+		}
+		return fmt.Errorf("%s error %s %s", action, resp.Status, strings.Join(messages, "; "))
+	}
+	return fmt.Errorf("%s error %s", action, resp.Status)
+}
+
+// 关闭响应，状态码不是codes中的一个时返回仓库的错误信息
+func checkStatus(resp *http.Response, action string, codes ...int) error {
+	defer resp.Body.Close()
+	for _, code := range codes {
+		if resp.StatusCode == code {
+			return nil
+		}
+	}
+	return registryError(resp, action)
+}
+
+// 仓库返回的Location可能是相对地址，需要相对于请求的地址解析
+func resolveLocation(resp *http.Response) (string, error) {
+	location := resp.Header.Get("Location")
+	if location == "" {
+		return "", fmt.Errorf("no Location in response of %s", resp.Request.URL)
+	}
+	u, err := resp.Request.URL.Parse(location)
+	if err != nil {
+		return "", err
+	}
+	return u.String(), nil
+}
+
+// 镜像在仓库中需要的权限，如 repository:team/app:pull
+func repositoryScope(repository, actions string) string {
+	return "repository:" + repository + ":" + actions
+}
@@ -0,0 +1,604 @@
+package image
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestParseChallenge(t *testing.T) {
+	tests := []struct {
+		challenge string
+		scheme    string
+		params    map[string]string
+	}{
+		{
+			challenge: `Bearer realm="https://auth.docker.io/token",service="registry.docker.io",scope="repository:library/busybox:pull"`,
+			scheme:    "Bearer",
+			params: map[string]string{
+				"realm":   "https://auth.docker.io/token",
+				"service": "registry.docker.io",
+				"scope":   "repository:library/busybox:pull",
+			},
+		},
+		{
+			challenge: `Bearer realm="https://auth.example.com/token", scope="repository:team/app:pull,push"`,
+			scheme:    "Bearer",
+			params: map[string]string{
+				"realm": "https://auth.example.com/token",
+				"scope": "repository:team/app:pull,push",
+			},
+		},
+		{
+			challenge: `Basic realm="Registry Realm"`,
+			scheme:    "Basic",
+			params:    map[string]string{"realm": "Registry Realm"},
+		},
+		{
+			challenge: `Bearer Realm=https://auth.example.com/token,service=registry`,
+			scheme:    "Bearer",
+			params:    map[string]string{"realm": "https://auth.example.com/token", "service": "registry"},
+		},
+		{
+			challenge: `  Basic  `,
+			scheme:    "Basic",
+			params:    map[string]string{},
+		},
+		{
+			challenge: `Bearer realm="https://auth.example.com/token`,
+			scheme:    "Bearer",
+			params:    map[string]string{"realm": "https://auth.example.com/token"},
+		},
+		{
+			challenge: "",
+			scheme:    "",
+			params:    map[string]string{},
+		},
+	}
+	for _, tt := range tests {
+		scheme, params := parseChallenge(tt.challenge)
+		if scheme != tt.scheme || !reflect.DeepEqual(params, tt.params) {
+			t.Errorf("parseChallenge(%q) = %q, %v, want %q, %v", tt.challenge, scheme, params, tt.scheme, tt.params)
+		}
+	}
+}
+
+func TestRegistryBearerAuth(t *testing.T) {
+	fr := newFakeRegistry(t)
+	fr.authMode = "bearer"
+	fr.username, fr.password = "user", "secret"
+	digest := fr.addBlob("team/app", []byte("config"))
+
+	c := newRegistryClient(fr.host(), &RegistryAuth{Username: "user", Password: "secret"}, false)
+	scope := repositoryScope("team/app", "pull")
+	for i := 0; i < 2; i++ {
+		exists, err := c.blobExists("team/app", digest, scope)
+		if err != nil {
+			t.Fatalf("blobExists error %v", err)
+		}
+		if !exists {
+			t.Fatalf("blobExists = false, want true")
+		}
+	}
+	//第一次请求返回401，获取token后重试，之后的请求直接使用缓存的token
+	want := []string{
+		"HEAD /v2/team/app/blobs/" + digest + " 401",
+		"GET /token 200",
+		"HEAD /v2/team/app/blobs/" + digest + " 200",
+		"HEAD /v2/team/app/blobs/" + digest + " 200",
+	}
+	if got := fr.requestLines(); !reflect.DeepEqual(got, want) {
+		t.Errorf("requests = %q, want %q", got, want)
+	}
+	if got := fr.tokenScopes; !reflect.DeepEqual(got, []string{scope}) {
+		t.Errorf("token scopes = %q, want %q", got, []string{scope})
+	}
+}
+
+func TestRegistryBearerAuthRejected(t *testing.T) {
+	fr := newFakeRegistry(t)
+	fr.authMode = "bearer"
+	fr.username, fr.password = "user", "secret"
+
+	c := newRegistryClient(fr.host(), &RegistryAuth{Username: "user", Password: "wrong"}, false)
+	if _, err := c.blobExists("team/app", digestOf([]byte("config")), repositoryScope("team/app", "pull")); err == nil {
+		t.Fatalf("blobExists with wrong password succeeded, want error")
+	}
+}
+
+func TestRegistryBasicAuth(t *testing.T) {
+	fr := newFakeRegistry(t)
+	fr.authMode = "basic"
+	fr.username, fr.password = "user", "secret"
+	digest := fr.addBlob("team/app", []byte("config"))
+	scope := repositoryScope("team/app", "pull")
+
+	c := newRegistryClient(fr.host(), nil, false)
+	if _, err := c.blobExists("team/app", digest, scope); err == nil || !strings.Contains(err.Error(), "requires username and password") {
+		t.Fatalf("blobExists without credentials error = %v, want requires username and password", err)
+	}
+
+	c = newRegistryClient(fr.host(), &RegistryAuth{Username: "user", Password: "secret"}, false)
+	for i := 0; i < 2; i++ {
+		content, err := c.fetchBlob("team/app", Descriptor{Digest: digest}, scope)
+		if err != nil {
+			t.Fatalf("fetchBlob error %v", err)
+		}
+		if string(content) != "config" {
+			t.Fatalf("fetchBlob = %q, want %q", content, "config")
+		}
+	}
+	want := []string{
+		"GET /v2/team/app/blobs/" + digest + " 401",
+		"GET /v2/team/app/blobs/" + digest + " 200",
+		"GET /v2/team/app/blobs/" + digest + " 200",
+	}
+	if got := fr.requestLines()[1:]; !reflect.DeepEqual(got, want) {
+		t.Errorf("requests = %q, want %q", got, want)
+	}
+}
+
+// 实现了OCI distribution API中pull和push用到的接口的镜像仓库
+type fakeRegistry struct {
+	server *httptest.Server
+
+	mu          sync.Mutex
+	blobs       map[string][]byte          //digest到blob内容的映射
+	repos       map[string]map[string]bool //镜像名到已有blob的映射
+	manifests   map[string]*fakeManifest   //repository:reference到manifest的映射
+	uploads     map[string]*fakeUpload     //上传ID到上传中的数据的映射
+	nextUpload  int
+	requests    []fakeRequest
+	tokenScopes []string
+
+	authMode  string //为空时不需要认证，basic或者bearer
+	username  string
+	password  string
+	mount     bool           //支持从同一仓库的其他镜像挂载blob
+	truncate  map[string]int //GET blob时只返回前n个字节就断开连接，每个blob只断开一次
+	noRange   bool           //忽略Range请求，总是返回完整的blob
+	failPatch int            //接下来的PATCH请求返回500的次数
+}
+
+type fakeManifest struct {
+	mediaType string
+	content   []byte
+}
+
+type fakeUpload struct {
+	repository string
+	data       []byte
+}
+
+type fakeRequest struct {
+	method string
+	path   string
+	query  string
+	header http.Header
+	status int
+}
+
+func newFakeRegistry(t *testing.T) *fakeRegistry {
+	fr := &fakeRegistry{
+		blobs:     make(map[string][]byte),
+		repos:     make(map[string]map[string]bool),
+		manifests: make(map[string]*fakeManifest),
+		uploads:   make(map[string]*fakeUpload),
+		truncate:  make(map[string]int),
+	}
+	fr.server = httptest.NewServer(http.HandlerFunc(fr.serveHTTP))
+	t.Cleanup(fr.server.Close)
+	return fr
+}
+
+// 仓库的地址，监听在127.0.0.1上，客户端使用http访问
+func (fr *fakeRegistry) host() string {
+	return strings.TrimPrefix(fr.server.URL, "http://")
+}
+
+func (fr *fakeRegistry) addBlob(repository string, content []byte) string {
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+	digest := digestOf(content)
+	fr.blobs[digest] = content
+	fr.linkBlob(repository, digest)
+	return digest
+}
+
+func (fr *fakeRegistry) linkBlob(repository, digest string) {
+	if fr.repos[repository] == nil {
+		fr.repos[repository] = make(map[string]bool)
+	}
+	fr.repos[repository][digest] = true
+}
+
+func (fr *fakeRegistry) hasBlob(repository, digest string) bool {
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+	return fr.repos[repository][digest]
+}
+
+func (fr *fakeRegistry) blob(digest string) []byte {
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+	return fr.blobs[digest]
+}
+
+func (fr *fakeRegistry) addManifest(repository, reference, mediaType string, content []byte) string {
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+	digest := digestOf(content)
+	m := &fakeManifest{mediaType: mediaType, content: content}
+	fr.manifests[repository+":"+reference] = m
+	fr.manifests[repository+":"+digest] = m
+	return digest
+}
+
+func (fr *fakeRegistry) manifest(repository, reference string) *fakeManifest {
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+	return fr.manifests[repository+":"+reference]
+}
+
+// 开始一次上传并写入data，返回上传的地址
+func (fr *fakeRegistry) startUpload(repository string, data []byte) string {
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+	return fr.newUpload(repository, data)
+}
+
+func (fr *fakeRegistry) newUpload(repository string, data []byte) string {
+	fr.nextUpload++
+	id := strconv.Itoa(fr.nextUpload)
+	fr.uploads[id] = &fakeUpload{repository: repository, data: append([]byte{}, data...)}
+	return fr.server.URL + "/v2/" + repository + "/blobs/uploads/" + id
+}
+
+func (fr *fakeRegistry) uploadCount() int {
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+	return len(fr.uploads)
+}
+
+// 按顺序返回 "METHOD path status"
+func (fr *fakeRegistry) requestLines() []string {
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+	var lines []string
+	for _, req := range fr.requests {
+		lines = append(lines, fmt.Sprintf("%s %s %d", req.method, req.path, req.status))
+	}
+	return lines
+}
+
+// 返回满足match的请求
+func (fr *fakeRegistry) findRequests(match func(req fakeRequest) bool) []fakeRequest {
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+	var result []fakeRequest
+	for _, req := range fr.requests {
+		if match(req) {
+			result = append(result, req)
+		}
+	}
+	return result
+}
+
+// 记录响应状态码的ResponseWriter
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Flush() {
+	w.ResponseWriter.(http.Flusher).Flush()
+}
+
+func (fr *fakeRegistry) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+	defer func() {
+		fr.mu.Lock()
+		fr.requests = append(fr.requests, fakeRequest{
+			method: r.Method, path: r.URL.Path, query: r.URL.RawQuery, header: r.Header.Clone(), status: sw.status,
+		})
+		fr.mu.Unlock()
+	}()
+	if r.URL.Path == "/token" {
+		fr.serveToken(sw, r)
+		return
+	}
+	if !strings.HasPrefix(r.URL.Path, "/v2/") {
+		sw.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if !fr.authorized(r) {
+		fr.challenge(sw, r)
+		return
+	}
+	name := strings.TrimPrefix(r.URL.Path, "/v2/")
+	if i := strings.Index(name, "/blobs/uploads/"); i >= 0 {
+		fr.serveUpload(sw, r, name[:i], name[i+len("/blobs/uploads/"):])
+	} else if i := strings.Index(name, "/manifests/"); i >= 0 {
+		fr.serveManifest(sw, r, name[:i], name[i+len("/manifests/"):])
+	} else if i := strings.Index(name, "/blobs/"); i >= 0 {
+		fr.serveBlob(sw, r, name[:i], name[i+len("/blobs/"):])
+	} else {
+		sw.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (fr *fakeRegistry) serveToken(w http.ResponseWriter, r *http.Request) {
+	if fr.username != "" {
+		if username, password, ok := r.BasicAuth(); !ok || username != fr.username || password != fr.password {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+	}
+	fr.mu.Lock()
+	fr.tokenScopes = append(fr.tokenScopes, strings.Join(r.URL.Query()["scope"], " "))
+	fr.mu.Unlock()
+	json.NewEncoder(w).Encode(map[string]string{"token": "fake-token"})
+}
+
+func (fr *fakeRegistry) authorized(r *http.Request) bool {
+	switch fr.authMode {
+	case "basic":
+		username, password, ok := r.BasicAuth()
+		return ok && username == fr.username && password == fr.password
+	case "bearer":
+		return r.Header.Get("Authorization") == "Bearer fake-token"
+	default:
+		return true
+	}
+}
+
+func (fr *fakeRegistry) challenge(w http.ResponseWriter, r *http.Request) {
+	if fr.authMode == "basic" {
+		w.Header().Set("WWW-Authenticate", `Basic realm="fake"`)
+	} else {
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="fake"`, fr.server.URL))
+	}
+	fr.writeError(w, http.StatusUnauthorized, "UNAUTHORIZED", "authentication required")
+}
+
+func (fr *fakeRegistry) writeError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, `{"errors":[{"code":%q,"message":%q}]}`, code, message)
+}
+
+func (fr *fakeRegistry) serveManifest(w http.ResponseWriter, r *http.Request, repository, reference string) {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		m := fr.manifest(repository, reference)
+		if m == nil {
+			fr.writeError(w, http.StatusNotFound, "MANIFEST_UNKNOWN", "manifest unknown")
+			return
+		}
+		w.Header().Set("Content-Type", m.mediaType)
+		w.Header().Set("Docker-Content-Digest", digestOf(m.content))
+		w.Write(m.content)
+	case http.MethodPut:
+		content, err := io.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		var manifest Manifest
+		if err := json.Unmarshal(content, &manifest); err != nil {
+			fr.writeError(w, http.StatusBadRequest, "MANIFEST_INVALID", err.Error())
+			return
+		}
+		for _, desc := range append([]Descriptor{manifest.Config}, manifest.Layers...) {
+			if !fr.hasBlob(repository, desc.Digest) {
+				fr.writeError(w, http.StatusBadRequest, "MANIFEST_BLOB_UNKNOWN", desc.Digest)
+				return
+			}
+		}
+		digest := fr.addManifest(repository, reference, r.Header.Get("Content-Type"), content)
+		w.Header().Set("Docker-Content-Digest", digest)
+		w.WriteHeader(http.StatusCreated)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (fr *fakeRegistry) serveBlob(w http.ResponseWriter, r *http.Request, repository, digest string) {
+	fr.mu.Lock()
+	content, ok := fr.blobs[digest]
+	ok = ok && fr.repos[repository][digest]
+	truncate := fr.truncate[digest]
+	delete(fr.truncate, digest)
+	fr.mu.Unlock()
+	if !ok {
+		fr.writeError(w, http.StatusNotFound, "BLOB_UNKNOWN", "blob unknown")
+		return
+	}
+	if r.Method == http.MethodHead {
+		w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+		return
+	}
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	var start int
+	if value := r.Header.Get("Range"); value != "" && !fr.noRange {
+		start, _ = strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(value, "bytes="), "-"))
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, len(content)-1, len(content)))
+		w.Header().Set("Content-Length", strconv.Itoa(len(content)-start))
+		w.WriteHeader(http.StatusPartialContent)
+	} else {
+		w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+	}
+	if truncate > 0 && start+truncate < len(content) {
+		//发送一部分数据后断开连接，模拟下载中断
+		w.Write(content[start : start+truncate])
+		w.(http.Flusher).Flush()
+		panic(http.ErrAbortHandler)
+	}
+	w.Write(content[start:])
+}
+
+func (fr *fakeRegistry) serveUpload(w http.ResponseWriter, r *http.Request, repository, id string) {
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+	if id == "" {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		query := r.URL.Query()
+		if digest, from := query.Get("mount"), query.Get("from"); fr.mount && digest != "" && fr.repos[from][digest] {
+			fr.linkBlob(repository, digest)
+			w.Header().Set("Location", "/v2/"+repository+"/blobs/"+digest)
+			w.WriteHeader(http.StatusCreated)
+			return
+		}
+		w.Header().Set("Location", fr.newUpload(repository, nil))
+		w.Header().Set("Range", "0-0")
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+	upload, ok := fr.uploads[id]
+	if !ok || upload.repository != repository {
+		fr.writeError(w, http.StatusNotFound, "BLOB_UPLOAD_UNKNOWN", "upload unknown")
+		return
+	}
+	location := "/v2/" + repository + "/blobs/uploads/" + id
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Location", location)
+		w.Header().Set("Range", fmt.Sprintf("0-%d", len(upload.data)-1))
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodPatch:
+		if fr.failPatch > 0 {
+			fr.failPatch--
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		var start, end int
+		if _, err := fmt.Sscanf(r.Header.Get("Content-Range"), "%d-%d", &start, &end); err != nil || start != len(upload.data) {
+			w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+			return
+		}
+		data, err := io.ReadAll(r.Body)
+		if err != nil || len(data) != end-start+1 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		upload.data = append(upload.data, data...)
+		w.Header().Set("Location", location)
+		w.Header().Set("Range", fmt.Sprintf("0-%d", len(upload.data)-1))
+		w.WriteHeader(http.StatusAccepted)
+	case http.MethodPut:
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		content := append(upload.data, data...)
+		digest := r.URL.Query().Get("digest")
+		if digestOf(content) != digest {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		fr.blobs[digest] = content
+		fr.linkBlob(repository, digest)
+		delete(fr.uploads, id)
+		w.Header().Set("Location", "/v2/"+repository+"/blobs/"+digest)
+		w.WriteHeader(http.StatusCreated)
+	case http.MethodDelete:
+		delete(fr.uploads, id)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// 把镜像存储和层存储放到临时目录中
+func setupImageStore(t *testing.T) {
+	imageRoot, layerRoot := ImageRoot, LayerRoot
+	ImageRoot, LayerRoot = t.TempDir(), t.TempDir()
+	t.Cleanup(func() {
+		ImageRoot, LayerRoot = imageRoot, layerRoot
+	})
+}
+
+// 生成一层gzip压缩的tar，文件的属主为当前用户，返回blob和diff_id
+func buildLayer(t *testing.T, files map[string]string) ([]byte, string) {
+	var tarBuf, gzBuf bytes.Buffer
+	tw := tar.NewWriter(&tarBuf)
+	for name, content := range files {
+		hdr := &tar.Header{
+			Name:     name,
+			Typeflag: tar.TypeReg,
+			Mode:     0644,
+			Size:     int64(len(content)),
+			Uid:      os.Getuid(),
+			Gid:      os.Getgid(),
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(tarBuf.Bytes())
+	gz := gzip.NewWriter(&gzBuf)
+	if _, err := gz.Write(tarBuf.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return gzBuf.Bytes(), "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// 在仓库中创建一个镜像，每层只有一个文件，返回manifest和原始的镜像配置
+// 配置中有ImageConfig没有的字段，用于检查pull之后镜像ID和配置是否保持不变
+func (fr *fakeRegistry) addImage(t *testing.T, repository, tag string, layerFiles ...map[string]string) (*Manifest, []byte) {
+	manifest := &Manifest{SchemaVersion: 2, MediaType: MediaTypeImageManifest}
+	var diffIDs []string
+	for _, files := range layerFiles {
+		blob, diffID := buildLayer(t, files)
+		manifest.Layers = append(manifest.Layers, Descriptor{
+			MediaType: MediaTypeLayerGzip,
+			Digest:    fr.addBlob(repository, blob),
+			Size:      int64(len(blob)),
+		})
+		diffIDs = append(diffIDs, diffID)
+	}
+	diffIDsJSON, _ := json.Marshal(diffIDs)
+	config := []byte(fmt.Sprintf(`{"architecture":"amd64","os":"linux","config":{"Env":["PATH=/bin"],"Cmd":["sh"]},`+
+		`"rootfs":{"type":"layers","diff_ids":%s},"moby.buildkit.buildinfo.v1":"e30="}`, diffIDsJSON))
+	manifest.Config = Descriptor{MediaType: MediaTypeImageConfig, Digest: fr.addBlob(repository, config), Size: int64(len(config))}
+	content, err := json.Marshal(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fr.addManifest(repository, tag, MediaTypeImageManifest, content)
+	return manifest, config
+}
@@ -0,0 +1,185 @@
+package image
+
+import (
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+)
+
+// 删除镜像，ref为标签时只删除这个标签，镜像没有其他标签时再删除镜像本身
+// usedBy记录了每个镜像ID被哪些容器使用，被使用的镜像和有多个标签的镜像ID需要force才能删除
+// 返回删除过程中每一步的结果，如 Untagged: busybox:latest、Deleted: sha256:...
+func Remove(ref string, force bool, usedBy map[string][]string) ([]string, error) {
+	id, err := Resolve(ref)
+	if err != nil {
+		return nil, err
+	}
+	tags, err := References(id)
+	if err != nil {
+		return nil, err
+	}
+	named, err := ParseReference(ref)
+	byTag := err == nil && contains(tags, named)
+
+	//通过标签删除有多个标签的镜像时只删除标签
+	if byTag && len(tags) > 1 {
+		if err := Untag(named); err != nil {
+			return nil, err
+		}
+		return []string{"Untagged: " + named}, nil
+	}
+	if !byTag && len(tags) > 1 && !force {
+		return nil, fmt.Errorf("image %s is referenced in multiple repositories %s, use --force to remove", ref, strings.Join(tags, ", "))
+	}
+	if containers := usedBy[id]; len(containers) > 0 && !force {
+		return nil, fmt.Errorf("image %s is being used by container %s, use --force to remove", ref, strings.Join(containers, ", "))
+	}
+	children, err := childrenOf(id)
+	if err != nil {
+		return nil, err
+	}
+	if len(children) > 0 && !force && !byTag {
+		return nil, fmt.Errorf("image %s has dependent child images", ref)
+	}
+
+	var result []string
+	for _, tag := range tags {
+		if err := Untag(tag); err != nil {
+			return result, err
+		}
+		result = append(result, "Untagged: "+tag)
+	}
+	//仍然被容器或者子镜像使用的镜像只删除标签，层由容器和子镜像继续使用
+	if len(usedBy[id]) > 0 || len(children) > 0 {
+		return result, nil
+	}
+	deleted, err := deleteImage(id, usedBy)
+	result = append(result, deleted...)
+	if err != nil {
+		return result, err
+	}
+	if err := gcLayers(); err != nil {
+		return result, err
+	}
+	return result, nil
+}
+
+// 删除镜像的元数据，再依次删除不再被使用的无标签的父镜像(build产生的中间镜像)
+func deleteImage(id string, usedBy map[string][]string) ([]string, error) {
+	var result []string
+	for id != "" {
+		img, err := getImageByID(id)
+		if err != nil {
+			return result, err
+		}
+		if err := removeBuildCache(id); err != nil {
+			return result, err
+		}
+		if err := os.Remove(imagePath(id)); err != nil {
+			return result, fmt.Errorf("remove image %s error %v", id, err)
+		}
+		if err := os.Remove(configPath(id)); err != nil && !os.IsNotExist(err) {
+			return result, fmt.Errorf("remove image %s config error %v", id, err)
+		}
+		result = append(result, "Deleted: "+id)
+
+		id = img.Parent
+		if id == "" || len(usedBy[id]) > 0 {
+			break
+		}
+		if _, err := os.Stat(imagePath(id)); err != nil {
+			break
+		}
+		tags, err := References(id)
+		if err != nil {
+			return result, err
+		}
+		children, err := childrenOf(id)
+		if err != nil {
+			return result, err
+		}
+		if len(tags) > 0 || len(children) > 0 {
+			break
+		}
+	}
+	return result, nil
+}
+
+// 返回以id为父镜像的所有镜像
+func childrenOf(id string) ([]string, error) {
+	images, err := ListImages()
+	if err != nil {
+		return nil, err
+	}
+	var children []string
+	for _, img := range images {
+		if img.Parent == id {
+			children = append(children, img.Id)
+		}
+	}
+	return children, nil
+}
+
+// 删除层存储中不再被任何镜像使用的层
+// 有pull、build等操作正在写入层时跳过回收，未使用的层在之后的rmi中再删除
+func gcLayers() error {
+	unlock, locked, err := tryLockLayersExclusive()
+	if err != nil {
+		return err
+	}
+	if !locked {
+		log.Infof("Layer store is in use, skip removing unused layers")
+		return nil
+	}
+	defer unlock()
+	images, err := ListImages()
+	if err != nil {
+		return err
+	}
+	used := make(map[string]bool)
+	for _, img := range images {
+		for _, diffID := range img.Layers {
+			used[layerDir(img.Driver, diffID)] = true
+		}
+	}
+	drivers, err := ioutil.ReadDir(LayerRoot)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	for _, driver := range drivers {
+		layers, err := ioutil.ReadDir(path.Join(LayerRoot, driver.Name()))
+		if err != nil {
+			return err
+		}
+		for _, layer := range layers {
+			//跳过正在解压的临时目录
+			if validateDigest("sha256:"+layer.Name()) != nil {
+				continue
+			}
+			dir := path.Join(LayerRoot, driver.Name(), layer.Name())
+			if used[dir] {
+				continue
+			}
+			log.Infof("Remove unused layer %s", dir)
+			if err := os.RemoveAll(dir); err != nil {
+				return fmt.Errorf("remove layer %s error %v", dir, err)
+			}
+		}
+	}
+	return nil
+}
+
+func contains(items []string, item string) bool {
+	for _, i := range items {
+		if i == item {
+			return true
+		}
+	}
+	return false
+}
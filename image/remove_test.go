@@ -0,0 +1,35 @@
+package image
+
+import (
+	"os"
+	"path"
+	"testing"
+)
+
+func TestGcLayers(t *testing.T) {
+	setupImageStore(t)
+	layer := path.Join(LayerRoot, "overlay", "0000000000000000000000000000000000000000000000000000000000000000")
+	if err := os.MkdirAll(layer, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	//pull、build正在写入层时不回收，新写入的层还没有被镜像引用
+	unlock, err := LockLayers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := gcLayers(); err != nil {
+		t.Fatalf("gcLayers error %v", err)
+	}
+	if _, err := os.Stat(layer); err != nil {
+		t.Errorf("layer removed while the layer store is locked: %v", err)
+	}
+	unlock()
+
+	if err := gcLayers(); err != nil {
+		t.Fatalf("gcLayers error %v", err)
+	}
+	if _, err := os.Stat(layer); !os.IsNotExist(err) {
+		t.Errorf("unused layer not removed: %v", err)
+	}
+}
@@ -0,0 +1,207 @@
+package image
+
+import (
+	"TinyDocker/storage"
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// 待保存的镜像
+type savedImage struct {
+	img  *Image
+	tags []string
+}
+
+// 把镜像保存为tar格式的archive写入w，同时包含docker save的manifest.json和OCI image layout
+// 两种格式共用blobs/sha256下的blob，层为未压缩的tar，refs为镜像名时保存这个标签，为镜像ID时不保存标签
+func Save(refs []string, w io.Writer) error {
+	//先检查所有镜像都存在，避免写出不完整的archive
+	var images []*savedImage
+	byID := make(map[string]*savedImage)
+	for _, ref := range refs {
+		img, err := GetImage(ref)
+		if err != nil {
+			return err
+		}
+		//同一个镜像的多个标签只保存一次镜像
+		image, ok := byID[img.Id]
+		if !ok {
+			image = &savedImage{img: img}
+			byID[img.Id] = image
+			images = append(images, image)
+		}
+		if named, err := ParseReference(ref); err == nil && !contains(image.tags, named) {
+			if tags, err := References(img.Id); err == nil && contains(tags, named) {
+				image.tags = append(image.tags, named)
+			}
+		}
+	}
+
+	tw := tar.NewWriter(w)
+	for _, dir := range []string{"blobs/", "blobs/sha256/"} {
+		if err := tw.WriteHeader(&tar.Header{Name: dir, Typeflag: tar.TypeDir, Mode: 0755, ModTime: time.Unix(0, 0)}); err != nil {
+			return err
+		}
+	}
+	//同一层只写入一次，key为本地的diff_id
+	written := make(map[string]Descriptor)
+	index := Index{SchemaVersion: 2, MediaType: MediaTypeImageIndex}
+	var dockerManifests []dockerManifest
+	for _, image := range images {
+		img := image.img
+		driver, err := storage.GetDriver(img.Driver)
+		if err != nil {
+			return err
+		}
+		diffIDs := make([]string, len(img.Layers))
+		var layers []Descriptor
+		var layerPaths []string
+		for i, diffID := range img.Layers {
+			desc, ok := written[diffID]
+			if !ok {
+				if desc, err = writeLayerBlob(tw, driver, diffID); err != nil {
+					return err
+				}
+				written[diffID] = desc
+			}
+			//重新打包的层和原来的tar不完全一样，config中记录实际保存的层的digest
+			diffIDs[i] = desc.Digest
+			layers = append(layers, desc)
+			layerPaths = append(layerPaths, blobName(desc.Digest))
+		}
+
+		content, err := img.configWithDiffIDs(diffIDs)
+		if err != nil {
+			return err
+		}
+		configDesc, err := writeBlob(tw, MediaTypeImageConfig, content)
+		if err != nil {
+			return err
+		}
+		manifest := Manifest{
+			SchemaVersion: 2,
+			MediaType:     MediaTypeImageManifest,
+			Config:        configDesc,
+			Layers:        layers,
+		}
+		if content, err = json.Marshal(manifest); err != nil {
+			return err
+		}
+		manifestDesc, err := writeBlob(tw, MediaTypeImageManifest, content)
+		if err != nil {
+			return err
+		}
+
+		if len(image.tags) == 0 {
+			index.Manifests = append(index.Manifests, manifestDesc)
+		}
+		for _, tag := range image.tags {
+			desc := manifestDesc
+			_, tagName := SplitReference(tag)
+			desc.Annotations = map[string]string{AnnotationImageName: tag, AnnotationRefName: tagName}
+			index.Manifests = append(index.Manifests, desc)
+		}
+		dockerManifests = append(dockerManifests, dockerManifest{
+			Config:   blobName(configDesc.Digest),
+			RepoTags: image.tags,
+			Layers:   layerPaths,
+		})
+	}
+
+	layout, err := json.Marshal(map[string]string{"imageLayoutVersion": ImageLayoutVersion})
+	if err != nil {
+		return err
+	}
+	indexContent, err := json.Marshal(index)
+	if err != nil {
+		return err
+	}
+	manifestContent, err := json.Marshal(dockerManifests)
+	if err != nil {
+		return err
+	}
+	if err := writeTarFile(tw, "oci-layout", layout); err != nil {
+		return err
+	}
+	if err := writeTarFile(tw, "index.json", indexContent); err != nil {
+		return err
+	}
+	if err := writeTarFile(tw, "manifest.json", manifestContent); err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+// blob在archive中的路径
+func blobName(digest string) string {
+	return path.Join("blobs", "sha256", strings.TrimPrefix(digest, "sha256:"))
+}
+
+func writeTarFile(tw *tar.Writer, name string, content []byte) error {
+	hdr := &tar.Header{
+		Name:     name,
+		Typeflag: tar.TypeReg,
+		Mode:     0644,
+		Size:     int64(len(content)),
+		ModTime:  time.Unix(0, 0),
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err := tw.Write(content)
+	return err
+}
+
+func writeBlob(tw *tar.Writer, mediaType string, content []byte) (Descriptor, error) {
+	desc := Descriptor{MediaType: mediaType, Digest: digestOf(content), Size: int64(len(content))}
+	return desc, writeTarFile(tw, blobName(desc.Digest), content)
+}
+
+// 重新打包层存储中的一层并写入archive，tar头中需要大小，所以先写到临时文件
+func writeLayerBlob(tw *tar.Writer, driver storage.Driver, diffID string) (Descriptor, error) {
+	file, err := ioutil.TempFile("", "mydocker-save-")
+	if err != nil {
+		return Descriptor{}, err
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+	h := sha256.New()
+	if err := WriteDiff(layerDir(driver.Name(), diffID), driver, io.MultiWriter(file, h)); err != nil {
+		return Descriptor{}, fmt.Errorf("pack layer %s error %v", diffID, err)
+	}
+	size, err := file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return Descriptor{}, err
+	}
+	desc := Descriptor{
+		MediaType: MediaTypeLayer,
+		Digest:    "sha256:" + hex.EncodeToString(h.Sum(nil)),
+		Size:      size,
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return Descriptor{}, err
+	}
+	hdr := &tar.Header{
+		Name:     blobName(desc.Digest),
+		Typeflag: tar.TypeReg,
+		Mode:     0644,
+		Size:     size,
+		ModTime:  time.Unix(0, 0),
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return Descriptor{}, err
+	}
+	if _, err := io.Copy(tw, file); err != nil {
+		return Descriptor{}, err
+	}
+	return desc, nil
+}
@@ -0,0 +1,137 @@
+package image
+
+import (
+	"TinyDocker/storage"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"runtime"
+	"strings"
+	"syscall"
+	"time"
+)
+
+var (
+	// 按内容寻址的层存储，每层以diff_id的sha256值命名，相同的层在多个镜像之间共享
+	// 不同存储驱动的whiteout格式不同，每个驱动使用单独的子目录
+	LayerRoot string = "/root/layers"
+)
+
+// 层存储的锁文件，保存在ImageRoot下，避免被当作层存储中的驱动目录
+const layerLockName = "layers.lock"
+
+// 对层存储加共享锁，返回解锁函数
+// pull、build等操作从写入新层到保存引用它的镜像之间持有共享锁，gcLayers持有排它锁
+// 否则回收时已经写入但还没有被镜像引用的层会被当作无用的层删除
+func LockLayers() (func(), error) {
+	file, err := openLayerLock()
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_SH); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("lock layer store error %v", err)
+	}
+	return func() { file.Close() }, nil
+}
+
+// 尝试对层存储加排它锁，有其他操作正在写入层时返回false
+func tryLockLayersExclusive() (func(), bool, error) {
+	file, err := openLayerLock()
+	if err != nil {
+		return nil, false, err
+	}
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		file.Close()
+		if err == syscall.EWOULDBLOCK {
+			return nil, false, nil
+		}
+		return nil, false, fmt.Errorf("lock layer store error %v", err)
+	}
+	return func() { file.Close() }, true, nil
+}
+
+func openLayerLock() (*os.File, error) {
+	if err := os.MkdirAll(ImageRoot, 0755); err != nil {
+		return nil, err
+	}
+	return os.OpenFile(path.Join(ImageRoot, layerLockName), os.O_RDWR|os.O_CREATE, 0644)
+}
+
+// 某一层解压后的目录
+func layerDir(driver, diffID string) string {
+	return path.Join(LayerRoot, driver, strings.TrimPrefix(diffID, "sha256:"))
+}
+
+// 判断层存储中是否已经有这一层
+func layerExists(driver, diffID string) bool {
+	_, err := os.Stat(layerDir(driver, diffID))
+	return err == nil
+}
+
+// 把一层解压到层存储下的临时目录，返回临时目录和解压后tar流的digest
+func unpackToTemp(reader io.Reader, driver storage.Driver) (string, string, error) {
+	root := path.Join(LayerRoot, driver.Name())
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return "", "", err
+	}
+	tmpDest, err := ioutil.TempDir(root, "tmp-")
+	if err != nil {
+		return "", "", err
+	}
+	diffID, err := unpackLayer(reader, tmpDest, driver)
+	if err != nil {
+		os.RemoveAll(tmpDest)
+		return "", "", err
+	}
+	return tmpDest, diffID, nil
+}
+
+// 把解压好的临时目录放到层存储中，已经存在相同的层时保留已有的层
+func commitLayer(tmpDest, driver, diffID string) error {
+	dest := layerDir(driver, diffID)
+	if err := os.Rename(tmpDest, dest); err != nil {
+		if layerExists(driver, diffID) {
+			return nil
+		}
+		return fmt.Errorf("rename layer %s error %v", dest, err)
+	}
+	//TempDir创建的目录权限为0700，层的根目录就是容器rootfs的根目录
+	return os.Chmod(dest, 0755)
+}
+
+// 把一个rootfs的tar流作为单层镜像导入，如/root下的<image>.tar
+func ImportRootfs(reader io.Reader, imageName string, driver storage.Driver) error {
+	if _, err := ParseReference(imageName); err != nil {
+		return err
+	}
+	unlock, err := LockLayers()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	tmpDest, diffID, err := unpackToTemp(reader, driver)
+	if err != nil {
+		return fmt.Errorf("unpack rootfs error %v", err)
+	}
+	defer os.RemoveAll(tmpDest)
+	if err := commitLayer(tmpDest, driver.Name(), diffID); err != nil {
+		return err
+	}
+	img := &Image{
+		Driver: driver.Name(),
+		Layers: []string{diffID},
+		Config: ImageConfig{
+			Created:      time.Now().UTC().Format(time.RFC3339),
+			Architecture: runtime.GOARCH,
+			OS:           runtime.GOOS,
+			RootFS: RootFS{
+				Type:    "layers",
+				DiffIDs: []string{diffID},
+			},
+		},
+	}
+	return saveAndTag(img, imageName)
+}
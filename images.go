@@ -0,0 +1,158 @@
+package main
+
+import (
+	"TinyDocker/image"
+	"encoding/json"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// image inspect输出的镜像信息
+type imageDetail struct {
+	Id       string            `json:"id"`
+	RepoTags []string          `json:"repoTags"`
+	Parent   string            `json:"parent,omitempty"`
+	Driver   string            `json:"driver"`
+	Layers   []string          `json:"layers"`
+	Size     int64             `json:"size"`
+	Config   image.ImageConfig `json:"config"`
+}
+
+// images列表中的一行
+type imageItem struct {
+	repository string
+	tag        string
+	img        *image.Image
+}
+
+// 列出镜像存储中的镜像，每个标签一行，没有标签也没有子镜像的镜像显示为<none>
+func listImages() error {
+	images, err := image.ListImages()
+	if err != nil {
+		return err
+	}
+	parents := make(map[string]bool)
+	for _, img := range images {
+		parents[img.Parent] = true
+	}
+	var items []imageItem
+	for _, img := range images {
+		tags, err := image.References(img.Id)
+		if err != nil {
+			return err
+		}
+		//build产生的中间镜像不显示
+		if len(tags) == 0 && !parents[img.Id] {
+			items = append(items, imageItem{repository: "<none>", tag: "<none>", img: img})
+		}
+		for _, tag := range tags {
+			repository, tag := image.SplitReference(tag)
+			items = append(items, imageItem{repository: repository, tag: tag, img: img})
+		}
+	}
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].repository != items[j].repository {
+			return items[i].repository < items[j].repository
+		}
+		return items[i].tag < items[j].tag
+	})
+
+	w := tabwriter.NewWriter(os.Stdout, 12, 1, 3, ' ', 0)
+	fmt.Fprint(w, "REPOSITORY\tTAG\tIMAGE ID\tCREATED\tSIZE\n")
+	for _, item := range items {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n",
+			item.repository,
+			item.tag,
+			shortImageID(item.img.Id),
+			imageCreated(item.img),
+			humanSize(uint64(item.img.Size())))
+	}
+	if err := w.Flush(); err != nil {
+		log.Errorf("Flush error %v", err)
+		return err
+	}
+	return nil
+}
+
+// 删除镜像，被容器使用的镜像需要force才能删除
+func removeImages(refs []string, force bool) error {
+	containers, err := getAllContainerInfo()
+	if err != nil {
+		return fmt.Errorf("get all container info error %v", err)
+	}
+	usedBy := make(map[string][]string)
+	for _, containerInfo := range containers {
+		imageId := containerInfo.ImageId
+		if imageId == "" {
+			//旧版本记录的容器信息中没有镜像ID
+			if imageId, err = image.Resolve(containerInfo.ImageName); err != nil {
+				continue
+			}
+		}
+		usedBy[imageId] = append(usedBy[imageId], containerInfo.Name)
+	}
+	var errs []string
+	for _, ref := range refs {
+		result, err := image.Remove(ref, force, usedBy)
+		for _, line := range result {
+			fmt.Println(line)
+		}
+		if err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("remove image error: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// 以JSON格式打印镜像的元数据和配置
+func inspectImage(ref string) error {
+	img, err := image.GetImage(ref)
+	if err != nil {
+		return err
+	}
+	tags, err := image.References(img.Id)
+	if err != nil {
+		return err
+	}
+	detail := &imageDetail{
+		Id:       img.Id,
+		RepoTags: tags,
+		Parent:   img.Parent,
+		Driver:   img.Driver,
+		Layers:   img.Layers,
+		Size:     img.Size(),
+		Config:   img.Config,
+	}
+	content, err := json.MarshalIndent(detail, "", "    ")
+	if err != nil {
+		return fmt.Errorf("json marshal image %s error %v", ref, err)
+	}
+	fmt.Fprintln(os.Stdout, string(content))
+	return nil
+}
+
+// 镜像ID的前12位
+func shortImageID(id string) string {
+	id = strings.TrimPrefix(id, "sha256:")
+	if len(id) > 12 {
+		return id[:12]
+	}
+	return id
+}
+
+// 镜像配置中记录的创建时间，转换为本地时间
+func imageCreated(img *image.Image) string {
+	created, err := time.Parse(time.RFC3339, img.Config.Created)
+	if err != nil {
+		return "-"
+	}
+	return created.Local().Format("2006-01-02 15:04:05")
+}
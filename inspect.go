@@ -0,0 +1,33 @@
+package main
+
+import (
+	subsystems "TinyDocker/cgroup/subsystem"
+	"TinyDocker/container"
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// inspect输出的容器信息，在config.json的基础上附加计算出的实际限制
+type containerDetail struct {
+	*container.ContainerInfo
+	CpuLimit string `json:"cpuLimit,omitempty"` //实际生效的CPU上限(CPU数量)
+}
+
+// 以JSON格式打印容器的详细信息
+func inspectContainer(containerName string) error {
+	containerInfo, err := getContainerInfoByName(containerName)
+	if err != nil {
+		return fmt.Errorf("get container %s info error %v", containerName, err)
+	}
+	detail := &containerDetail{
+		ContainerInfo: containerInfo,
+		CpuLimit:      subsystems.CpuLimitString(containerInfo.ResourceConfig),
+	}
+	content, err := json.MarshalIndent(detail, "", "    ")
+	if err != nil {
+		return fmt.Errorf("json marshal container %s error %v", containerName, err)
+	}
+	fmt.Fprintln(os.Stdout, string(content))
+	return nil
+}
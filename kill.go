@@ -0,0 +1,48 @@
+package main
+
+import (
+	"TinyDocker/container"
+	"fmt"
+	"golang.org/x/sys/unix"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// 给运行中的容器发送信号，不修改容器的状态，容器因此退出时由shim记录为exited
+func killContainer(containerName, signal string) error {
+	sig, err := parseSignal(signal)
+	if err != nil {
+		return err
+	}
+	containerInfo, err := getContainerInfoByName(containerName)
+	if err != nil {
+		return fmt.Errorf("get container %s info error %v", containerName, err)
+	}
+	if containerInfo.Status != container.RUNNING {
+		return fmt.Errorf("container %s is not running", containerName)
+	}
+	if err := signalContainer(containerInfo, sig, false); err != nil {
+		return fmt.Errorf("kill container %s error %v", containerName, err)
+	}
+	return nil
+}
+
+// 解析信号，支持 SIGTERM、TERM 和 15 这几种写法
+func parseSignal(signal string) (syscall.Signal, error) {
+	if num, err := strconv.Atoi(signal); err == nil {
+		if num <= 0 || num > 64 {
+			return 0, fmt.Errorf("invalid signal %s", signal)
+		}
+		return syscall.Signal(num), nil
+	}
+	name := strings.ToUpper(signal)
+	if !strings.HasPrefix(name, "SIG") {
+		name = "SIG" + name
+	}
+	sig := unix.SignalNum(name)
+	if sig == 0 {
+		return 0, fmt.Errorf("invalid signal %s", signal)
+	}
+	return sig, nil
+}
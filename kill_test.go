@@ -0,0 +1,48 @@
+package main
+
+import (
+	"syscall"
+	"testing"
+)
+
+func TestParseSignal(t *testing.T) {
+	tests := []struct {
+		signal  string
+		want    syscall.Signal
+		wantErr bool
+	}{
+		{signal: "SIGTERM", want: syscall.SIGTERM},
+		{signal: "TERM", want: syscall.SIGTERM},
+		{signal: "15", want: syscall.SIGTERM},
+		{signal: "sigkill", want: syscall.SIGKILL},
+		{signal: "kill", want: syscall.SIGKILL},
+		{signal: "9", want: syscall.SIGKILL},
+		{signal: "HUP", want: syscall.SIGHUP},
+		{signal: "SIGUSR1", want: syscall.SIGUSR1},
+		{signal: "1", want: syscall.Signal(1)},
+		{signal: "64", want: syscall.Signal(64)},
+		{signal: "0", wantErr: true},
+		{signal: "-9", wantErr: true},
+		{signal: "65", wantErr: true},
+		{signal: "", wantErr: true},
+		{signal: "SIG", wantErr: true},
+		{signal: "SIGFOO", wantErr: true},
+		{signal: "9x", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseSignal(tt.signal)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseSignal(%q) = %v, want error", tt.signal, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseSignal(%q) error %v", tt.signal, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseSignal(%q) = %d, want %d", tt.signal, got, tt.want)
+		}
+	}
+}
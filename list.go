@@ -1,6 +1,7 @@
 package main
 
 import (
+	subsystems "TinyDocker/cgroup/subsystem"
 	"TinyDocker/container"
 	"encoding/json"
 	"fmt"
@@ -11,36 +12,28 @@ import (
 )
 
 func ListContainers() {
-	//找到存储容器信息的路径 /var/run/mydocker
-	dirUrl := fmt.Sprintf(container.DefaultInfoLocation, "")
-	dirUrl = dirUrl[:len(dirUrl)-1]
-	//读取该文件夹下的所有文件
-	files, err := ioutil.ReadDir(dirUrl)
+	containers, err := getAllContainerInfo()
 	if err != nil {
-		log.Errorf("Read dir %s error %v", dirUrl, err)
+		log.Errorf("Get all container info error %v", err)
 		return
 	}
-	var containers []*container.ContainerInfo
-	for _, file := range files {
-		//将配置文件中的信息转换为容器信息的对象
-		tmpContainer, err := getConainterInfo(file)
-		if err != nil {
-			log.Errorf("Get container info error %v", err)
-			continue
-		}
-		containers = append(containers, tmpContainer)
-	}
 
 	//使用控制台打印出容器信息
 	w := tabwriter.NewWriter(os.Stdout, 12, 1, 3, ' ', 0)
-	fmt.Fprint(w, "ID\tNAME\tPID\tSTATUS\tCOMMAND\tCREATED\n")
+	fmt.Fprint(w, "ID\tNAME\tPID\tSTATUS\tCOMMAND\tCPUS\tCREATED\n")
 	for _, item := range containers {
-		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n",
+		//没有CPU上限时显示为-
+		cpus := subsystems.CpuLimitString(item.ResourceConfig)
+		if cpus == "" {
+			cpus = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
 			item.Id,
 			item.Name,
 			item.Pid,
 			item.Status,
 			item.Command,
+			cpus,
 			item.CreatedTime)
 	}
 
@@ -51,6 +44,37 @@ func ListContainers() {
 	}
 }
 
+// 读取所有容器的信息
+func getAllContainerInfo() ([]*container.ContainerInfo, error) {
+	//找到存储容器信息的路径 /var/run/mydocker
+	dirUrl := fmt.Sprintf(container.DefaultInfoLocation, "")
+	dirUrl = dirUrl[:len(dirUrl)-1]
+	//读取该文件夹下的所有文件
+	files, err := ioutil.ReadDir(dirUrl)
+	if err != nil {
+		//还没有创建过容器
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("read dir %s error %v", dirUrl, err)
+	}
+	var containers []*container.ContainerInfo
+	for _, file := range files {
+		//网络配置也保存在这个目录下，跳过没有config.json的目录
+		if _, err := os.Stat(fmt.Sprintf(container.DefaultInfoLocation, file.Name()) + container.ConfigName); err != nil {
+			continue
+		}
+		//将配置文件中的信息转换为容器信息的对象
+		tmpContainer, err := getConainterInfo(file)
+		if err != nil {
+			log.Errorf("Get container info error %v", err)
+			continue
+		}
+		containers = append(containers, tmpContainer)
+	}
+	return containers, nil
+}
+
 // 将配置文件中的信息转换为容器信息对象
 func getConainterInfo(file os.FileInfo) (*container.ContainerInfo, error) {
 	containerName := file.Name()
@@ -63,7 +87,7 @@ func getConainterInfo(file os.FileInfo) (*container.ContainerInfo, error) {
 	}
 
 	var containerInfo container.ContainerInfo
-	if err := json.Unmarshal(content, containerInfo); err != nil {
+	if err := json.Unmarshal(content, &containerInfo); err != nil {
 		log.Errorf("JSON unmarshal error %v", err)
 	}
 
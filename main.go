@@ -1,6 +1,8 @@
 package main
 
 import (
+	subsystems "TinyDocker/cgroup/subsystem"
+	"TinyDocker/storage"
 	log "github.com/sirupsen/logrus"
 	"github.com/urfave/cli"
 	"os"
@@ -14,16 +16,42 @@ func main() {
 	app := cli.NewApp()
 	app.Name = "mydocker"
 	app.Usage = usage
+	app.Flags = []cli.Flag{
+		cli.StringFlag{
+			Name:   "storage-driver",
+			Usage:  "storage driver of container rootfs, overlay or aufs",
+			EnvVar: "MYDOCKER_STORAGE_DRIVER",
+		},
+	}
 
 	app.Commands = []cli.Command{
 		initCommand,
+		shimCommand,
 		runCommand,
 		listCommand,
+		inspectCommand,
+		updateCommand,
+		statsCommand,
 		logCommand,
 		execCommand,
+		startCommand,
+		restartCommand,
 		stopCommand,
+		killCommand,
+		waitCommand,
 		removeCommand,
 		commitCommand,
+		buildCommand,
+		imagesCommand,
+		rmiCommand,
+		tagCommand,
+		pullCommand,
+		pushCommand,
+		saveCommand,
+		loadCommand,
+		exportCommand,
+		importCommand,
+		imageCommand,
 		networkCommand,
 	}
 
@@ -32,7 +60,11 @@ func main() {
 		log.SetFormatter(&log.JSONFormatter{})
 
 		log.SetOutput(os.Stdout)
-		return nil
+
+		//探测宿主机使用cgroup v1、v2还是hybrid，选择对应的cgroup实现
+		subsystems.Init()
+		//选择挂载容器rootfs的存储驱动，没有指定时优先使用overlay
+		return storage.Init(context.GlobalString("storage-driver"))
 	}
 
 	if err := app.Run(os.Args); err != nil {
@@ -3,17 +3,20 @@ package main
 import (
 	subsystems "TinyDocker/cgroup/subsystem"
 	"TinyDocker/container"
+	"TinyDocker/image"
 	"TinyDocker/network"
+	"TinyDocker/storage"
 	"fmt"
 	log "github.com/sirupsen/logrus"
 	"github.com/urfave/cli"
 	"os"
+	"strings"
 )
 
 var runCommand = cli.Command{
 	Name:  "run",
 	Usage: `Create a container with namespace and cgroups limit ie: mydocker run -ti [image] [command]`,
-	Flags: []cli.Flag{
+	Flags: append([]cli.Flag{
 		cli.BoolFlag{
 			Name:  "ti",
 			Usage: "enable tty",
@@ -22,18 +25,6 @@ var runCommand = cli.Command{
 			Name:  "d",
 			Usage: "detach container",
 		},
-		cli.StringFlag{
-			Name:  "m",
-			Usage: "memory limit",
-		},
-		cli.StringFlag{
-			Name:  "cpushare",
-			Usage: "cpushare limit",
-		},
-		cli.StringFlag{
-			Name:  "cpuset",
-			Usage: "cpuset limit",
-		},
 		cli.StringFlag{
 			Name:  "name",
 			Usage: "container name",
@@ -46,15 +37,48 @@ var runCommand = cli.Command{
 			Name:  "e",
 			Usage: "set environment",
 		},
-	},
+		cli.StringSliceFlag{
+			Name:  "env-file",
+			Usage: "read in a file of environment variables",
+		},
+		cli.StringFlag{
+			Name:  "net",
+			Usage: "container network",
+		},
+		cli.StringSliceFlag{
+			Name:  "p",
+			Usage: "port mapping",
+		},
+		cli.StringFlag{
+			Name:  "shm-size",
+			Usage: "size of /dev/shm ie: 128m",
+		},
+		cli.StringSliceFlag{
+			Name:  "device",
+			Usage: "add a host device to the container ie: /dev/fuse or /dev/sda:/dev/xvda:r",
+		},
+		cli.StringFlag{
+			Name:  "stop-signal",
+			Usage: "signal to stop the container, default is the image's StopSignal or SIGTERM",
+		},
+		cli.StringFlag{
+			Name:  "restart",
+			Value: "no",
+			Usage: "restart policy when the container exits: no, on-failure[:max-retries], always, unless-stopped",
+		},
+		cli.BoolFlag{
+			Name:  "init",
+			Usage: "run an init inside the container that forwards signals and reaps processes",
+		},
+	}, resourceFlags...),
 	/*
-		1. 判断参数是否包含command
-		2. 获取用户指定的command
+		1. 判断参数是否包含镜像名
+		2. 获取用户指定的command，没有指定时使用镜像配置中的命令
 		3. 调用Run function去准备启动容器
 	*/
 	Action: func(context *cli.Context) error {
 		if len(context.Args()) < 1 {
-			return fmt.Errorf("Missing container command")
+			return fmt.Errorf("Missing image name")
 		}
 		var cmdArray []string
 		for _, arg := range context.Args() {
@@ -74,10 +98,9 @@ var runCommand = cli.Command{
 		if createTty && detach {
 			return fmt.Errorf("ti and d paramter can not both provided")
 		}
-		resConf := &subsystems.ResourceConfig{
-			MemoryLimit: context.String("m"),
-			CpuSet:      context.String("cpuset"),
-			CpuShare:    context.String("cpushare"),
+		resConf := &subsystems.ResourceConfig{}
+		if err := setResourceConfig(context, resConf); err != nil {
+			return err
 		}
 		log.Infof("createTty %v", createTty)
 		//传递容器名称
@@ -85,8 +108,39 @@ var runCommand = cli.Command{
 		//获取voluem的参数
 		volume := context.String("v")
 
-		envSlice := context.StringSlice("e")
-		Run(createTty, cmdArray, resConf, containerName, volume, imageName, envSlice)
+		envSlice, err := getEnvSlice(context.StringSlice("env-file"), context.StringSlice("e"))
+		if err != nil {
+			return err
+		}
+		nw := context.String("net")
+		portmapping := context.StringSlice("p")
+		shmSize := int64(container.DefaultShmSize)
+		if context.IsSet("shm-size") {
+			if shmSize, err = subsystems.ParseBytes(context.String("shm-size")); err != nil || shmSize == 0 {
+				return fmt.Errorf("invalid shm size %s", context.String("shm-size"))
+			}
+		}
+		devices := context.StringSlice("device")
+		useInit := context.Bool("init")
+		stopSignal := context.String("stop-signal")
+		if stopSignal != "" {
+			if _, err := parseSignal(stopSignal); err != nil {
+				return err
+			}
+		}
+		restartPolicy, err := container.ParseRestartPolicy(context.String("restart"))
+		if err != nil {
+			return err
+		}
+		//使用-ti的容器没有shim，退出后没有进程负责重启，只有detach的容器可以重启
+		if createTty && restartPolicy.Name != "no" {
+			return fmt.Errorf("restart policy can not be used with ti")
+		}
+		exitCode := Run(createTty, cmdArray, resConf, containerName, volume, imageName, envSlice, nw, portmapping,
+			shmSize, devices, useInit, stopSignal, restartPolicy)
+		if exitCode != 0 {
+			return cli.NewExitError("", exitCode)
+		}
 		return nil
 	},
 }
@@ -101,6 +155,18 @@ var initCommand = cli.Command{
 	},
 }
 
+var shimCommand = cli.Command{
+	Name:   "shim",
+	Usage:  "Start and monitor a detached container. Do not call it outside",
+	Hidden: true,
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) < 1 {
+			return fmt.Errorf("Missing container name")
+		}
+		return runShim(context.Args().Get(0))
+	},
+}
+
 var listCommand = cli.Command{
 	Name:  "ps",
 	Usage: "list all the containers",
@@ -110,6 +176,45 @@ var listCommand = cli.Command{
 	},
 }
 
+var inspectCommand = cli.Command{
+	Name:  "inspect",
+	Usage: "display detailed information of a container",
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) < 1 {
+			return fmt.Errorf("Missing container name")
+		}
+		containerName := context.Args().Get(0)
+		return inspectContainer(containerName)
+	},
+}
+
+var updateCommand = cli.Command{
+	Name:  "update",
+	Usage: "update resource limits of a container ie: mydocker update -m 200m [container]",
+	Flags: resourceFlags,
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) < 1 {
+			return fmt.Errorf("Missing container name")
+		}
+		containerName := context.Args().Get(0)
+		return updateContainer(containerName, context)
+	},
+}
+
+var statsCommand = cli.Command{
+	Name:  "stats",
+	Usage: "display live resource usage of containers ie: mydocker stats [container...]",
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "no-stream",
+			Usage: "print stats once in json and exit",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		return statsContainers(context.Args(), context.Bool("no-stream"))
+	},
+}
+
 var logCommand = cli.Command{
 	Name:  "logs",
 	Usage: "print logs of a container",
@@ -126,11 +231,17 @@ var logCommand = cli.Command{
 var execCommand = cli.Command{
 	Name:  "exec",
 	Usage: "exec a command into container",
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "ti",
+			Usage: "allocate a tty in the container",
+		},
+	},
 	Action: func(context *cli.Context) error {
 		//cgo的setns只要被导入就会执行，，哪些不需要exec的容器命令会受到影响
 		//对于不需要exec功能的GO代码，只要不设置对应的环境变量，就会直接退出
 		if os.Getenv(ENV_EXEC_PID) != "" {
-			log.Infof("pid callback pid %s", os.Getgid())
+			log.Infof("pid callback pid %d", os.Getgid())
 			return nil
 		}
 
@@ -143,7 +254,7 @@ var execCommand = cli.Command{
 			commandArray = append(commandArray, arg)
 		}
 		//执行命令
-		ExecContainer(containerName, commandArray)
+		ExecContainer(containerName, commandArray, context.Bool("ti"))
 		return nil
 	},
 }
@@ -151,16 +262,121 @@ var execCommand = cli.Command{
 var stopCommand = cli.Command{
 	Name:  "stop",
 	Usage: "stop a container",
+	Flags: []cli.Flag{
+		cli.IntFlag{
+			Name:  "t",
+			Value: 10,
+			Usage: "seconds to wait for the container to stop before killing it",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) < 1 {
+			return fmt.Errorf("Missing container name")
+		}
+		if context.Int("t") < 0 {
+			return fmt.Errorf("invalid timeout %d", context.Int("t"))
+		}
+		containerName := context.Args().Get(0)
+		return stopContainer(containerName, context.Int("t"))
+	},
+}
+
+var startCommand = cli.Command{
+	Name:  "start",
+	Usage: "start a stopped container",
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "a",
+			Usage: "attach to the container's output until it exits",
+		},
+	},
 	Action: func(context *cli.Context) error {
 		if len(context.Args()) < 1 {
 			return fmt.Errorf("Missing container name")
 		}
 		containerName := context.Args().Get(0)
-		stopContainer(containerName)
+		if err := startStoppedContainer(containerName, context.Bool("a")); err != nil {
+			return err
+		}
+		//attach时退出码和容器的退出码一致
+		if context.Bool("a") {
+			exitCode, err := waitContainer(containerName)
+			if err != nil {
+				return err
+			}
+			if exitCode != 0 {
+				return cli.NewExitError("", exitCode)
+			}
+		}
 		return nil
 	},
 }
 
+var waitCommand = cli.Command{
+	Name:  "wait",
+	Usage: "block until one or more containers stop, then print their exit codes",
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) < 1 {
+			return fmt.Errorf("Missing container name")
+		}
+		var failed []string
+		for _, containerName := range context.Args() {
+			exitCode, err := waitContainer(containerName)
+			if err != nil {
+				log.Errorf("Wait container %s error %v", containerName, err)
+				failed = append(failed, containerName)
+				continue
+			}
+			fmt.Println(exitCode)
+		}
+		if len(failed) > 0 {
+			return fmt.Errorf("failed to wait containers %s", strings.Join(failed, " "))
+		}
+		return nil
+	},
+}
+
+var restartCommand = cli.Command{
+	Name:  "restart",
+	Usage: "restart a container",
+	Flags: []cli.Flag{
+		cli.IntFlag{
+			Name:  "t",
+			Value: 10,
+			Usage: "seconds to wait for the container to stop before killing it",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) < 1 {
+			return fmt.Errorf("Missing container name")
+		}
+		if context.Int("t") < 0 {
+			return fmt.Errorf("invalid timeout %d", context.Int("t"))
+		}
+		containerName := context.Args().Get(0)
+		return restartContainer(containerName, context.Int("t"))
+	},
+}
+
+var killCommand = cli.Command{
+	Name:  "kill",
+	Usage: "send a signal to a running container",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "s",
+			Value: "SIGKILL",
+			Usage: "signal to send to the container",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) < 1 {
+			return fmt.Errorf("Missing container name")
+		}
+		containerName := context.Args().Get(0)
+		return killContainer(containerName, context.String("s"))
+	},
+}
+
 var removeCommand = cli.Command{
 	Name:  "rm",
 	Usage: "remove unused containers",
@@ -176,18 +392,240 @@ var removeCommand = cli.Command{
 
 var commitCommand = cli.Command{
 	Name:  "commit",
-	Usage: "commit a container into image",
+	Usage: "commit the changes of a container into a new image",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "a, author",
+			Usage: "author of the new image",
+		},
+		cli.StringFlag{
+			Name:  "m, message",
+			Usage: "commit message",
+		},
+	},
 	Action: func(context *cli.Context) error {
 		if len(context.Args()) < 2 {
 			return fmt.Errorf("Missing container name and image name")
 		}
 		containerName := context.Args().Get(0)
 		imageName := context.Args().Get(1)
-		commitContainer(containerName, imageName)
+		return commitContainer(containerName, imageName, context.String("author"), context.String("message"))
+	},
+}
+
+var buildCommand = cli.Command{
+	Name:  "build",
+	Usage: "build an image from a build file ie: mydocker build -t [name] [context]",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "t",
+			Usage: "name of the image",
+		},
+		cli.StringFlag{
+			Name:  "f",
+			Usage: "build file, default is Dockerfile in the context",
+		},
+		cli.BoolFlag{
+			Name:  "no-cache",
+			Usage: "do not use cache when building the image",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if context.String("t") == "" {
+			return fmt.Errorf("Missing image name")
+		}
+		contextDir := "."
+		if len(context.Args()) > 0 {
+			contextDir = context.Args().Get(0)
+		}
+		return buildImage(contextDir, context.String("f"), context.String("t"), context.Bool("no-cache"))
+	},
+}
+
+var imagesCommand = cli.Command{
+	Name:  "images",
+	Usage: "list images",
+	Action: func(context *cli.Context) error {
+		return listImages()
+	},
+}
+
+var rmiCommand = cli.Command{
+	Name:  "rmi",
+	Usage: "remove images ie: mydocker rmi [image...]",
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "f, force",
+			Usage: "force removal of the image",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) < 1 {
+			return fmt.Errorf("Missing image name")
+		}
+		return removeImages(context.Args(), context.Bool("force"))
+	},
+}
+
+var tagCommand = cli.Command{
+	Name:  "tag",
+	Usage: "create a tag that refers to an image ie: mydocker tag [source] [target]",
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) < 2 {
+			return fmt.Errorf("Missing source image and target name")
+		}
+		return image.Tag(context.Args().Get(0), context.Args().Get(1))
+	},
+}
+
+var saveCommand = cli.Command{
+	Name:  "save",
+	Usage: "save images to a tar archive ie: mydocker save -o [file] [image...]",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "o, output",
+			Usage: "write to a file instead of stdout",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) < 1 {
+			return fmt.Errorf("Missing image name")
+		}
+		return saveImages(context.Args(), context.String("output"))
+	},
+}
+
+var loadCommand = cli.Command{
+	Name:  "load",
+	Usage: "load images from a tar archive ie: mydocker load -i [file]",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "i, input",
+			Usage: "read from a file instead of stdin",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		return loadImages(context.String("input"))
+	},
+}
+
+var exportCommand = cli.Command{
+	Name:  "export",
+	Usage: "export the filesystem of a container as a tar archive ie: mydocker export -o [file] [container]",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "o, output",
+			Usage: "write to a file instead of stdout",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) < 1 {
+			return fmt.Errorf("Missing container name")
+		}
+		return exportContainer(context.Args().Get(0), context.String("output"))
+	},
+}
+
+var importCommand = cli.Command{
+	Name:  "import",
+	Usage: "create a single layer image from a rootfs tar archive, - for stdin ie: mydocker import [file] [image]",
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) < 2 {
+			return fmt.Errorf("Missing file and image name")
+		}
+		return importRootfs(context.Args().Get(0), context.Args().Get(1))
+	},
+}
+
+// 访问镜像仓库的参数，pull和push共用
+var registryFlags = []cli.Flag{
+	cli.StringFlag{
+		Name:  "u, username",
+		Usage: "registry username",
+	},
+	cli.StringFlag{
+		Name:  "password",
+		Usage: "registry password",
+	},
+	cli.BoolFlag{
+		Name:  "insecure",
+		Usage: "use http instead of https",
+	},
+}
+
+func getRegistryAuth(context *cli.Context) *image.RegistryAuth {
+	if !context.IsSet("username") {
+		return nil
+	}
+	return &image.RegistryAuth{Username: context.String("username"), Password: context.String("password")}
+}
+
+var pullCommand = cli.Command{
+	Name:  "pull",
+	Usage: "pull an image from a registry ie: mydocker pull [registry/]name[:tag]",
+	Flags: registryFlags,
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) < 1 {
+			return fmt.Errorf("Missing image name")
+		}
+		imageName, err := image.Pull(context.Args().Get(0), storage.DefaultDriver, getRegistryAuth(context), context.Bool("insecure"))
+		if err != nil {
+			return fmt.Errorf("pull image error: %+v", err)
+		}
+		fmt.Println(imageName)
 		return nil
 	},
 }
 
+var pushCommand = cli.Command{
+	Name:  "push",
+	Usage: "push an image to a registry ie: mydocker push [registry/]name[:tag]",
+	Flags: registryFlags,
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) < 1 {
+			return fmt.Errorf("Missing image name")
+		}
+		digest, err := image.Push(context.Args().Get(0), getRegistryAuth(context), context.Bool("insecure"))
+		if err != nil {
+			return fmt.Errorf("push image error: %+v", err)
+		}
+		fmt.Println(digest)
+		return nil
+	},
+}
+
+var imageCommand = cli.Command{
+	Name:  "image",
+	Usage: "image commands",
+	Subcommands: []cli.Command{
+		{
+			Name:  "inspect",
+			Usage: "print the config of an image as json ie: mydocker image inspect [image]",
+			Action: func(context *cli.Context) error {
+				if len(context.Args()) < 1 {
+					return fmt.Errorf("Missing image name")
+				}
+				return inspectImage(context.Args().Get(0))
+			},
+		},
+		{
+			Name:  "load",
+			Usage: "import an OCI image layout or docker save archive ie: mydocker image load [path] [name]",
+			Action: func(context *cli.Context) error {
+				if len(context.Args()) < 1 {
+					return fmt.Errorf("Missing image path")
+				}
+				imageName, err := image.Import(context.Args().Get(0), context.Args().Get(1), storage.DefaultDriver)
+				if err != nil {
+					return fmt.Errorf("load image error: %+v", err)
+				}
+				fmt.Println(imageName)
+				return nil
+			},
+		},
+	},
+}
+
 var networkCommand = cli.Command{
 	Name:  "network",
 	Usage: "container network commands",
@@ -161,6 +161,11 @@ func (d *BridgeNetworkDriver) Connect(network *Network, endpoint *Endpoint) erro
 	return nil
 }
 
+// 删除宿主机上的Veth，Veth不存在时说明已经随容器的Network Namespace一起删除了
 func (d *BridgeNetworkDriver) Disconnect(network Network, endpoint *Endpoint) error {
-	return nil
+	link, err := netlink.LinkByName(endpoint.ID[:5])
+	if err != nil {
+		return nil
+	}
+	return netlink.LinkDel(link)
 }
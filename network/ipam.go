@@ -1,7 +1,9 @@
 package network
 
 import (
+	"encoding/binary"
 	"encoding/json"
+	"fmt"
 	log "github.com/sirupsen/logrus"
 	"net"
 	"os"
@@ -141,3 +143,31 @@ func (ipam *IPAM) Release(subnet *net.IPNet, ipaddr *net.IP) error {
 	ipam.dump()
 	return nil
 }
+
+// 分配指定的IP地址，用于重启的容器继续使用之前的IP，IP已经被分配时返回错误
+func (ipam *IPAM) AllocateIP(subnet *net.IPNet, ipaddr net.IP) error {
+	ipam.Subnets = &map[string]string{}
+	if err := ipam.load(); err != nil {
+		log.Errorf("Error load allocation info, %v", err)
+	}
+	ip := ipaddr.To4()
+	if ip == nil || !subnet.Contains(ip) {
+		return fmt.Errorf("ip %s is not in subnet %s", ipaddr, subnet)
+	}
+	one, size := subnet.Mask.Size()
+	if _, exist := (*ipam.Subnets)[subnet.String()]; !exist {
+		(*ipam.Subnets)[subnet.String()] = strings.Repeat("0", 1<<uint8(size-one))
+	}
+	//和Allocate一样，位图中的序号c对应网段IP加上c+1
+	c := int(binary.BigEndian.Uint32(ip)) - int(binary.BigEndian.Uint32(subnet.IP.To4())) - 1
+	ipalloc := []byte((*ipam.Subnets)[subnet.String()])
+	if c < 0 || c >= len(ipalloc) {
+		return fmt.Errorf("ip %s is not in subnet %s", ipaddr, subnet)
+	}
+	if ipalloc[c] == '1' {
+		return fmt.Errorf("ip %s is already allocated", ipaddr)
+	}
+	ipalloc[c] = '1'
+	(*ipam.Subnets)[subnet.String()] = string(ipalloc)
+	return ipam.dump()
+}
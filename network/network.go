@@ -30,11 +30,11 @@ type Network struct {
 }
 
 type Endpoint struct {
-	ID          string           `json:id`
+	ID          string           `json:"id"`
 	Device      netlink.Veth     `josn:"dev"`
 	IPAddress   net.IP           `jsohn:"ip"`
 	MacAddress  net.HardwareAddr `josn:"mac"`
-	PortMapping []string         `josn:"portmapping"'`
+	PortMapping []string         `josn:"portmapping"`
 	Network     *Network
 }
 
@@ -76,19 +76,19 @@ func (nw *Network) dump(dumpPath string) error {
 	nwPath := path.Join(dumpPath, nw.Name)
 	nwFile, err := os.OpenFile(nwPath, os.O_TRUNC|os.O_WRONLY|os.O_CREATE, 0644)
 	if err != nil {
-		logrus.Errorf("error:", err)
+		logrus.Errorf("error: %v", err)
 		return err
 	}
 	defer nwFile.Close()
 
 	nwJson, err := json.Marshal(nw)
 	if err != nil {
-		logrus.Errorf("error:", err)
+		logrus.Errorf("error: %v", err)
 		return err
 	}
 	_, err = nwFile.Write(nwJson)
 	if err != nil {
-		logrus.Errorf("error:", err)
+		logrus.Errorf("error: %v", err)
 		return err
 	}
 	return nil
@@ -107,7 +107,7 @@ func (nw *Network) load(dumpPath string) error {
 	}
 	err = json.Unmarshal(nwJson[:n], nw)
 	if err != nil {
-		logrus.Errorf("Error load nw info", err)
+		logrus.Errorf("Error load nw info %v", err)
 		return err
 	}
 	return nil
@@ -120,10 +120,22 @@ func Connect(networkName string, cinfo *container.ContainerInfo) error {
 		return fmt.Errorf("No Such Network: %s", networkName)
 	}
 	//通过调用IPAM从网络的网段中获取可用的IP作为容器的IP地址
-	ip, err := ipAllocator.Allocate(network.IpRange)
-	if err != nil {
-		return err
+	//重新启动的容器优先使用之前的IP
+	var ip net.IP
+	if cinfo.IPAddress != "" {
+		if err := ipAllocator.AllocateIP(network.IpRange, net.ParseIP(cinfo.IPAddress)); err != nil {
+			logrus.Warnf("Reuse ip %s error %v, allocate a new one", cinfo.IPAddress, err)
+		} else {
+			ip = net.ParseIP(cinfo.IPAddress).To4()
+		}
+	}
+	if ip == nil {
+		var err error
+		if ip, err = ipAllocator.Allocate(network.IpRange); err != nil {
+			return err
+		}
 	}
+	cinfo.IPAddress = ip.String()
 	//创建网络端点
 	ep := &Endpoint{
 		ID:          fmt.Sprintf("%s-%s", cinfo.Id, networkName),
@@ -138,7 +150,7 @@ func Connect(networkName string, cinfo *container.ContainerInfo) error {
 		return err
 	}
 	//在容器的Namespace中配置容器网络。设备IP和路由信息
-	if err = configEndpointIpAddressAndRoute(ep, cinfo); err != nil {
+	if err := configEndpointIpAddressAndRoute(ep, cinfo); err != nil {
 		return err
 	}
 	//配置i容器到宿主机的端口映射
@@ -305,6 +317,22 @@ func enterContainerNetns(enLink *netlink.Link, cinfo *container.ContainerInfo) f
 	}
 }
 
+// 删除configPortMapping添加的端口映射规则
+func deletePortMapping(ep *Endpoint) {
+	for _, pm := range ep.PortMapping {
+		portMapping := strings.Split(pm, ":")
+		if len(portMapping) != 2 {
+			continue
+		}
+		iptableCmd := fmt.Sprintf("-t nat -D PREROUTING -p tcp -m tcp --dport %s -j DNAT --to-destination %s:%s",
+			portMapping[0], ep.IPAddress.String(), portMapping[1])
+		cmd := exec.Command("iptables", strings.Split(iptableCmd, " ")...)
+		if output, err := cmd.CombinedOutput(); err != nil {
+			logrus.Errorf("iptables Output, %s", output)
+		}
+	}
+}
+
 // 配置端口映射
 func configPortMapping(ep *Endpoint, cinfo *container.ContainerInfo) error {
 	//遍历容器端口映射列表
@@ -329,6 +357,47 @@ func configPortMapping(ep *Endpoint, cinfo *container.ContainerInfo) error {
 	return nil
 }
 
+/*
+容器退出后断开网络：
+1. 删除宿主机上的Veth，容器的Network Namespace销毁时另一端也会删除
+2. 删除端口映射的iptables规则
+3. 释放容器的IP，容器重新启动时如果IP没有被占用会继续使用
+*/
 func Disconnect(networkName string, cinfo *container.ContainerInfo) error {
-	return nil
+	network, ok := networks[networkName]
+	if !ok {
+		return fmt.Errorf("No Such Network: %s", networkName)
+	}
+	ep := &Endpoint{
+		ID:          fmt.Sprintf("%s-%s", cinfo.Id, networkName),
+		IPAddress:   net.ParseIP(cinfo.IPAddress).To4(),
+		PortMapping: cinfo.PortMapping,
+		Network:     network,
+	}
+	if err := drivers[network.Driver].Disconnect(*network, ep); err != nil {
+		logrus.Errorf("Disconnect endpoint %s error %v", ep.ID, err)
+	}
+	if ep.IPAddress == nil {
+		return nil
+	}
+	deletePortMapping(ep)
+	//Release会修改传入的IP，需要传入副本
+	ip := append(net.IP{}, ep.IPAddress...)
+	return ipAllocator.Release(network.IpRange, &ip)
+}
+
+// 读取容器网络的收发字节数，宿主机上的Veth一端收到的数据就是容器发出的数据
+func EndpointStats(cinfo *container.ContainerInfo) (uint64, uint64, error) {
+	if len(cinfo.Id) < 5 {
+		return 0, 0, fmt.Errorf("invalid container id %s", cinfo.Id)
+	}
+	link, err := netlink.LinkByName(cinfo.Id[:5])
+	if err != nil {
+		return 0, 0, err
+	}
+	statistics := link.Attrs().Statistics
+	if statistics == nil {
+		return 0, 0, fmt.Errorf("no statistics of link %s", cinfo.Id[:5])
+	}
+	return statistics.TxBytes, statistics.RxBytes, nil
 }
@@ -9,6 +9,80 @@ package nsenter
 #include <stdlib.h>
 #include <string.h>
 #include <fcntl.h>
+#include <grp.h>
+#include <sys/ioctl.h>
+#include <sys/wait.h>
+
+//从/proc/self/cmdline中读出exec之后的参数作为要执行的命令，参数之间以\0分隔
+//必须在setns进入mnt Namespace之前读取，之后/proc是容器中的proc
+static char **read_exec_args(void) {
+	int fd = open("/proc/self/cmdline", O_RDONLY);
+	if (fd == -1) {
+		return NULL;
+	}
+	size_t size = 0, cap = 4096;
+	char *buf = malloc(cap);
+	ssize_t n;
+	while (buf && (n = read(fd, buf + size, cap - size)) > 0) {
+		size += n;
+		if (size == cap) {
+			cap *= 2;
+			buf = realloc(buf, cap);
+		}
+	}
+	close(fd);
+	if (!buf) {
+		return NULL;
+	}
+	int argc = 0;
+	size_t i;
+	for (i = 0; i < size; i++) {
+		if (buf[i] == '\0') {
+			argc++;
+		}
+	}
+	//跳过/proc/self/exe和exec
+	if (argc < 3) {
+		return NULL;
+	}
+	char **argv = calloc(argc - 1, sizeof(char *));
+	char *arg = buf;
+	int index = 0;
+	while (arg < buf + size) {
+		if (index >= 2) {
+			argv[index - 2] = arg;
+		}
+		index++;
+		arg += strlen(arg) + 1;
+	}
+	return argv;
+}
+
+//切换到容器进程的用户，先设置附加组和主组，最后设置uid
+static int set_user(void) {
+	char *uid = getenv("mydocker_uid");
+	char *gid = getenv("mydocker_gid");
+	char *groups = getenv("mydocker_groups");
+	gid_t gids[64];
+	int ngroups = 0;
+	if (groups) {
+		char *group = strtok(groups, ",");
+		while (group && ngroups < 64) {
+			gids[ngroups++] = atoi(group);
+			group = strtok(NULL, ",");
+		}
+	}
+	if (setgroups(ngroups, gids) == -1) {
+		return -1;
+	}
+	if (gid && setgid(atoi(gid)) == -1) {
+		return -1;
+	}
+	if (uid && setuid(atoi(uid)) == -1) {
+		return -1;
+	}
+	return 0;
+}
 
 //__attribute__类似于构造函数，一旦这个包被引用，这个函数就会自动执行
 __attribute__((constructor)) void enter_namespace(void) {
@@ -21,14 +95,11 @@ __attribute__((constructor)) void enter_namespace(void) {
 		//fprintf(stdout, "missing mydocker_pid env skip nsenter");
 		return;
 	}
-	char *mydocker_cmd;
-	//从环境变量中获取需要执行的命令
-	mydocker_cmd = getenv("mydocker_cmd");
-	if (mydocker_cmd) {
-		//fprintf(stdout, "got mydocker_cmd=%s\n", mydocker_cmd);
-	} else {
-		//fprintf(stdout, "missing mydocker_cmd env skip nsenter");
-		return;
+	//从命令行参数中获取需要执行的命令
+	char **argv = read_exec_args();
+	if (!argv) {
+		fprintf(stderr, "missing exec command\n");
+		exit(1);
 	}
 	int i;
 	char nspath[1024];
@@ -47,8 +118,37 @@ __attribute__((constructor)) void enter_namespace(void) {
 		}
 		close(fd);
 	}
-	//在进入的Namespace中执行指定的命令
-	int res = system(mydocker_cmd);
+	//在进入的Namespace中执行指定的命令，fork出的子进程才会进入新的PID Namespace
+	pid_t child = fork();
+	if (child == 0) {
+		//exec -ti时在容器的PID Namespace中创建新的会话，并把pty设置为控制终端
+		if (getenv("mydocker_tty")) {
+			setsid();
+			ioctl(0, TIOCSCTTY, 0);
+		}
+		//以容器进程的用户在它的工作目录下运行，和init进程一样先chdir再切换用户
+		char *cwd = getenv("mydocker_cwd");
+		if (cwd && chdir(cwd) == -1) {
+			fprintf(stderr, "chdir %s failed: %s\n", cwd, strerror(errno));
+			exit(126);
+		}
+		if (set_user() == -1) {
+			fprintf(stderr, "set user failed: %s\n", strerror(errno));
+			exit(126);
+		}
+		//这些变量只用于进入容器，不传给用户命令
+		unsetenv("mydocker_pid");
+		unsetenv("mydocker_tty");
+		unsetenv("mydocker_cwd");
+		unsetenv("mydocker_uid");
+		unsetenv("mydocker_gid");
+		unsetenv("mydocker_groups");
+		execvp(argv[0], argv);
+		fprintf(stderr, "exec %s failed: %s\n", argv[0], strerror(errno));
+		exit(127);
+	}
+	int status;
+	waitpid(child, &status, 0);
 	exit(0);
 	return;
 }
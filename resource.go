@@ -0,0 +1,114 @@
+package main
+
+import (
+	subsystems "TinyDocker/cgroup/subsystem"
+	"github.com/urfave/cli"
+)
+
+// run和update共用的资源限制参数
+var resourceFlags = []cli.Flag{
+	cli.StringFlag{
+		Name:  "m",
+		Usage: "memory limit",
+	},
+	cli.StringFlag{
+		Name:  "cpushare",
+		Usage: "cpushare limit",
+	},
+	cli.StringFlag{
+		Name:  "cpuset",
+		Usage: "cpuset limit",
+	},
+	cli.StringFlag{
+		Name:  "cpus",
+		Usage: "number of cpus ie: 1.5",
+	},
+	cli.StringFlag{
+		Name:  "cpu-quota",
+		Usage: "cpu cfs quota in microseconds, -1 for unlimited",
+	},
+	cli.StringFlag{
+		Name:  "cpu-period",
+		Usage: "cpu cfs period in microseconds",
+	},
+	cli.StringFlag{
+		Name:  "memory-swap",
+		Usage: "memory plus swap limit, -1 for unlimited swap",
+	},
+	cli.StringFlag{
+		Name:  "memory-reservation",
+		Usage: "memory soft limit",
+	},
+	cli.StringFlag{
+		Name:  "pids-limit",
+		Usage: "max number of processes, -1 for unlimited",
+	},
+	cli.StringFlag{
+		Name:  "blkio-weight",
+		Usage: "block io weight (10-1000)",
+	},
+	cli.StringSliceFlag{
+		Name:  "device-read-bps",
+		Usage: "limit read rate from a device ie: /dev/sda:10mb",
+	},
+	cli.StringSliceFlag{
+		Name:  "device-write-bps",
+		Usage: "limit write rate to a device ie: /dev/sda:10mb",
+	},
+	cli.StringSliceFlag{
+		Name:  "device-read-iops",
+		Usage: "limit read io per second from a device ie: /dev/sda:1000",
+	},
+	cli.StringSliceFlag{
+		Name:  "device-write-iops",
+		Usage: "limit write io per second to a device ie: /dev/sda:1000",
+	},
+	cli.StringSliceFlag{
+		Name:  "hugetlb-limit",
+		Usage: "hugetlb limit ie: 2MB:1g",
+	},
+}
+
+// 把命令行中指定的资源限制参数写入res，没有指定的参数保留res中原来的值
+func setResourceConfig(context *cli.Context, res *subsystems.ResourceConfig) error {
+	stringFields := map[string]*string{
+		"m":                  &res.MemoryLimit,
+		"memory-swap":        &res.MemorySwap,
+		"memory-reservation": &res.MemoryReservation,
+		"cpushare":           &res.CpuShare,
+		"cpuset":             &res.CpuSet,
+		"cpus":               &res.Cpus,
+		"cpu-quota":          &res.CpuQuota,
+		"cpu-period":         &res.CpuPeriod,
+		"pids-limit":         &res.PidsLimit,
+		"blkio-weight":       &res.BlkioWeight,
+	}
+	for name, field := range stringFields {
+		if context.IsSet(name) {
+			*field = context.String(name)
+		}
+	}
+	sliceFields := map[string]*[]string{
+		"device-read-bps":   &res.DeviceReadBps,
+		"device-write-bps":  &res.DeviceWriteBps,
+		"device-read-iops":  &res.DeviceReadIOps,
+		"device-write-iops": &res.DeviceWriteIOps,
+		"hugetlb-limit":     &res.HugetlbLimit,
+	}
+	for name, field := range sliceFields {
+		if context.IsSet(name) {
+			*field = context.StringSlice(name)
+		}
+	}
+	//--cpus和--cpu-quota互斥，更新其中一个时清除另一个
+	if context.IsSet("cpus") && !context.IsSet("cpu-quota") {
+		res.CpuQuota = ""
+	}
+	if context.IsSet("cpu-quota") && !context.IsSet("cpus") {
+		res.Cpus = ""
+	}
+	if _, _, err := subsystems.ParseCpuLimit(res); err != nil {
+		return err
+	}
+	return nil
+}
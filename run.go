@@ -4,124 +4,251 @@ import (
 	"TinyDocker/cgroup"
 	subsystems "TinyDocker/cgroup/subsystem"
 	"TinyDocker/container"
+	"TinyDocker/image"
 	"TinyDocker/network"
-	"encoding/json"
+	"TinyDocker/storage"
 	"fmt"
 	log "github.com/sirupsen/logrus"
 	"math/rand"
 	"os"
+	"os/exec"
+	"path"
 	"strconv"
 	"strings"
 	"time"
 )
 
+// 容器启动之前出错时run的退出码，和docker一样为125
+const runErrorExitCode = 125
+
+// 创建并启动容器，返回run命令的退出码，使用-ti时为容器的退出码
 func Run(tty bool, comArray []string, res *subsystems.ResourceConfig, containerName, volume, imageName string,
-	envSlice []string, nw string, portmapping []string) {
+	envSlice []string, nw string, portmapping []string, shmSize int64, deviceSpecs []string, useInit bool,
+	stopSignal string, restartPolicy container.RestartPolicy) int {
 	containerID := randStringBytes(10)
 	if containerName == "" {
 		containerName = containerID
 	}
 
-	parent, writePipe := container.
-		NewParentProcess(tty, containerName, volume, imageName, envSlice)
+	//镜像不在镜像存储中时先导入，再读取镜像配置
+	if err := container.CreateReadOnlyLayer(imageName, storage.DefaultDriver); err != nil {
+		log.Errorf("Create image %s error %v", imageName, err)
+		return runErrorExitCode
+	}
+	img, err := image.GetImage(imageName)
+	if err != nil {
+		log.Errorf("Get image %s error %v", imageName, err)
+		return runErrorExitCode
+	}
+	config := img.Config.Config
+	//没有指定命令时使用镜像的Cmd
+	if len(config.Command(comArray)) == 0 {
+		log.Errorf("No command specified and image %s has no Entrypoint or Cmd", imageName)
+		return runErrorExitCode
+	}
+	if len(comArray) == 0 {
+		comArray = config.Cmd
+	}
+	//没有指定停止信号时使用镜像配置的StopSignal
+	if stopSignal == "" {
+		stopSignal = config.StopSignal
+	}
+	//--device指定的设备需要在devices cgroup中允许访问
+	for _, deviceSpec := range deviceSpecs {
+		device, err := container.ParseDevice(deviceSpec)
+		if err != nil {
+			log.Errorf("Parse device error %v", err)
+			return runErrorExitCode
+		}
+		res.DeviceRules = append(res.DeviceRules, device.CgroupRule())
+	}
+	//cgroup v2中没有devices控制器，设备的访问控制需要挂载eBPF程序，目前没有实现
+	if len(deviceSpecs) > 0 && subsystems.CurrentMode() == subsystems.CgroupModeUnified {
+		log.Warnf("Device access control is not supported on cgroup v2, --device only creates the device nodes and access to devices is not restricted")
+	}
+
+	containerInfo := &container.ContainerInfo{
+		Id:          containerID,
+		Name:        containerName,
+		Command:     strings.Join(append(append([]string{}, config.Entrypoint...), comArray...), " "),
+		CreatedTime: time.Now().Format("2006-01-02 15:04:05"),
+		Status:      container.CREATED,
+		Volume:      volume,
+		PortMapping: portmapping,
+		//每个容器使用独立的cgroup，生命周期和容器一致，rm容器时才会删除
+		CgroupPath:     path.Join(container.CgroupParent, containerID),
+		ResourceConfig: res,
+		StorageDriver:  storage.DefaultDriver.Name(),
+		ImageName:      imageName,
+		ImageId:        img.Id,
+		Entrypoint:     config.Entrypoint,
+		Cmd:            comArray,
+		Env:            envSlice,
+		ShmSize:        shmSize,
+		Devices:        deviceSpecs,
+		Network:        nw,
+		Init:           useInit,
+		StopSignal:     stopSignal,
+		RestartPolicy:  restartPolicy,
+	}
+	if err := recordContainerInfo(containerInfo); err != nil {
+		log.Errorf("Record container info error %v", err)
+		return runErrorExitCode
+	}
+
+	//使用detach创建的容器交给shim进程启动和监控，CLI可以直接退出
+	if !tty {
+		if err := startShim(containerName); err != nil {
+			log.Errorf("Start container %s error %v", containerName, err)
+			cleanupContainer(containerInfo)
+			return runErrorExitCode
+		}
+		return 0
+	}
+
+	//使用tty时，父进程需要等待子进程结束
+	parent, console, err := startContainer(containerInfo, true)
+	if err != nil {
+		log.Errorf("Start container %s error %v", containerName, err)
+		cleanupContainer(containerInfo)
+		return runErrorExitCode
+	}
+	if err := container.ProxyConsole(console); err != nil {
+		log.Errorf("Proxy console error %v", err)
+	}
+	//CLI的退出码和容器的退出码一致
+	exitCode := waitExitCode(parent)
+	//和detach的容器一样保留容器信息和rootfs，wait和inspect可以读取退出码，rm时才删除
+	if err := recordContainerExit(containerName, exitCode, container.Exit); err != nil {
+		log.Errorf("Record container %s exit error %v", containerName, err)
+	}
+	disconnectContainer(containerInfo)
+	return exitCode
+}
+
+/*
+根据容器信息创建容器进程：挂载rootfs，启动init进程，设置cgroup和网络，最后把init spec发送给init进程
+tty为true时返回容器中pty的master，run -ti和shim都通过这里启动容器
+*/
+func startContainer(containerInfo *container.ContainerInfo, tty bool) (*exec.Cmd, *os.File, error) {
+	img, err := image.GetImage(containerInfo.ImageId)
+	if err != nil {
+		return nil, nil, err
+	}
+	config := img.Config.Config
+	//-e指定的环境变量覆盖镜像中同名的变量
+	env := image.MergeEnv(config.Env, containerInfo.Env)
+	var devices []container.Device
+	for _, deviceSpec := range containerInfo.Devices {
+		device, err := container.ParseDevice(deviceSpec)
+		if err != nil {
+			return nil, nil, err
+		}
+		devices = append(devices, *device)
+	}
+
+	parent, writePipe := container.NewParentProcess(tty, containerInfo.Name, containerInfo.Volume, containerInfo.ImageId)
 	if parent == nil {
-		log.Errorf("New parent process error")
-		return
+		return nil, nil, fmt.Errorf("new parent process error")
+	}
+	defer writePipe.Close()
+	//容器的rootfs已经挂载，可以在其中查找镜像配置的用户
+	args := append(append([]string{}, containerInfo.Entrypoint...), containerInfo.Cmd...)
+	spec, err := container.NewInitSpec(parent.Dir, containerInfo.Id, tty, args, env, &config)
+	if err != nil {
+		return nil, nil, fmt.Errorf("create init spec error %v", err)
+	}
+	if containerInfo.ShmSize > 0 {
+		spec.SetShmSize(containerInfo.ShmSize)
+	}
+	spec.Devices = append(spec.Devices, devices...)
+	spec.Init = containerInfo.Init
+	//使用-ti时init进程在容器中分配pty，通过socket把master发送回来
+	var consoleSocket, consoleChild *os.File
+	if tty {
+		if consoleSocket, consoleChild, err = container.NewConsoleSocket(parent); err != nil {
+			return nil, nil, err
+		}
+		defer consoleSocket.Close()
 	}
 	//真正开始前面创建好的command调用,clone一个namespace隔离的进程
 	//然后在子进程中调用/proc/self/exe,也就是调用自己，调用init方法区初始化容器的一些资源
-	if err := parent.Start(); err != nil {
-		log.Error(err)
+	err = parent.Start()
+	//init进程启动后关闭子进程的一端，init进程出错退出时接收pty会立即返回
+	if consoleChild != nil {
+		consoleChild.Close()
 	}
-
-	//record container info
-	containerName, err := recordContainerInfo(parent.Process.Pid, comArray, containerName, containerID, volume)
 	if err != nil {
-		log.Errorf("Record container info error %v", err)
-		return
+		return nil, nil, err
+	}
+
+	containerInfo.Pid = strconv.Itoa(parent.Process.Pid)
+	containerInfo.ProcessEnv = spec.Env
+	containerInfo.ProcessCwd = spec.Cwd
+	containerInfo.ProcessUid, containerInfo.ProcessGid, containerInfo.ProcessGroups = spec.Uid, spec.Gid, spec.AdditionalGids
+	containerInfo.Status = container.RUNNING
+	containerInfo.StartedTime = time.Now().Format("2006-01-02 15:04:05")
+	if err := updateContainerInfo(containerInfo.Name, containerInfo); err != nil {
+		parent.Process.Kill()
+		parent.Wait()
+		return nil, nil, err
 	}
 
 	// 创建cgroupManager ，设置资源限制并使限制在容器上生效
-	cgroupManager := cgroup.NewCgroupManager("mydocker-cgroup")
-	defer cgroupManager.Destroy()
-	cgroupManager.Set(res)
+	cgroupManager := cgroup.NewCgroupManager(containerInfo.CgroupPath)
+	if err := cgroupManager.Set(containerInfo.ResourceConfig); err != nil {
+		log.Warnf("Container %s: %v", containerInfo.Name, err)
+	}
 	cgroupManager.Apply(parent.Process.Pid)
 
-	if nw != "" {
+	if containerInfo.Network != "" {
 		// config container network
 		network.Init()
-		containerInfo := &container.ContainerInfo{
-			Id:          containerID,
-			Pid:         strconv.Itoa(parent.Process.Pid),
-			Name:        containerName,
-			PortMapping: portmapping,
+		if err := network.Connect(containerInfo.Network, containerInfo); err != nil {
+			parent.Process.Kill()
+			parent.Wait()
+			network.Disconnect(containerInfo.Network, containerInfo)
+			return nil, nil, fmt.Errorf("connect network %s error %v", containerInfo.Network, err)
 		}
-		if err := network.Connect(nw, containerInfo); err != nil {
-			log.Errorf("Error Connect Network %v", err)
-			return
+		//记录分配的IP，重新启动时继续使用
+		if err := updateContainerInfo(containerInfo.Name, containerInfo); err != nil {
+			log.Errorf("Update container %s info error %v", containerInfo.Name, err)
 		}
 	}
-	//发送用户命令
-	sendInitCommand(comArray, writePipe)
-
-	//使用tty时，父进程需要等待子进程结束
-	//如果使用detach创建了容器，就不能再等待，可以直接退出
-	if tty {
+	//发送用户命令和容器配置
+	log.Infof("command all is %q", spec.Args)
+	if err := container.SendInitSpec(spec, writePipe); err != nil {
+		parent.Process.Kill()
 		parent.Wait()
-		deleteContainerInfo(containerName)
-		container.DeleteWorkSpace(volume, containerName)
+		return nil, nil, err
 	}
-
-}
-
-func sendInitCommand(comArray []string, writePipe *os.File) {
-	command := strings.Join(comArray, " ")
-	log.Infof("command all is %s", command)
-	writePipe.WriteString(command)
-	writePipe.Close()
-}
-
-func recordContainerInfo(containerPID int, commandArray []string, containerName, id, volume string) (string, error) {
-	createTime := time.Now().Format("2006-01-02 15:04:05")
-	command := strings.Join(commandArray, "")
-	containerInfo := &container.ContainerInfo{
-		Id:          id,
-		Pid:         strconv.Itoa(containerPID),
-		Command:     command,
-		CreatedTime: createTime,
-		Status:      container.RUNNING,
-		Name:        containerName,
-		Volume:      volume,
+	if !tty {
+		return parent, nil, nil
 	}
-	//将容器信息序列化为字符串
-	jsonBytes, err := json.Marshal(containerInfo)
+	console, err := container.RecvConsole(consoleSocket)
 	if err != nil {
-		log.Errorf("Record container info error %v", err)
-		return "", err
+		parent.Wait()
+		return nil, nil, err
 	}
-	jsonStr := string(jsonBytes)
+	return parent, console, nil
+}
 
+// 保存新建的容器信息
+func recordContainerInfo(containerInfo *container.ContainerInfo) error {
 	//拼凑存储容器信息的路径
-	dirUrl := fmt.Sprintf(container.DefaultInfoLocation, containerName)
+	dirUrl := fmt.Sprintf(container.DefaultInfoLocation, containerInfo.Name)
 	if err := os.MkdirAll(dirUrl, 0622); err != nil {
-		log.Errorf("Mkdir error %s error %v", dirUrl, err)
-		return "", err
+		return fmt.Errorf("mkdir %s error %v", dirUrl, err)
 	}
-	fileName := dirUrl + "/" + container.ConfigName
 	//最终创建出最终的配置文件（config.json）
-	file, err := os.Create(fileName)
-	defer file.Close()
-	if err != nil {
-		log.Errorf("Create file %s error %v", fileName, err)
-		return "", err
-	}
-	//将json画后的数据写入文件中
-	if _, err := file.WriteString(jsonStr); err != nil {
-		log.Errorf("File write string error %v", err)
-		return "", err
-	}
+	return updateContainerInfo(containerInfo.Name, containerInfo)
+}
 
-	return containerName, nil
+// 删除启动失败的容器
+func cleanupContainer(containerInfo *container.ContainerInfo) {
+	deleteContainerInfo(containerInfo.Name)
+	container.DeleteWorkSpace(containerInfo.Volume, containerInfo.Name, containerInfo.StorageDriver)
+	cgroup.NewCgroupManager(containerInfo.CgroupPath).Destroy()
 }
 
 func deleteContainerInfo(containerId string) {
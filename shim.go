@@ -0,0 +1,343 @@
+package main
+
+import (
+	"TinyDocker/container"
+	"TinyDocker/network"
+	"bufio"
+	"encoding/json"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+	"net"
+	"os"
+	"os/exec"
+	"path"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	// shim的控制socket，保存在容器信息的目录下
+	ShimSocketName = "shim.sock"
+	// shim进程自己的日志
+	ShimLogFile = "shim.log"
+	// shim通过fd 3通知CLI容器是否启动成功
+	shimReadyFd = 3
+)
+
+// shim启动容器后通过管道发送给CLI的结果
+type shimReady struct {
+	Pid   int    `json:"pid"`
+	Error string `json:"error,omitempty"`
+}
+
+// 通过控制socket发送给shim的请求，Action为state、signal或stop，stop发送信号并在容器退出后记录为stopped
+type shimRequest struct {
+	Action string `json:"action"`
+	Signal int    `json:"signal,omitempty"`
+}
+
+type shimResponse struct {
+	Pid    int    `json:"pid"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+/*
+启动容器的shim进程，shim使用新的会话运行，CLI退出后继续监控容器
+等待shim启动容器后返回，容器启动失败时返回shim报告的错误
+*/
+func startShim(containerName string) error {
+	dirURL := fmt.Sprintf(container.DefaultInfoLocation, containerName)
+	logFile, err := os.OpenFile(path.Join(dirURL, ShimLogFile), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer logFile.Close()
+	readPipe, writePipe, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer readPipe.Close()
+
+	cmd := exec.Command("/proc/self/exe", "shim", containerName)
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	cmd.ExtraFiles = []*os.File{writePipe}
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	err = cmd.Start()
+	writePipe.Close()
+	if err != nil {
+		return fmt.Errorf("start shim error %v", err)
+	}
+	//shim会一直运行，不需要等待它退出
+	defer cmd.Process.Release()
+
+	var ready shimReady
+	if err := json.NewDecoder(readPipe).Decode(&ready); err != nil {
+		return fmt.Errorf("shim exited before container started, see %s", path.Join(dirURL, ShimLogFile))
+	}
+	if ready.Error != "" {
+		return fmt.Errorf("%s", ready.Error)
+	}
+	log.Infof("Container %s started with pid %d by shim %d", containerName, ready.Pid, cmd.Process.Pid)
+	return nil
+}
+
+// 重启容器前等待的时间，从restartBackoffMin开始每次加倍，最多为restartBackoffMax
+// 容器运行超过restartBackoffReset后退出时重新从restartBackoffMin开始
+const (
+	restartBackoffMin   = 100 * time.Millisecond
+	restartBackoffMax   = time.Minute
+	restartBackoffReset = 10 * time.Second
+)
+
+// shim中和控制socket共享的状态
+type shimState struct {
+	mu      sync.Mutex
+	pid     int           //当前运行的容器进程，等待重启时为0
+	stopped bool          //通过stop停止，容器退出后不再重启
+	stopCh  chan struct{} //stop时关闭，打断重启前的等待
+}
+
+// 记录新启动的容器进程，返回是否已经被stop
+func (s *shimState) setPid(pid int) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pid = pid
+	return s.stopped
+}
+
+// 等待容器进程退出并返回退出码
+// 先用WNOWAIT等待进程退出但不回收，在锁内把pid清零后再回收
+// 回收之前进程是僵尸进程，PID不会被复用，serveShim不会把信号发给复用这个PID的其他进程
+func (s *shimState) waitExit(cmd *exec.Cmd) int {
+	var info unix.Siginfo
+	for {
+		if err := unix.Waitid(unix.P_PID, cmd.Process.Pid, &info, unix.WEXITED|unix.WNOWAIT, nil); err != unix.EINTR {
+			break
+		}
+	}
+	s.setPid(0)
+	return waitExitCode(cmd)
+}
+
+func (s *shimState) isStopped() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stopped
+}
+
+/*
+shim进程的入口，shim是容器init进程的父进程：
+1. 启动容器，通过fd 3告诉CLI启动的结果
+2. 在控制socket上处理state、signal和stop请求
+3. 等待容器退出，记录退出码和退出时间，并执行清理
+4. 按照容器的重启策略等待一段时间后重新启动容器，通过stop停止的容器不会重启
+*/
+func runShim(containerName string) error {
+	ready := os.NewFile(uintptr(shimReadyFd), "ready")
+	syscall.CloseOnExec(shimReadyFd)
+	reportReady := func(result shimReady) {
+		json.NewEncoder(ready).Encode(result)
+		ready.Close()
+	}
+
+	containerInfo, err := getContainerInfoByName(containerName)
+	if err != nil {
+		reportReady(shimReady{Error: err.Error()})
+		return err
+	}
+	//shim的标准输出是日志文件，容器的输出仍然写到container.log
+	//启动失败时恢复之前的容器信息，重新启动失败的容器保持原来的状态
+	origInfo := *containerInfo
+	//通过run和start启动时重新开始计算重启次数
+	containerInfo.RestartCount = 0
+	parent, _, err := startContainer(containerInfo, false)
+	if err != nil {
+		updateContainerInfo(containerName, &origInfo)
+		reportReady(shimReady{Error: err.Error()})
+		return err
+	}
+	containerInfo.ShimPid = strconv.Itoa(os.Getpid())
+	if err := updateContainerInfo(containerName, containerInfo); err != nil {
+		log.Errorf("Update container %s info error %v", containerName, err)
+	}
+
+	state := &shimState{pid: parent.Process.Pid, stopCh: make(chan struct{})}
+	socketPath := path.Join(fmt.Sprintf(container.DefaultInfoLocation, containerName), ShimSocketName)
+	os.Remove(socketPath)
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		log.Errorf("Listen on %s error %v", socketPath, err)
+	} else {
+		go serveShim(listener, state)
+	}
+	reportReady(shimReady{Pid: parent.Process.Pid})
+
+	backoff := restartBackoffMin
+	var exitCode int
+	for {
+		startedAt := time.Now()
+		exitCode = state.waitExit(parent)
+		log.Infof("Container %s exited with code %d", containerName, exitCode)
+		//每次退出都断开网络，重新启动时再连接
+		disconnectContainer(containerInfo)
+
+		containerInfo, err = getContainerInfoByName(containerName)
+		if err != nil {
+			break
+		}
+		if state.isStopped() || !containerInfo.RestartPolicy.ShouldRestart(exitCode, containerInfo.RestartCount) {
+			break
+		}
+		if err := recordContainerExit(containerName, exitCode, container.RESTARTING); err != nil {
+			log.Errorf("Record container %s exit error %v", containerName, err)
+		}
+		if time.Since(startedAt) >= restartBackoffReset {
+			backoff = restartBackoffMin
+		}
+		log.Infof("Restart container %s in %v", containerName, backoff)
+		select {
+		case <-time.After(backoff):
+		case <-state.stopCh:
+		}
+		if state.isStopped() {
+			break
+		}
+		if backoff *= 2; backoff > restartBackoffMax {
+			backoff = restartBackoffMax
+		}
+
+		//重新读取容器信息，update等命令的修改在重启后生效
+		if containerInfo, err = getContainerInfoByName(containerName); err != nil {
+			break
+		}
+		containerInfo.RestartCount++
+		if parent, _, err = startContainer(containerInfo, false); err != nil {
+			log.Errorf("Restart container %s error %v", containerName, err)
+			break
+		}
+		//重启的同时收到了stop，直接杀掉新启动的进程
+		if state.setPid(parent.Process.Pid) {
+			parent.Process.Kill()
+		}
+	}
+
+	//清理：关闭控制socket
+	if listener != nil {
+		listener.Close()
+		os.Remove(socketPath)
+	}
+	status := container.Exit
+	if state.isStopped() {
+		status = container.STOP
+	}
+	return recordContainerExit(containerName, exitCode, status)
+}
+
+// 断开容器的网络，释放IP
+func disconnectContainer(containerInfo *container.ContainerInfo) {
+	if containerInfo.Network == "" {
+		return
+	}
+	network.Init()
+	if err := network.Disconnect(containerInfo.Network, containerInfo); err != nil {
+		log.Errorf("Disconnect network %s error %v", containerInfo.Network, err)
+	}
+}
+
+// 等待容器进程退出，返回退出码，被信号杀死时为128加信号值
+func waitExitCode(cmd *exec.Cmd) int {
+	cmd.Wait()
+	if cmd.ProcessState == nil {
+		return -1
+	}
+	status, ok := cmd.ProcessState.Sys().(syscall.WaitStatus)
+	if !ok {
+		return cmd.ProcessState.ExitCode()
+	}
+	if status.Signaled() {
+		return 128 + int(status.Signal())
+	}
+	return status.ExitStatus()
+}
+
+// 把容器的退出码、退出时间和状态写入config.json，status为exited、stopped或restarting
+func recordContainerExit(containerName string, exitCode int, status string) error {
+	containerInfo, err := getContainerInfoByName(containerName)
+	if err != nil {
+		return err
+	}
+	containerInfo.Status = status
+	containerInfo.Pid = " "
+	if status != container.RESTARTING {
+		containerInfo.ShimPid = ""
+	}
+	containerInfo.ExitCode = exitCode
+	containerInfo.FinishedTime = time.Now().Format("2006-01-02 15:04:05")
+	return updateContainerInfo(containerName, containerInfo)
+}
+
+// 处理控制socket上的请求，每个连接一个请求
+func serveShim(listener net.Listener, state *shimState) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		go func(conn net.Conn) {
+			defer conn.Close()
+			var req shimRequest
+			if err := json.NewDecoder(bufio.NewReader(conn)).Decode(&req); err != nil {
+				return
+			}
+			state.mu.Lock()
+			resp := shimResponse{Pid: state.pid, Status: container.RUNNING}
+			if state.pid == 0 {
+				resp.Status = container.RESTARTING
+			}
+			switch req.Action {
+			case "state":
+			case "signal", "stop":
+				if req.Action == "stop" && !state.stopped {
+					state.stopped = true
+					close(state.stopCh)
+				}
+				//等待重启时没有需要发送信号的进程
+				if state.pid != 0 {
+					if err := syscall.Kill(state.pid, syscall.Signal(req.Signal)); err != nil {
+						resp.Error = err.Error()
+					}
+				}
+			default:
+				resp.Error = fmt.Sprintf("unknown action %s", req.Action)
+			}
+			state.mu.Unlock()
+			json.NewEncoder(conn).Encode(resp)
+		}(conn)
+	}
+}
+
+// 通过控制socket向容器的shim发送请求
+func requestShim(containerName string, req shimRequest) (*shimResponse, error) {
+	socketPath := path.Join(fmt.Sprintf(container.DefaultInfoLocation, containerName), ShimSocketName)
+	conn, err := net.DialTimeout("unix", socketPath, 5*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return nil, err
+	}
+	var resp shimResponse
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return nil, err
+	}
+	if resp.Error != "" {
+		return &resp, fmt.Errorf("%s", resp.Error)
+	}
+	return &resp, nil
+}
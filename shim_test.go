@@ -0,0 +1,30 @@
+package main
+
+import (
+	"os/exec"
+	"testing"
+)
+
+func TestShimWaitExit(t *testing.T) {
+	tests := []struct {
+		script string
+		want   int
+	}{
+		{script: "exit 3", want: 3},
+		{script: "kill -KILL $$", want: 137},
+	}
+	for _, tt := range tests {
+		cmd := exec.Command("sh", "-c", tt.script)
+		if err := cmd.Start(); err != nil {
+			t.Fatal(err)
+		}
+		state := &shimState{pid: cmd.Process.Pid}
+		if got := state.waitExit(cmd); got != tt.want {
+			t.Errorf("waitExit(%q) = %d, want %d", tt.script, got, tt.want)
+		}
+		//回收之后pid必须已经清零
+		if state.pid != 0 {
+			t.Errorf("waitExit(%q) left pid %d", tt.script, state.pid)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"TinyDocker/container"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+/*
+重新启动已经停止的容器，容器的config.json、可写层和挂载点都还保留着
+由shim重新创建namespace和cgroup，挂载可写层，连接网络并运行容器记录的命令
+attach为true时输出容器的日志直到容器退出
+*/
+func startStoppedContainer(containerName string, attach bool) error {
+	containerInfo, err := getContainerInfoByName(containerName)
+	if err != nil {
+		return fmt.Errorf("get container %s info error %v", containerName, err)
+	}
+	if _, err := requestShim(containerName, shimRequest{Action: "state"}); containerInfo.Status == container.RUNNING || err == nil {
+		return fmt.Errorf("container %s is already running", containerName)
+	}
+	//从启动前日志的末尾开始输出
+	logFilePath := fmt.Sprintf(container.DefaultInfoLocation, containerName) + container.ContainerLogFile
+	var offset int64
+	if stat, err := os.Stat(logFilePath); err == nil {
+		offset = stat.Size()
+	}
+	if err := startShim(containerName); err != nil {
+		return fmt.Errorf("start container %s error %v", containerName, err)
+	}
+	if attach {
+		attachContainer(containerName, logFilePath, offset)
+	}
+	return nil
+}
+
+// 先停止运行中的容器，再重新启动
+func restartContainer(containerName string, timeout int) error {
+	containerInfo, err := getContainerInfoByName(containerName)
+	if err != nil {
+		return fmt.Errorf("get container %s info error %v", containerName, err)
+	}
+	if containerInfo.Status == container.RUNNING || containerInfo.Status == container.RESTARTING {
+		if err := stopContainer(containerName, timeout); err != nil {
+			return err
+		}
+	}
+	if err := startStoppedContainer(containerName, false); err != nil {
+		return fmt.Errorf("restart container %s error %v", containerName, err)
+	}
+	return nil
+}
+
+// 从offset开始持续输出容器的日志，收到的SIGINT和SIGTERM转发给容器，容器退出后返回
+func attachContainer(containerName, logFilePath string, offset int64) {
+	containerInfo, err := getContainerInfoByName(containerName)
+	if err != nil {
+		log.Errorf("Get container %s info error %v", containerName, err)
+		return
+	}
+	file, err := os.Open(logFilePath)
+	if err != nil {
+		log.Errorf("Open log file %s error %v", logFilePath, err)
+		return
+	}
+	defer file.Close()
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		log.Errorf("Seek log file %s error %v", logFilePath, err)
+		return
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	go func() {
+		for sig := range signals {
+			if err := signalContainer(containerInfo, sig.(syscall.Signal), false); err != nil {
+				log.Errorf("Forward signal %v to container %s error %v", sig, containerName, err)
+			}
+		}
+	}()
+	for {
+		//先判断是否退出再输出，保证退出前写入的日志都能输出
+		stopped := isContainerStopped(containerInfo)
+		if _, err := io.Copy(os.Stdout, file); err != nil {
+			log.Errorf("Read log file %s error %v", logFilePath, err)
+			return
+		}
+		if stopped {
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
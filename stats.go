@@ -0,0 +1,186 @@
+package main
+
+import (
+	"TinyDocker/cgroup"
+	"TinyDocker/container"
+	"TinyDocker/network"
+	"encoding/json"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+// 两次采样之间的间隔，CPU使用率根据这段时间内的CPU时间增量计算
+const statsInterval = time.Second
+
+// 某个时刻容器的资源使用情况
+type containerStats struct {
+	Id          string  `json:"id"`
+	Name        string  `json:"name"`
+	CpuPercent  float64 `json:"cpuPercent"`
+	MemoryUsage uint64  `json:"memoryUsage"`
+	MemoryLimit uint64  `json:"memoryLimit"`
+	MemPercent  float64 `json:"memoryPercent"`
+	NetworkRx   uint64  `json:"networkRx"`
+	NetworkTx   uint64  `json:"networkTx"`
+	BlkioRead   uint64  `json:"blkioRead"`
+	BlkioWrite  uint64  `json:"blkioWrite"`
+	Pids        uint64  `json:"pids"`
+
+	cpuUsage uint64
+	readTime time.Time
+}
+
+// 打印容器的资源使用情况，没有指定容器时统计所有运行中的容器
+// noStream为true时只采样一次并以JSON格式输出，否则像top一样持续刷新
+// 日志改为输出到标准错误，避免混入JSON和刷新的表格
+func statsContainers(containerNames []string, noStream bool) error {
+	log.SetOutput(os.Stderr)
+	previous := make(map[string]*containerStats)
+	for {
+		containers, err := getStatsContainers(containerNames)
+		if err != nil {
+			return err
+		}
+		var current []*containerStats
+		for _, containerInfo := range containers {
+			stats, err := readContainerStats(containerInfo)
+			if err != nil {
+				log.Warnf("Read container %s stats error %v", containerInfo.Name, err)
+				continue
+			}
+			if prev, ok := previous[stats.Id]; ok {
+				stats.CpuPercent = cpuPercent(prev, stats)
+			}
+			current = append(current, stats)
+		}
+		//第一次采样没有可以比较的数据，等待一个间隔后再次采样
+		if len(previous) == 0 && len(current) > 0 {
+			for _, stats := range current {
+				previous[stats.Id] = stats
+			}
+			time.Sleep(statsInterval)
+			continue
+		}
+		previous = make(map[string]*containerStats)
+		for _, stats := range current {
+			previous[stats.Id] = stats
+		}
+
+		if noStream {
+			return printStatsJSON(current)
+		}
+		//清屏并把光标移动到左上角
+		fmt.Fprint(os.Stdout, "\033[2J\033[H")
+		printStatsTable(current)
+		time.Sleep(statsInterval)
+	}
+}
+
+// 获取需要统计的容器，没有指定时返回所有运行中的容器
+func getStatsContainers(containerNames []string) ([]*container.ContainerInfo, error) {
+	var containers []*container.ContainerInfo
+	if len(containerNames) == 0 {
+		all, err := getAllContainerInfo()
+		if err != nil {
+			return nil, err
+		}
+		for _, containerInfo := range all {
+			if containerInfo.Status == container.RUNNING {
+				containers = append(containers, containerInfo)
+			}
+		}
+		return containers, nil
+	}
+	for _, containerName := range containerNames {
+		containerInfo, err := getContainerInfoByName(containerName)
+		if err != nil {
+			return nil, fmt.Errorf("get container %s info error %v", containerName, err)
+		}
+		containers = append(containers, containerInfo)
+	}
+	return containers, nil
+}
+
+func readContainerStats(containerInfo *container.ContainerInfo) (*containerStats, error) {
+	if containerInfo.CgroupPath == "" {
+		return nil, fmt.Errorf("container %s has no cgroup", containerInfo.Name)
+	}
+	cgroupStats, err := cgroup.NewCgroupManager(containerInfo.CgroupPath).GetStats()
+	if err != nil {
+		return nil, err
+	}
+	stats := &containerStats{
+		Id:          containerInfo.Id,
+		Name:        containerInfo.Name,
+		MemoryUsage: cgroupStats.MemoryUsage,
+		MemoryLimit: cgroupStats.MemoryLimit,
+		BlkioRead:   cgroupStats.BlkioRead,
+		BlkioWrite:  cgroupStats.BlkioWrite,
+		Pids:        cgroupStats.PidsCurrent,
+		cpuUsage:    cgroupStats.CpuUsage,
+		readTime:    time.Now(),
+	}
+	if stats.MemoryLimit > 0 {
+		stats.MemPercent = float64(stats.MemoryUsage) / float64(stats.MemoryLimit) * 100
+	}
+	//没有连接网络的容器没有Veth设备，流量统计为0
+	if rx, tx, err := network.EndpointStats(containerInfo); err == nil {
+		stats.NetworkRx, stats.NetworkTx = rx, tx
+	}
+	return stats, nil
+}
+
+// CPU使用率为采样间隔内CPU时间的增量占时间间隔的比例，多核时可以超过100%
+func cpuPercent(prev, current *containerStats) float64 {
+	elapsed := current.readTime.Sub(prev.readTime).Nanoseconds()
+	if elapsed <= 0 || current.cpuUsage < prev.cpuUsage {
+		return 0
+	}
+	return float64(current.cpuUsage-prev.cpuUsage) / float64(elapsed) * 100
+}
+
+func printStatsTable(stats []*containerStats) {
+	w := tabwriter.NewWriter(os.Stdout, 12, 1, 3, ' ', 0)
+	fmt.Fprint(w, "ID\tNAME\tCPU %\tMEM USAGE / LIMIT\tMEM %\tNET I/O\tBLOCK I/O\tPIDS\n")
+	for _, item := range stats {
+		fmt.Fprintf(w, "%s\t%s\t%.2f%%\t%s / %s\t%.2f%%\t%s / %s\t%s / %s\t%d\n",
+			item.Id,
+			item.Name,
+			item.CpuPercent,
+			humanSize(item.MemoryUsage), humanSize(item.MemoryLimit),
+			item.MemPercent,
+			humanSize(item.NetworkRx), humanSize(item.NetworkTx),
+			humanSize(item.BlkioRead), humanSize(item.BlkioWrite),
+			item.Pids)
+	}
+	if err := w.Flush(); err != nil {
+		log.Errorf("Flush error %v", err)
+	}
+}
+
+func printStatsJSON(stats []*containerStats) error {
+	if stats == nil {
+		stats = []*containerStats{}
+	}
+	content, err := json.MarshalIndent(stats, "", "    ")
+	if err != nil {
+		return fmt.Errorf("json marshal stats error %v", err)
+	}
+	fmt.Fprintln(os.Stdout, string(content))
+	return nil
+}
+
+// 将字节数格式化为可读的形式，如 12.50MiB
+func humanSize(size uint64) string {
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB"}
+	value := float64(size)
+	i := 0
+	for value >= 1024 && i < len(units)-1 {
+		value /= 1024
+		i++
+	}
+	return fmt.Sprintf("%.2f%s", value, units[i])
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"TinyDocker/cgroup"
 	"TinyDocker/container"
 	"encoding/json"
 	"fmt"
@@ -9,43 +10,111 @@ import (
 	"os"
 	"strconv"
 	"syscall"
+	"time"
 )
 
-func stopContainer(containerName string) {
-	//获取PID
-	pid, err := GetContainerPidByName(containerName)
+/*
+停止容器：
+1. 发送容器配置的停止信号，默认为SIGTERM
+2. 等待容器进程退出，超过timeout秒后发送SIGKILL
+3. 进程真正退出后才记录状态，由shim记录的容器由shim在回收进程后记录stopped，使用-ti运行的容器由run的CLI记录退出码
+正在等待重启的容器会直接停止，通过stop停止的容器不会按照重启策略重启
+*/
+func stopContainer(containerName string, timeout int) error {
+	containerInfo, err := getContainerInfoByName(containerName)
 	if err != nil {
-		log.Errorf("Get contaienr pid by name %s error %v", containerName, err)
-		return
+		return fmt.Errorf("get container %s info error %v", containerName, err)
 	}
-	pidInt, err := strconv.Atoi(pid)
-	if err != nil {
-		log.Errorf("Conver pid from string to int error %v", err)
-		return
+	if containerInfo.Status != container.RUNNING && containerInfo.Status != container.RESTARTING {
+		return fmt.Errorf("container %s is not running", containerName)
 	}
-	//调用kill发送信号给进程，通过传递syscall.SIGTERM信号，杀掉容器主进程
-	if err := syscall.Kill(pidInt, syscall.SIGTERM); err != nil {
-		log.Errorf("Stop container %s error %v", containerName, err)
-		return
+	stopSignal := syscall.SIGTERM
+	if containerInfo.StopSignal != "" {
+		if stopSignal, err = parseSignal(containerInfo.StopSignal); err != nil {
+			return fmt.Errorf("parse stop signal error %v", err)
+		}
 	}
-	//获取容器信息，修改状态为STOP，并覆盖之前的信息
-	containerInfo, err := getContainerInfoByName(containerName)
+	if err := signalContainer(containerInfo, stopSignal, true); err != nil {
+		return fmt.Errorf("stop container %s error %v", containerName, err)
+	}
+	if !waitContainerStop(containerInfo, time.Duration(timeout)*time.Second) {
+		log.Infof("Container %s did not stop in %d seconds, killing it", containerName, timeout)
+		if err := signalContainer(containerInfo, syscall.SIGKILL, true); err != nil {
+			return fmt.Errorf("kill container %s error %v", containerName, err)
+		}
+		if !waitContainerStop(containerInfo, stopKillTimeout) {
+			return fmt.Errorf("container %s did not exit after SIGKILL", containerName)
+		}
+	}
+	return nil
+}
+
+// 发送SIGKILL之后等待容器退出的时间
+const stopKillTimeout = 10 * time.Second
+
+/*
+给容器的主进程发送信号，有shim时通过shim的控制socket发送
+stop为true时shim会在容器退出后记录stopped状态，而不是exited
+*/
+func signalContainer(containerInfo *container.ContainerInfo, sig syscall.Signal, stop bool) error {
+	if containerInfo.ShimPid != "" {
+		action := "signal"
+		if stop {
+			action = "stop"
+		}
+		_, err := requestShim(containerInfo.Name, shimRequest{Action: action, Signal: int(sig)})
+		return err
+	}
+	pid, err := strconv.Atoi(containerInfo.Pid)
 	if err != nil {
-		log.Errorf("Get container %s info error %v", containerName, err)
-		return
+		return fmt.Errorf("conver pid %s to int error %v", containerInfo.Pid, err)
+	}
+	return syscall.Kill(pid, sig)
+}
+
+// 等待容器退出，超时返回false
+func waitContainerStop(containerInfo *container.ContainerInfo, timeout time.Duration) bool {
+	for deadline := time.Now().Add(timeout); ; time.Sleep(100 * time.Millisecond) {
+		if isContainerStopped(containerInfo) {
+			return true
+		}
+		if time.Now().After(deadline) {
+			return false
+		}
+	}
+}
+
+// 判断容器进程是否已经退出，有shim的容器要等shim记录完退出状态，等待重启的容器不算退出
+func isContainerStopped(containerInfo *container.ContainerInfo) bool {
+	if containerInfo.ShimPid != "" {
+		shimPid, _ := strconv.Atoi(containerInfo.ShimPid)
+		if syscall.Kill(shimPid, 0) == syscall.ESRCH {
+			return true
+		}
+		info, err := getContainerInfoByName(containerInfo.Name)
+		return err == nil && (info.Status == container.STOP || info.Status == container.Exit)
 	}
-	containerInfo.Status = container.STOP
-	containerInfo.Pid = " "
+	pid, _ := strconv.Atoi(containerInfo.Pid)
+	return syscall.Kill(pid, 0) == syscall.ESRCH
+}
+
+// 用新的容器信息覆盖config.json
+// shim和CLI会同时读写config.json，先写临时文件再rename，读到的总是完整的内容
+func updateContainerInfo(containerName string, containerInfo *container.ContainerInfo) error {
 	newContentBytes, err := json.Marshal(containerInfo)
 	if err != nil {
-		log.Errorf("Json marshal %s error %v", containerName, err)
-		return
+		return fmt.Errorf("json marshal %s error %v", containerName, err)
 	}
 	dirURL := fmt.Sprintf(container.DefaultInfoLocation, containerName)
 	configFilePath := dirURL + container.ConfigName
-	if err := ioutil.WriteFile(configFilePath, newContentBytes, 0622); err != nil {
-		log.Errorf("Write file %s error", configFilePath, err)
+	tmpFilePath := fmt.Sprintf("%s.%d.tmp", configFilePath, os.Getpid())
+	if err := ioutil.WriteFile(tmpFilePath, newContentBytes, 0622); err != nil {
+		return fmt.Errorf("write file %s error %v", tmpFilePath, err)
+	}
+	if err := os.Rename(tmpFilePath, configFilePath); err != nil {
+		return fmt.Errorf("rename %s error %v", tmpFilePath, err)
 	}
+	return nil
 }
 
 func getContainerInfoByName(containerName string) (*container.ContainerInfo, error) {
@@ -70,7 +139,8 @@ func removeContainer(containerName string) {
 		log.Errorf("Get container %s info error %v", containerName, err)
 		return
 	}
-	if containerInfo.Status != container.STOP {
+	//stop之后容器进程可能还没有退出，shim还在运行时不能删除
+	if _, err := requestShim(containerName, shimRequest{Action: "state"}); containerInfo.Status == container.RUNNING || err == nil {
 		log.Errorf("Couldn't remove running container")
 		return
 	}
@@ -79,5 +149,9 @@ func removeContainer(containerName string) {
 		log.Errorf("Remove file %s error %v", dirURL, err)
 		return
 	}
-	container.DeleteWorkSpace(containerInfo.Volume, containerName)
+	container.DeleteWorkSpace(containerInfo.Volume, containerName, containerInfo.StorageDriver)
+	//删除容器独立的cgroup
+	if containerInfo.CgroupPath != "" {
+		cgroup.NewCgroupManager(containerInfo.CgroupPath).Destroy()
+	}
 }
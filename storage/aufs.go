@@ -0,0 +1,66 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// 使用aufs叠加各层，只有打了aufs补丁的内核才支持
+type AufsDriver struct {
+}
+
+func (d *AufsDriver) Name() string {
+	return "aufs"
+}
+
+// aufs不需要workDir，可写层为rw分支，各只读层为ro+wh分支，表示分支中的whiteout文件生效
+func (d *AufsDriver) Mount(lowerDirs []string, writeDir, workDir, mountPoint string) error {
+	dirs := "dirs=" + writeDir + "=rw"
+	for _, lowerDir := range lowerDirs {
+		dirs += ":" + lowerDir + "=ro+wh"
+	}
+	if out, err := exec.Command("mount", "-t", "aufs", "-o", dirs, "none", mountPoint).CombinedOutput(); err != nil {
+		return fmt.Errorf("mount aufs to %s error %v %s", mountPoint, err, out)
+	}
+	return nil
+}
+
+func (d *AufsDriver) Unmount(mountPoint string) error {
+	if out, err := exec.Command("umount", mountPoint).CombinedOutput(); err != nil {
+		return fmt.Errorf("unmount %s error %v %s", mountPoint, err, out)
+	}
+	return nil
+}
+
+// aufs和镜像使用相同的whiteout格式，直接创建名字以.wh.开头的空文件
+func (d *AufsDriver) CreateWhiteout(path string) error {
+	name := filepath.Base(path)
+	if name != WhiteoutOpaqueDir && strings.TrimPrefix(name, WhiteoutPrefix) == "" {
+		return fmt.Errorf("invalid whiteout %s", path)
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0)
+	if err != nil {
+		return fmt.Errorf("create whiteout %s error %v", path, err)
+	}
+	return file.Close()
+}
+
+// 可写层中的whiteout已经是镜像的格式，.wh..wh.开头的其余文件是aufs内部使用的
+func (d *AufsDriver) Whiteout(path string, fi os.FileInfo) (string, bool) {
+	name := fi.Name()
+	if !strings.HasPrefix(name, WhiteoutPrefix) {
+		return "", false
+	}
+	if strings.HasPrefix(name, WhiteoutPrefix+WhiteoutPrefix) && name != WhiteoutOpaqueDir {
+		return "", true
+	}
+	return name, true
+}
+
+// aufs的不透明目录通过目录中的.wh..wh..opq文件标记，已经由Whiteout处理
+func (d *AufsDriver) IsOpaque(path string) bool {
+	return false
+}
@@ -0,0 +1,95 @@
+package storage
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+var (
+	drivers = map[string]Driver{}
+	// 当前使用的存储驱动，由Init根据参数或宿主机支持的文件系统选择
+	DefaultDriver Driver
+)
+
+// 存储驱动负责把镜像的只读层和容器的可写层叠加挂载成容器的rootfs
+type Driver interface {
+	//返回驱动的名字，如 overlay aufs
+	Name() string
+	//把lowerDirs(从最上层到最底层)和可写层writeDir叠加挂载到mountPoint，workDir是驱动需要的工作目录
+	Mount(lowerDirs []string, writeDir, workDir, mountPoint string) error
+	//卸载mountPoint
+	Unmount(mountPoint string) error
+	//在层目录中创建whiteout，path为tar中.wh.<name>或者.wh..wh..opq对应的绝对路径
+	CreateWhiteout(path string) error
+	//判断可写层中的文件是否是驱动格式的whiteout，是的话返回镜像中对应的whiteout文件名
+	//返回的文件名为空表示这是驱动内部使用的文件，不应该出现在镜像中
+	Whiteout(path string, fi os.FileInfo) (string, bool)
+	//判断可写层中的目录是否是不透明的，即下层同名目录的内容全部不可见
+	IsOpaque(path string) bool
+}
+
+const (
+	// 被删除文件的标记，.wh.<name> 表示下层的<name>在这一层被删除
+	WhiteoutPrefix = ".wh."
+	// 目录中存在这个文件时，表示下层同名目录的内容在这一层全部不可见
+	WhiteoutOpaqueDir = WhiteoutPrefix + WhiteoutPrefix + ".opq"
+)
+
+func init() {
+	for _, driver := range []Driver{&OverlayDriver{}, &AufsDriver{}} {
+		drivers[driver.Name()] = driver
+	}
+}
+
+// 选择存储驱动，name为空时优先使用overlay，内核不支持overlay时退回aufs
+func Init(name string) error {
+	if name == "" {
+		name = "aufs"
+		if supportsFilesystem("overlay") {
+			name = "overlay"
+		}
+	}
+	driver, err := GetDriver(name)
+	if err != nil {
+		return err
+	}
+	DefaultDriver = driver
+	return nil
+}
+
+// 通过名字获取存储驱动，name为空时返回当前使用的驱动
+func GetDriver(name string) (Driver, error) {
+	if name == "" {
+		if DefaultDriver == nil {
+			if err := Init(""); err != nil {
+				return nil, err
+			}
+		}
+		return DefaultDriver, nil
+	}
+	driver, ok := drivers[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown storage driver %s", name)
+	}
+	return driver, nil
+}
+
+// 通过/proc/filesystems判断内核是否支持某种文件系统
+func supportsFilesystem(fsType string) bool {
+	f, err := os.Open("/proc/filesystems")
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) > 0 && fields[len(fields)-1] == fsType {
+			return true
+		}
+	}
+	return false
+}
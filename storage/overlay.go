@@ -0,0 +1,77 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// 使用内核自带的overlayfs叠加各层
+type OverlayDriver struct {
+}
+
+func (d *OverlayDriver) Name() string {
+	return "overlay"
+}
+
+// lowerdir中越靠前的目录越在上层，upperdir为可写层，workdir必须和upperdir在同一个文件系统中
+func (d *OverlayDriver) Mount(lowerDirs []string, writeDir, workDir, mountPoint string) error {
+	if len(lowerDirs) == 0 {
+		return fmt.Errorf("overlay needs at least one lower dir")
+	}
+	if err := os.MkdirAll(workDir, 0700); err != nil {
+		return fmt.Errorf("mkdir work dir %s error %v", workDir, err)
+	}
+	options := fmt.Sprintf("lowerdir=%s,upperdir=%s,workdir=%s", strings.Join(lowerDirs, ":"), writeDir, workDir)
+	if err := syscall.Mount("overlay", mountPoint, "overlay", 0, options); err != nil {
+		return fmt.Errorf("mount overlay to %s error %v", mountPoint, err)
+	}
+	return nil
+}
+
+func (d *OverlayDriver) Unmount(mountPoint string) error {
+	if err := syscall.Unmount(mountPoint, 0); err != nil {
+		return fmt.Errorf("unmount %s error %v", mountPoint, err)
+	}
+	return nil
+}
+
+// overlay的whiteout是设备号为0/0的字符设备，不透明目录通过trusted.overlay.opaque属性标记
+func (d *OverlayDriver) CreateWhiteout(path string) error {
+	dir, name := filepath.Split(path)
+	if name == WhiteoutOpaqueDir {
+		if err := syscall.Setxattr(dir, "trusted.overlay.opaque", []byte("y"), 0); err != nil {
+			return fmt.Errorf("set opaque xattr on %s error %v", dir, err)
+		}
+		return nil
+	}
+	original := strings.TrimPrefix(name, WhiteoutPrefix)
+	if original == "" {
+		return fmt.Errorf("invalid whiteout %s", path)
+	}
+	target := filepath.Join(dir, original)
+	os.RemoveAll(target)
+	if err := syscall.Mknod(target, syscall.S_IFCHR, 0); err != nil {
+		return fmt.Errorf("create whiteout %s error %v", target, err)
+	}
+	return nil
+}
+
+// 设备号为0/0的字符设备是被删除的文件
+func (d *OverlayDriver) Whiteout(path string, fi os.FileInfo) (string, bool) {
+	if fi.Mode()&os.ModeCharDevice == 0 {
+		return "", false
+	}
+	if stat, ok := fi.Sys().(*syscall.Stat_t); ok && stat.Rdev == 0 {
+		return WhiteoutPrefix + fi.Name(), true
+	}
+	return "", false
+}
+
+func (d *OverlayDriver) IsOpaque(path string) bool {
+	value := make([]byte, 1)
+	n, err := syscall.Getxattr(path, "trusted.overlay.opaque", value)
+	return err == nil && n == 1 && value[0] == 'y'
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"TinyDocker/cgroup"
+	subsystems "TinyDocker/cgroup/subsystem"
+	"TinyDocker/container"
+	"fmt"
+	"github.com/urfave/cli"
+)
+
+// 修改运行中容器的资源限制，直接改写容器cgroup中的配置
+// 只改写配置有变化的subsystem，部分subsystem失败时也把实际生效的配置保存到config.json
+// update不会修改DeviceRules，devices不会被重写，避免重写期间容器短暂无法访问/dev/null等设备
+func updateContainer(containerName string, context *cli.Context) error {
+	containerInfo, err := getContainerInfoByName(containerName)
+	if err != nil {
+		return fmt.Errorf("get container %s info error %v", containerName, err)
+	}
+	if containerInfo.Status != container.RUNNING {
+		return fmt.Errorf("container %s is not running", containerName)
+	}
+	if containerInfo.CgroupPath == "" {
+		return fmt.Errorf("container %s has no cgroup", containerName)
+	}
+	//在原有配置的基础上修改，没有指定的限制保持不变
+	res := &subsystems.ResourceConfig{}
+	if containerInfo.ResourceConfig != nil {
+		*res = *containerInfo.ResourceConfig
+	}
+	if err := setResourceConfig(context, res); err != nil {
+		return err
+	}
+
+	cgroupManager := cgroup.NewCgroupManager(containerInfo.CgroupPath)
+	applied, setErr := cgroupManager.Update(containerInfo.ResourceConfig, res)
+	containerInfo.ResourceConfig = applied
+	if err := updateContainerInfo(containerName, containerInfo); err != nil {
+		return err
+	}
+	if setErr != nil {
+		return fmt.Errorf("update container %s cgroup error %v", containerName, setErr)
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"TinyDocker/container"
+	"fmt"
+	"time"
+)
+
+// 使用-ti运行的容器进程退出后，等待run的CLI记录退出码的时间
+const exitRecordTimeout = 5 * time.Second
+
+/*
+等待容器退出，返回容器的退出码，被信号杀死时为128加信号值
+退出码由shim或者run -ti的CLI在容器退出后写入config.json，等待重启的容器不算退出
+*/
+func waitContainer(containerName string) (int, error) {
+	containerInfo, err := getContainerInfoByName(containerName)
+	if err != nil {
+		return 0, err
+	}
+	if containerInfo.Status == container.RUNNING || containerInfo.Status == container.RESTARTING {
+		for !isContainerStopped(containerInfo) {
+			time.Sleep(100 * time.Millisecond)
+		}
+		//没有shim的容器在进程被CLI回收之后才记录退出码，CLI异常退出时不会一直等待
+		hasShim := containerInfo.ShimPid != ""
+		for deadline := time.Now().Add(exitRecordTimeout); ; time.Sleep(100 * time.Millisecond) {
+			if containerInfo, err = getContainerInfoByName(containerName); err != nil {
+				return 0, fmt.Errorf("container %s has been removed", containerName)
+			}
+			if hasShim || containerInfo.Status != container.RUNNING || time.Now().After(deadline) {
+				break
+			}
+		}
+	}
+	return containerInfo.ExitCode, nil
+}